@@ -0,0 +1,238 @@
+// Package client calls the bookmarks API. A Client keeps its session in a
+// cookie jar, like a browser.
+package client
+
+import (
+	"bookmarks/internal/structs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const REQUEST_TIMEOUT = 30 * time.Second
+
+// The API's types, usable outside this module. The client uses the /v2
+// routes.
+type (
+	BookmarkSetupResponse        = structs.BookmarkSetupResponse
+	SessionBookmarksResponse     = structs.SessionBookmarksResponse
+	BookmarksResponse            = structs.BookmarksResponse
+	EventSelectionCountsResponse = structs.EventSelectionCountsResponse
+	Attendance                   = structs.Attendance
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrUnauthorized = errors.New("no session")
+
+// StatusError is returned for unsuccessful responses. It matches
+// ErrNotFound and ErrUnauthorized with errors.Is.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+	// Message is the error message of a JSON error body.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected http status %d: %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// Client calls the API at a base URL, e.g. https://example.com/api. Each
+// Client has its own session, so use one per user.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New returns a client for the API at baseURL. httpClient may be nil; if it
+// has no cookie jar, a copy with a new jar is used.
+func New(baseURL string, httpClient *http.Client) (*Client, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, err
+	}
+
+	var httpCopy http.Client
+	if httpClient != nil {
+		httpCopy = *httpClient
+	} else {
+		httpCopy.Timeout = REQUEST_TIMEOUT
+	}
+	if httpCopy.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		httpCopy.Jar = jar
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &httpCopy,
+	}, nil
+}
+
+// Setup sets up the client's session for a schedule and returns its ID. If
+// sessionId is not empty, that session is continued, e.g. from another
+// device; if it is invalid, a new session is started.
+func (c *Client) Setup(ctx context.Context, scheduleId string, sessionId string) (string, error) {
+	var body any
+	if sessionId != "" {
+		body = structs.BookmarkSetupRequest{SessionID: sessionId}
+	}
+
+	var resp BookmarkSetupResponse
+	if err := c.do(ctx, "PUT", schedulePath(scheduleId, "setup-bookmarks"), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.SessionID, nil
+}
+
+// GetBookmarks returns the session's bookmarked events. Without a session,
+// there are none.
+func (c *Client) GetBookmarks(ctx context.Context, scheduleId string) (*SessionBookmarksResponse, error) {
+	var resp SessionBookmarksResponse
+	if err := c.do(ctx, "GET", schedulePath(scheduleId, "bookmarks"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetBookmarks replaces the session's bookmarked events. Unknown events are
+// dropped. It returns ErrUnauthorized if Setup was not called.
+func (c *Client) SetBookmarks(ctx context.Context, scheduleId string, events []string) (*SessionBookmarksResponse, error) {
+	if events == nil {
+		events = []string{}
+	}
+
+	var resp SessionBookmarksResponse
+	if err := c.do(ctx, "PUT", schedulePath(scheduleId, "bookmarks"), structs.BookmarksRequest{Events: events}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetSelection returns a shared selection by its ID. Unknown IDs have no
+// events.
+func (c *Client) GetSelection(ctx context.Context, scheduleId string, id string) (*BookmarksResponse, error) {
+	var resp BookmarksResponse
+	if err := c.do(ctx, "GET", schedulePath(scheduleId, "bookmarks", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DecodeShareCode returns the events of a share code, or ErrNotFound.
+func (c *Client) DecodeShareCode(ctx context.Context, scheduleId string, code string) (*BookmarksResponse, error) {
+	var resp BookmarksResponse
+	if err := c.do(ctx, "GET", schedulePath(scheduleId, "bookmarks", "decode", code), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetCounts returns the number of sessions that bookmarked each event, with
+// the schedule's count settings applied.
+func (c *Client) GetCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
+	var resp EventSelectionCountsResponse
+	if err := c.do(ctx, "GET", schedulePath(scheduleId, "counts"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Counts, nil
+}
+
+// CheckIn marks a bookmarked event as attended by the session. It returns a
+// StatusError with status 422 if the event is not bookmarked.
+func (c *Client) CheckIn(ctx context.Context, scheduleId string, eventId string) error {
+	return c.do(ctx, "PUT", schedulePath(scheduleId, "attendance", eventId), nil, nil)
+}
+
+// CancelCheckIn forgets that the session attended an event.
+func (c *Client) CancelCheckIn(ctx context.Context, scheduleId string, eventId string) error {
+	return c.do(ctx, "DELETE", schedulePath(scheduleId, "attendance", eventId), nil, nil)
+}
+
+// GetAttendance returns the events the session checked in to.
+func (c *Client) GetAttendance(ctx context.Context, scheduleId string) ([]Attendance, error) {
+	var resp structs.AttendanceResponse
+	if err := c.do(ctx, "GET", schedulePath(scheduleId, "attendance"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Attendance, nil
+}
+
+func schedulePath(scheduleId string, parts ...string) string {
+	path := "/v2/schedule/" + url.PathEscape(scheduleId)
+	for _, part := range parts {
+		path += "/" + url.PathEscape(part)
+	}
+	return path
+}
+
+// do sends body as JSON, if not nil, and decodes a successful response into
+// out, if not nil.
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var errResp structs.ErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		return &StatusError{
+			Method:     method,
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(respBody)),
+			Message:    errResp.Error.Message,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, req.URL, err)
+	}
+	return nil
+}
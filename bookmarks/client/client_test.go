@@ -0,0 +1,126 @@
+package client_test
+
+import (
+	"bookmarks/client"
+	"bookmarks/internal/config"
+	"bookmarks/internal/db/memory"
+	"bookmarks/internal/server"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/validator/validatortest"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const SCHEDULE_ID = "test"
+const SECRET = "test-secret"
+
+func newServer(t *testing.T) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	source := validatortest.NewSource(t, map[string][]structs.Event{
+		SCHEDULE_ID: {{Id: "e1"}, {Id: "e2"}, {Id: "e3"}},
+	})
+	cfg := &config.Config{
+		ScheduleURLs: source.URLs(),
+		Secret:       SECRET,
+	}
+
+	srv := httptest.NewServer(server.NewHandler(ctx, memory.NewStore([]byte(SECRET)), cfg, nil))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newClient(t *testing.T, url string, httpClient *http.Client) *client.Client {
+	c, err := client.New(url, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	url := newServer(t)
+	c := newClient(t, url+"/", nil)
+
+	if _, err := c.SetBookmarks(ctx, SCHEDULE_ID, []string{"e1"}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized without a session, got %v", err)
+	}
+
+	sessionId, err := c.Setup(ctx, SCHEDULE_ID, "")
+	if err != nil || sessionId == "" {
+		t.Fatalf("expected a session, got %q, %v", sessionId, err)
+	}
+
+	saved, err := c.SetBookmarks(ctx, SCHEDULE_ID, []string{"e1", "e2", "unknown"})
+	if err != nil || !slices.Equal(saved.Events, []string{"e1", "e2"}) {
+		t.Fatalf("unexpected bookmarks %v, %v", saved, err)
+	}
+
+	cur, err := c.GetBookmarks(ctx, SCHEDULE_ID)
+	if err != nil || cur.Id != saved.Id {
+		t.Fatalf("expected the saved bookmarks, got %v, %v", cur, err)
+	}
+
+	// the http client's jar is not shared
+	other := newClient(t, url, &http.Client{})
+	if cur, err := other.GetBookmarks(ctx, SCHEDULE_ID); err != nil || len(cur.Events) != 0 {
+		t.Fatalf("expected no bookmarks for another client, got %v, %v", cur, err)
+	}
+
+	// continuing the session on another device
+	if joined, err := other.Setup(ctx, SCHEDULE_ID, sessionId); err != nil || joined != sessionId {
+		t.Fatalf("expected to join %s, got %s, %v", sessionId, joined, err)
+	}
+	if cur, err := other.GetBookmarks(ctx, SCHEDULE_ID); err != nil || cur.Id != saved.Id {
+		t.Fatalf("expected the joined session's bookmarks, got %v, %v", cur, err)
+	}
+
+	shared, err := other.GetSelection(ctx, SCHEDULE_ID, saved.Id)
+	if err != nil || !slices.Equal(shared.Events, saved.Events) {
+		t.Fatalf("expected the shared selection, got %v, %v", shared, err)
+	}
+
+	decoded, err := other.DecodeShareCode(ctx, SCHEDULE_ID, saved.Code)
+	if err != nil || !slices.Equal(decoded.Events, saved.Events) {
+		t.Fatalf("expected the decoded selection, got %v, %v", decoded, err)
+	}
+	if _, err := other.DecodeShareCode(ctx, SCHEDULE_ID, "invalid"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	counts, err := c.GetCounts(ctx, SCHEDULE_ID)
+	if expected := map[string]int{"e1": 1, "e2": 1}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected counts %v, got %v, %v", expected, counts, err)
+	}
+
+	var statusErr *client.StatusError
+	if _, err := c.GetCounts(ctx, "unknown"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || statusErr.Message != "Not Found" ||
+		!strings.Contains(statusErr.Body, `"code":"not_found"`) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+
+	if err := c.CheckIn(ctx, SCHEDULE_ID, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckIn(ctx, SCHEDULE_ID, "e3"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422 for an event that is not bookmarked, got %v", err)
+	}
+	attendance, err := c.GetAttendance(ctx, SCHEDULE_ID)
+	if err != nil || len(attendance) != 1 || attendance[0].EventId != "e1" {
+		t.Fatalf("expected the check-in, got %v, %v", attendance, err)
+	}
+	if err := c.CancelCheckIn(ctx, SCHEDULE_ID, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if attendance, err := c.GetAttendance(ctx, SCHEDULE_ID); err != nil || len(attendance) != 0 {
+		t.Fatalf("expected no check-ins, got %v, %v", attendance, err)
+	}
+}
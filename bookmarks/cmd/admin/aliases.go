@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"bookmarks/internal/validator"
+	"context"
+	"flag"
+	"fmt"
+)
+
+func rewriteAliases(ctx context.Context, cfg *config.Config, args []string) error {
+	var scheduleId string
+	flags := flag.NewFlagSet("rewrite-aliases", flag.ExitOnError)
+	flags.StringVar(&scheduleId, "schedule", "", "only rewrite this schedule")
+	flags.Parse(args)
+
+	if _, ok := cfg.ScheduleURLs[scheduleId]; scheduleId != "" && !ok {
+		return fmt.Errorf("no such schedule %s", scheduleId)
+	}
+
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	v := validator.NewValidator(cfg.ScheduleURLs, cfg.FeedCacheDir)
+	v.SetAliases(cfg.GetAliases())
+
+	for id := range cfg.ScheduleURLs {
+		if scheduleId != "" && id != scheduleId {
+			continue
+		}
+
+		changed, err := store.RewriteSelections(ctx, id, func(eventIds []string) []string {
+			return v.ResolveAliases(ctx, id, eventIds)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: rewrote %d selections\n", id, changed)
+	}
+
+	return nil
+}
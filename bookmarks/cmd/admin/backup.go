@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func backup(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("expected a backup path")
+	}
+
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	if err := store.Backup(ctx, flags.Arg(0)); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %s\n", flags.Arg(0))
+	return nil
+}
+
+// restore replaces the database with a backup, after checking it. The
+// replaced database is kept next to it. The service must be stopped.
+func restore(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("expected a backup path")
+	}
+	backupPath := flags.Arg(0)
+
+	dbPath := db.FilePath(cfg.DBURL)
+
+	version, err := db.CheckBackup(ctx, backupPath)
+	if err != nil {
+		return fmt.Errorf("cannot restore %s: %w", backupPath, err)
+	}
+
+	for _, suffix := range []string{"-wal", "-journal"} {
+		if info, err := os.Stat(dbPath + suffix); err == nil && info.Size() > 0 {
+			return fmt.Errorf("%s exists, the database is in use or was not closed cleanly", dbPath+suffix)
+		}
+	}
+
+	// copied next to the database first, so the rename is atomic
+	tmpPath := dbPath + ".restore"
+	if err := copyFile(backupPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		oldPath := fmt.Sprintf("%s.%s.bak", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(dbPath, oldPath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		fmt.Printf("moved the current database to %s\n", oldPath)
+	}
+
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(dbPath + suffix)
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return err
+	}
+
+	// upgrade the schema of older backups
+	restored := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	restored.Init()
+	if err := restored.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s (schema version %d) to %s\n", filepath.Base(backupPath), version, dbPath)
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// exportedSelection is a session's selection in the JSON export.
+type exportedSelection struct {
+	ScheduleId string   `json:"scheduleId"`
+	SessionId  string   `json:"sessionId"`
+	Id         string   `json:"id"`
+	Date       string   `json:"date"`
+	Events     []string `json:"events"`
+}
+
+func export(ctx context.Context, cfg *config.Config, args []string) error {
+	var scheduleId, format, outPath string
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.StringVar(&scheduleId, "schedule", "", "only export this schedule")
+	flags.StringVar(&format, "format", "json", "output format, json or csv")
+	flags.StringVar(&outPath, "o", "", "output file path, instead of stdout")
+	flags.Parse(args)
+
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	store, err := db.OpenDB(ctx, cfg.DBURL, []byte(cfg.Secret))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	buf := bufio.NewWriter(out)
+	if format == "csv" {
+		err = exportCSV(ctx, store, scheduleId, buf)
+	} else {
+		err = exportJSON(ctx, store, scheduleId, buf)
+	}
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// exportJSON writes an array of the sessions' selections.
+func exportJSON(ctx context.Context, store *db.DB, scheduleId string, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	sep := "["
+	err := store.ExportSessionSelections(ctx, scheduleId, func(sessionId string, sel db.SessionSelection) error {
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ","
+
+		return enc.Encode(exportedSelection{
+			ScheduleId: sel.ScheduleId, SessionId: sessionId, Id: sel.Hash, Date: sel.Date, Events: sel.Events,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if sep == "[" {
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "]\n")
+	}
+	return err
+}
+
+// exportCSV writes a row for each event of the sessions' selections.
+func exportCSV(ctx context.Context, store *db.DB, scheduleId string, w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"schedule_id", "session_id", "selection_id", "date", "event_id"}); err != nil {
+		return err
+	}
+
+	err := store.ExportSessionSelections(ctx, scheduleId, func(sessionId string, sel db.SessionSelection) error {
+		if len(sel.Events) == 0 {
+			return out.Write([]string{sel.ScheduleId, sessionId, sel.Hash, sel.Date, ""})
+		}
+
+		for _, eventId := range sel.Events {
+			if err := out.Write([]string{sel.ScheduleId, sessionId, sel.Hash, sel.Date, eventId}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
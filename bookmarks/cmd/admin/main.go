@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, cfg *config.Config, args []string) error
+	// noConfig commands run without reading the config.
+	noConfig bool
+}
+
+var commands = map[string]command{
+	"rewrite-aliases": {
+		"[-schedule id]",
+		"rewrite stored selections using the schedules' event ID aliases",
+		rewriteAliases,
+		false,
+	},
+	"webhook-log": {
+		"[-schedule id] [-limit n]",
+		"show the latest webhook delivery attempts",
+		webhookLog,
+		false,
+	},
+	"stats": {
+		"[-top n]",
+		"show the number of sessions and selections, and the most bookmarked events of each schedule",
+		stats,
+		false,
+	},
+	"export": {
+		"[-schedule id] [-format json|csv] [-o path]",
+		"export every session's bookmarked events",
+		export,
+		false,
+	},
+	"gc": {
+		"[-history duration] [-dry-run]",
+		"delete selections no session has, and optionally old selection history",
+		gc,
+		false,
+	},
+	"rebuild-counts": {
+		"[-schedule id]",
+		"recompute the bookmark counts of each event from the sessions' selections",
+		rebuildCounts,
+		false,
+	},
+	"inspect-session": {
+		"<id>",
+		"show everything stored about a session",
+		inspectSession,
+		false,
+	},
+	"revoke-session": {
+		"[-erase] <id>",
+		"make a session ID invalid, and optionally erase its data",
+		revokeSession,
+		false,
+	},
+	"vacuum": {
+		"",
+		"rebuild the database file to return unused space",
+		vacuum,
+		false,
+	},
+	"backup": {
+		"<path>",
+		"write a consistent copy of the database, which may be in use, to a new file",
+		backup,
+		false,
+	},
+	"restore": {
+		"<path>",
+		"check a backup and replace the database with it; the service must be stopped",
+		restore,
+		false,
+	},
+	"generate-vapid-keys": {
+		"",
+		"generate a VAPID key pair for push notifications",
+		generateVAPIDKeys,
+		true,
+	},
+}
+
+func main() {
+	var cfgPath string
+	flag.StringVar(&cfgPath, "config", "schedule.yaml", "config file path, or empty to only use environment variables")
+	flag.Usage = usage
+
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var cfg *config.Config
+	if !cmd.noConfig {
+		var err error
+		cfg, err = config.ParseConfig(cfgPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := cmd.run(context.Background(), cfg, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config path] <command> [args]\n\nCommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %s\n", strings.TrimSpace(name+" "+cmd.usage))
+		fmt.Fprintf(out, "    \t%s\n", cmd.description)
+	}
+
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func gc(ctx context.Context, cfg *config.Config, args []string) error {
+	var history time.Duration
+	var dryRun bool
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	flags.DurationVar(&history, "history", 0, "also delete selection history older than this, e.g. 8760h")
+	flags.BoolVar(&dryRun, "dry-run", false, "only show what would be deleted")
+	flags.Parse(args)
+
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	var historyBefore time.Time
+	if history > 0 {
+		historyBefore = time.Now().Add(-history)
+	}
+
+	stats, err := store.CollectGarbage(ctx, historyBefore, dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	fmt.Printf("%s %d history entries and %d unused selections\n", verb, stats.History, stats.Selections)
+	return nil
+}
+
+func vacuum(ctx context.Context, cfg *config.Config, args []string) error {
+	dbPath := db.FilePath(cfg.DBURL)
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	before, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Vacuum(ctx); err != nil {
+		return err
+	}
+
+	after, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %d -> %d bytes\n", dbPath, before.Size(), after.Size())
+	return nil
+}
+
+func rebuildCounts(ctx context.Context, cfg *config.Config, args []string) error {
+	var scheduleId string
+	flags := flag.NewFlagSet("rebuild-counts", flag.ExitOnError)
+	flags.StringVar(&scheduleId, "schedule", "", "only rebuild this schedule's counts")
+	flags.Parse(args)
+
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	changed, err := store.RebuildEventCounts(ctx, scheduleId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rebuilt event counts, %d were wrong\n", changed)
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/push"
+	"context"
+	"fmt"
+)
+
+func generateVAPIDKeys(ctx context.Context, cfg *config.Config, args []string) error {
+	publicKey, privateKey, err := push.GenerateKeys()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("vapid_public_key: %s\n", publicKey)
+	fmt.Printf("vapid_private_key: %s\n", privateKey)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func inspectSession(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("inspect-session", flag.ExitOnError)
+	flags.Parse(args)
+
+	sessionId, err := sessionIdArg(flags)
+	if err != nil {
+		return err
+	}
+
+	store, err := db.OpenDB(ctx, cfg.DBURL, []byte(cfg.Secret))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	revoked, err := store.IsSessionRevoked(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := store.ResolveSessionLink(ctx, sessionId, "")
+	if err != nil {
+		return err
+	}
+
+	data, err := store.GetSessionData(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("session: %s\nrevoked: %v\n", sessionId, revoked)
+	if resolved != sessionId {
+		fmt.Printf("merged into: %s\n", resolved)
+	}
+	if len(data.LinkedIds) > 0 {
+		fmt.Printf("merged sessions: %s\n", strings.Join(data.LinkedIds, ", "))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nSCHEDULE\tDATE\tSELECTION\tEVENTS")
+	for _, sel := range data.Selections {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", sel.ScheduleId, sel.Date, sel.Hash, strings.Join(sel.Events, " "))
+	}
+
+	fmt.Fprintf(w, "\nhistory: %d entries\n", len(data.History))
+
+	if len(data.PushSubscriptions) > 0 {
+		fmt.Fprintln(w, "\nSCHEDULE\tREMINDER MINUTES\tCHANGE ALERTS\tPUSH ENDPOINT")
+		for _, sub := range data.PushSubscriptions {
+			fmt.Fprintf(w, "%s\t%d\t%v\t%s\n", sub.ScheduleId, sub.ReminderMinutes, sub.ChangeAlerts, sub.Endpoint)
+		}
+	}
+
+	if len(data.Attendance) > 0 {
+		fmt.Fprintln(w, "\nSCHEDULE\tDATE\tATTENDED")
+		for _, a := range data.Attendance {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.ScheduleId, a.Date, a.EventId)
+		}
+	}
+
+	return w.Flush()
+}
+
+func revokeSession(ctx context.Context, cfg *config.Config, args []string) error {
+	var erase bool
+	flags := flag.NewFlagSet("revoke-session", flag.ExitOnError)
+	flags.BoolVar(&erase, "erase", false, "also erase the session's data")
+	flags.Parse(args)
+
+	sessionId, err := sessionIdArg(flags)
+	if err != nil {
+		return err
+	}
+
+	store := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
+	store.Init()
+	defer store.Close()
+
+	// merged IDs are resolved before they are checked, so revoking one has
+	// no effect
+	resolved, err := store.ResolveSessionLink(ctx, sessionId, "")
+	if err != nil {
+		return err
+	}
+	if resolved != sessionId {
+		return fmt.Errorf("%s was merged into %s, revoke that session instead", sessionId, resolved)
+	}
+
+	// the sessions merged into it are revoked too, as erasing removes their
+	// links
+	linkedIds, err := store.GetLinkedSessionIds(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append([]string{sessionId}, linkedIds...) {
+		if err := store.RevokeSession(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s\n", id)
+	}
+
+	if erase {
+		if err := store.DeleteSessionData(ctx, sessionId); err != nil {
+			return err
+		}
+		fmt.Printf("erased %s\n", sessionId)
+	}
+
+	return nil
+}
+
+// sessionIdArg returns the session ID argument, which may be a session cookie
+// value.
+func sessionIdArg(flags *flag.FlagSet) (string, error) {
+	if flags.NArg() != 1 {
+		return "", errors.New("expected a session ID")
+	}
+
+	sessionId, _, _ := strings.Cut(flags.Arg(0), ".")
+	return sessionId, nil
+}
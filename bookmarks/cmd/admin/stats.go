@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+func stats(ctx context.Context, cfg *config.Config, args []string) error {
+	var top int
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags.IntVar(&top, "top", 10, "number of most bookmarked events to show per schedule")
+	flags.Parse(args)
+
+	store, err := db.OpenDB(ctx, cfg.DBURL, []byte(cfg.Secret))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := store.GetStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sessions: %d\nrevoked sessions: %d\n\n", stats.Sessions, stats.Revoked)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SCHEDULE\tSESSIONS\tSELECTIONS\tHISTORY\tLAST UPDATE")
+	for _, schedule := range stats.Schedules {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n",
+			schedule.ScheduleId, schedule.Sessions, schedule.Selections, schedule.History, schedule.LastUpdate,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if top <= 0 {
+		return nil
+	}
+
+	for _, schedule := range stats.Schedules {
+		counts, err := store.GetEventSelectionCounts(ctx, schedule.ScheduleId)
+		if err != nil {
+			return err
+		}
+
+		type eventCount struct {
+			id    string
+			count int
+		}
+		events := make([]eventCount, 0, len(counts))
+		for id, count := range counts {
+			events = append(events, eventCount{id, count})
+		}
+		slices.SortFunc(events, func(a eventCount, b eventCount) int {
+			return cmp.Or(b.count-a.count, cmp.Compare(a.id, b.id))
+		})
+
+		fmt.Printf("\n%s: top events\n", schedule.ScheduleId)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, event := range events[:min(top, len(events))] {
+			fmt.Fprintf(w, "  %s\t%d\n", event.id, event.count)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
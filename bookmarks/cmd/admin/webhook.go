@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/db"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func webhookLog(ctx context.Context, cfg *config.Config, args []string) error {
+	var scheduleId string
+	var limit int
+	flags := flag.NewFlagSet("webhook-log", flag.ExitOnError)
+	flags.StringVar(&scheduleId, "schedule", "", "only show this schedule")
+	flags.IntVar(&limit, "limit", 50, "number of attempts to show")
+	flags.Parse(args)
+
+	store, err := db.OpenDB(ctx, cfg.DBURL, []byte(cfg.Secret))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	deliveries, err := store.GetWebhookDeliveries(ctx, scheduleId, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tSCHEDULE\tTYPE\tEVENT\tATTEMPT\tSTATUS\tURL\tERROR")
+	for _, d := range deliveries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			d.Date.Format(time.RFC3339), d.ScheduleId, d.EventType, d.EventId, d.Attempt, d.Status, d.URL, d.Error,
+		)
+	}
+	return w.Flush()
+}
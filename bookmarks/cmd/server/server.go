@@ -3,21 +3,42 @@ package main
 import (
 	"bookmarks/internal/config"
 	"bookmarks/internal/db"
+	"bookmarks/internal/logging"
 	"bookmarks/internal/server"
 	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	var cfgPath string
 	var port int
-	flag.StringVar(&cfgPath, "config", "schedule.yaml", "config file path")
+	var logLevel string
+	flag.StringVar(&cfgPath, "config", "schedule.yaml", "config file path, or empty to only use environment variables")
 	flag.IntVar(&port, "port", 8000, "the port to bind to")
+	flag.StringVar(&logLevel, "log-level", "info", "the log level (debug, info, warn, error)")
 
 	flag.Parse()
 
-	config := config.ParseConfig(cfgPath)
-	db := db.NewDB(config.DBURL)
+	if err := logging.Setup(logLevel); err != nil {
+		panic(err)
+	}
+
+	cfg, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	db := db.NewDB(cfg.DBURL, []byte(cfg.Secret))
 	db.Init()
 
-	server.Run(port, db, config)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	updates := config.Watch(cfgPath, 5*time.Second, reload)
+
+	server.Run(port, db, cfg, updates)
 }
@@ -0,0 +1,8 @@
+module example.com/bookmarks-client-example
+
+go 1.23.6
+
+require bookmarks v0.0.0
+
+// the bookmarks module has no public path, so it is used from a checkout
+replace bookmarks => ../..
@@ -0,0 +1,46 @@
+// Command client is an example of using the bookmarks client from another
+// module. It bookmarks events for a new session and checks in to the first.
+package main
+
+import (
+	"bookmarks/client"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "the bookmarks API's base URL")
+	scheduleId := flag.String("schedule", "example", "the schedule's ID")
+	flag.Parse()
+
+	ctx := context.Background()
+	c, err := client.New(*baseURL, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessionId, err := c.Setup(ctx, *scheduleId, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("session: %s\n", sessionId)
+
+	var saved *client.SessionBookmarksResponse
+	saved, err = c.SetBookmarks(ctx, *scheduleId, flag.Args())
+	if errors.Is(err, client.ErrNotFound) {
+		log.Fatalf("unknown schedule %s", *scheduleId)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("bookmarked: %v\n", saved.Events)
+
+	if len(saved.Events) > 0 {
+		if err := c.CheckIn(ctx, *scheduleId, saved.Events[0]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("checked in to %s\n", saved.Events[0])
+	}
+}
@@ -1,31 +1,499 @@
 package config
 
 import (
+	"bookmarks/internal/counts"
+	"bookmarks/internal/db"
+	"bookmarks/internal/push"
+	"bookmarks/internal/webhook"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
 	"gopkg.in/yaml.v3"
 )
 
+const ENV_PREFIX = "BOOKMARKS_"
+
+const DEFAULT_BACKUP_INTERVAL = 24 * time.Hour
+const DEFAULT_BACKUP_KEEP = 7
+
 type Config struct {
-	DBURL          string            `yaml:"db_url"`
-	AllowedOrigins []string          `yaml:"allowed_origins"`
-	Domain         string            `yaml:"domain"`
-	ScheduleURLs   map[string]string `yaml:"schedule_urls"`
-	Secret         string            `yaml:"secret"`
+	DBURL          string                      `yaml:"db_url"`
+	AllowedOrigins []string                    `yaml:"allowed_origins"`
+	Domain         string                      `yaml:"domain"`
+	ScheduleURLs   map[string]string           `yaml:"schedule_urls"`
+	Secret         string                      `yaml:"secret"`
+	SecretFile     string                      `yaml:"secret_file"`
+	FeedCacheDir   string                      `yaml:"feed_cache_dir"`
+	Schedules      map[string]ScheduleSettings `yaml:"schedules"`
+	// GlobalSessions enables a session cookie shared by every schedule.
+	GlobalSessions bool `yaml:"global_sessions"`
+	// AdminToken is the bearer token for the /admin routes, which are
+	// disabled if it is empty.
+	AdminToken string `yaml:"admin_token"`
+
+	// VAPID keys for Web Push, as unpadded base64url. Push notifications are
+	// disabled if they are not set.
+	VAPIDPublicKey      string `yaml:"vapid_public_key"`
+	VAPIDPrivateKey     string `yaml:"vapid_private_key"`
+	VAPIDPrivateKeyFile string `yaml:"vapid_private_key_file"`
+	VAPIDSubject        string `yaml:"vapid_subject"`
+	// PushHosts are the hosts push endpoints may be on, with their
+	// subdomains. If empty, any host name is allowed that doesn't resolve to
+	// a private address.
+	PushHosts []string `yaml:"push_hosts"`
+
+	// BackupDir is where snapshots of the database are written every
+	// BackupInterval, keeping the last BackupKeep. No snapshots are written
+	// if it is empty.
+	BackupDir      string        `yaml:"backup_dir"`
+	BackupInterval time.Duration `yaml:"backup_interval"`
+	BackupKeep     int           `yaml:"backup_keep"`
+
+	// HistoryRetention is how long selection history entries are kept. They
+	// are kept forever if it is 0.
+	HistoryRetention time.Duration `yaml:"history_retention"`
+
+	pushKeys *push.Keys
+}
+
+// ScheduleSettings are optional per-schedule settings.
+type ScheduleSettings struct {
+	// Aliases maps old event IDs to their new IDs.
+	Aliases map[string]string `yaml:"aliases"`
+	// TimeZone is used for event times without an offset, instead of the
+	// time zone in the schedule's config.json.
+	TimeZone string `yaml:"time_zone"`
+	// Webhooks receive signed events about the schedule.
+	Webhooks []WebhookSettings `yaml:"webhooks"`
+	// CountThresholds are the bookmark counts at which a webhook event is
+	// sent for an event.
+	CountThresholds []int `yaml:"count_thresholds"`
+	// Counts protects individual choices in the public bookmark counts.
+	Counts counts.Privacy `yaml:"counts"`
+}
+
+type WebhookSettings struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+	// Events are the event types to send, or all if empty.
+	Events []string `yaml:"events"`
+}
+
+// ValidationError lists every problem found in a config.
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if e.Path != "" {
+		fmt.Fprintf(&b, "invalid config %s:", e.Path)
+	} else {
+		b.WriteString("invalid config:")
+	}
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
 }
 
-func ParseConfig(path string) *Config {
-	f, err := os.Open(path)
+// ParseConfig reads the config file at path, if any, applies BOOKMARKS_*
+// environment overrides, reads the secret file and validates the result.
+func ParseConfig(path string) (*Config, error) {
+	config := &Config{}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := yaml.NewDecoder(f).Decode(config); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+	}
+
+	problems := config.applyEnv()
+
+	if config.SecretFile != "" {
+		if config.Secret != "" {
+			problems = append(problems, "only one of secret and secret_file may be set")
+		}
+
+		secret, err := os.ReadFile(config.SecretFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read secret_file: %s", err))
+		}
+		config.Secret = strings.TrimSpace(string(secret))
+	}
+
+	if config.VAPIDPrivateKeyFile != "" {
+		if config.VAPIDPrivateKey != "" {
+			problems = append(problems, "only one of vapid_private_key and vapid_private_key_file may be set")
+		}
+
+		key, err := os.ReadFile(config.VAPIDPrivateKeyFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read vapid_private_key_file: %s", err))
+		}
+		config.VAPIDPrivateKey = strings.TrimSpace(string(key))
+	}
+
+	if config.BackupInterval == 0 {
+		config.BackupInterval = DEFAULT_BACKUP_INTERVAL
+	}
+	if config.BackupKeep == 0 {
+		config.BackupKeep = DEFAULT_BACKUP_KEEP
+	}
+
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Path: path, Problems: problems}
+	}
+
+	return config, nil
+}
+
+func (c *Config) applyEnv() []string {
+	var problems []string
+
+	if val, ok := os.LookupEnv(ENV_PREFIX + "DB_URL"); ok {
+		c.DBURL = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "DOMAIN"); ok {
+		c.Domain = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "SECRET"); ok {
+		c.Secret = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "SECRET_FILE"); ok {
+		c.SecretFile = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "FEED_CACHE_DIR"); ok {
+		c.FeedCacheDir = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "ADMIN_TOKEN"); ok {
+		c.AdminToken = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "GLOBAL_SESSIONS"); ok {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%sGLOBAL_SESSIONS: expected a boolean, got %q", ENV_PREFIX, val))
+		}
+		c.GlobalSessions = enabled
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "BACKUP_DIR"); ok {
+		c.BackupDir = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "BACKUP_INTERVAL"); ok {
+		interval, err := time.ParseDuration(val)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%sBACKUP_INTERVAL: expected a duration, got %q", ENV_PREFIX, val))
+		}
+		c.BackupInterval = interval
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "BACKUP_KEEP"); ok {
+		keep, err := strconv.Atoi(val)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%sBACKUP_KEEP: expected a number, got %q", ENV_PREFIX, val))
+		}
+		c.BackupKeep = keep
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "HISTORY_RETENTION"); ok {
+		retention, err := time.ParseDuration(val)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%sHISTORY_RETENTION: expected a duration, got %q", ENV_PREFIX, val))
+		}
+		c.HistoryRetention = retention
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "VAPID_PUBLIC_KEY"); ok {
+		c.VAPIDPublicKey = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "VAPID_PRIVATE_KEY"); ok {
+		c.VAPIDPrivateKey = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "VAPID_PRIVATE_KEY_FILE"); ok {
+		c.VAPIDPrivateKeyFile = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "VAPID_SUBJECT"); ok {
+		c.VAPIDSubject = val
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "PUSH_HOSTS"); ok {
+		c.PushHosts = splitList(val)
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = splitList(val)
+	}
+	if val, ok := os.LookupEnv(ENV_PREFIX + "SCHEDULE_URLS"); ok {
+		if c.ScheduleURLs == nil {
+			c.ScheduleURLs = make(map[string]string)
+		}
+		for _, entry := range splitList(val) {
+			scheduleId, scheduleURL, ok := strings.Cut(entry, "=")
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%sSCHEDULE_URLS: expected id=url, got %q", ENV_PREFIX, entry))
+				continue
+			}
+			c.ScheduleURLs[strings.TrimSpace(scheduleId)] = strings.TrimSpace(scheduleURL)
+		}
+	}
+
+	return problems
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+
+	if c.Secret == "" {
+		problems = append(problems, "secret must not be empty")
+	}
+
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		problems = append(problems, "admin_token must be at least 16 characters")
+	}
+
+	if c.DBURL == "" {
+		problems = append(problems, "db_url must not be empty")
+	} else if err := checkWritable(db.FilePath(c.DBURL)); err != nil {
+		problems = append(problems, fmt.Sprintf("db_url: database is not writable: %s", err))
+	}
+
+	if c.FeedCacheDir != "" {
+		if err := checkWritable(filepath.Join(c.FeedCacheDir, ".check")); err != nil {
+			problems = append(problems, fmt.Sprintf("feed_cache_dir: directory is not writable: %s", err))
+		}
+	}
+
+	if c.BackupDir != "" {
+		if err := checkWritable(filepath.Join(c.BackupDir, ".check")); err != nil {
+			problems = append(problems, fmt.Sprintf("backup_dir: directory is not writable: %s", err))
+		}
+	}
+	if c.BackupInterval < time.Minute {
+		problems = append(problems, "backup_interval must be at least 1m")
+	}
+	if c.BackupKeep < 1 {
+		problems = append(problems, "backup_keep must be at least 1")
+	}
+	if c.HistoryRetention < 0 {
+		problems = append(problems, "history_retention must not be negative")
+	}
+
+	for scheduleId, scheduleURL := range c.ScheduleURLs {
+		if scheduleId == "" || strings.ContainsAny(scheduleId, "/?#") {
+			problems = append(problems, fmt.Sprintf("schedule_urls: invalid schedule ID %q", scheduleId))
+		}
+
+		if !isValidScheduleURL(scheduleURL) {
+			problems = append(problems, fmt.Sprintf("schedule_urls: invalid URL %q for %s", scheduleURL, scheduleId))
+		}
+	}
+
+	for scheduleId, settings := range c.Schedules {
+		if _, ok := c.ScheduleURLs[scheduleId]; !ok {
+			problems = append(problems, fmt.Sprintf("schedules: %s is not in schedule_urls", scheduleId))
+		}
+
+		for oldId, newId := range settings.Aliases {
+			if oldId == newId || oldId == "" || newId == "" {
+				problems = append(problems, fmt.Sprintf("schedules: %s: invalid alias %q -> %q", scheduleId, oldId, newId))
+			}
+		}
+
+		if settings.TimeZone != "" {
+			if _, err := time.LoadLocation(settings.TimeZone); err != nil {
+				problems = append(problems, fmt.Sprintf("schedules: %s: invalid time_zone %q", scheduleId, settings.TimeZone))
+			}
+		}
+
+		for _, hook := range settings.Webhooks {
+			urlObj, err := url.Parse(hook.URL)
+			if err != nil || (urlObj.Scheme != "http" && urlObj.Scheme != "https") || urlObj.Host == "" {
+				problems = append(problems, fmt.Sprintf("schedules: %s: invalid webhook URL %q", scheduleId, hook.URL))
+			}
+			if hook.Secret == "" {
+				problems = append(problems, fmt.Sprintf("schedules: %s: webhook %s must have a secret", scheduleId, hook.URL))
+			}
+			for _, eventType := range hook.Events {
+				if !slices.Contains(webhook.EventTypes, eventType) {
+					problems = append(problems, fmt.Sprintf("schedules: %s: unknown webhook event %q", scheduleId, eventType))
+				}
+			}
+		}
+
+		for _, threshold := range settings.CountThresholds {
+			if threshold <= 0 {
+				problems = append(problems, fmt.Sprintf("schedules: %s: invalid count threshold %d", scheduleId, threshold))
+			}
+		}
+
+		if settings.Counts.MinCount < 0 || settings.Counts.Bucket < 0 {
+			problems = append(problems, fmt.Sprintf("schedules: %s: counts: min_count and bucket must not be negative", scheduleId))
+		}
+		if settings.Counts.NoiseEpsilon < 0 || math.IsNaN(settings.Counts.NoiseEpsilon) || math.IsInf(settings.Counts.NoiseEpsilon, 0) {
+			problems = append(problems, fmt.Sprintf("schedules: %s: counts: invalid noise_epsilon", scheduleId))
+		}
+	}
+
+	if c.VAPIDPublicKey != "" || c.VAPIDPrivateKey != "" {
+		keys, err := push.ParseKeys(c.VAPIDPublicKey, c.VAPIDPrivateKey)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("vapid_public_key, vapid_private_key: %s", err))
+		}
+		c.pushKeys = keys
+
+		if !strings.HasPrefix(c.VAPIDSubject, "mailto:") && !strings.HasPrefix(c.VAPIDSubject, "https://") {
+			problems = append(problems, "vapid_subject must be a mailto: or https: URL")
+		}
+	}
+
+	for _, host := range c.PushHosts {
+		if host == "" || strings.ContainsAny(host, "/@ ") {
+			problems = append(problems, fmt.Sprintf("push_hosts: invalid host %q", host))
+		}
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		urlObj, err := url.Parse(origin)
+		if err != nil || (urlObj.Scheme != "http" && urlObj.Scheme != "https") || urlObj.Host == "" ||
+			(urlObj.Path != "" && urlObj.Path != "/") || urlObj.RawQuery != "" || urlObj.Fragment != "" {
+			problems = append(problems, fmt.Sprintf("allowed_origins: invalid origin %q", origin))
+		}
+	}
+
+	return problems
+}
+
+func isValidScheduleURL(scheduleURL string) bool {
+	urlObj, err := url.Parse(scheduleURL)
 	if err != nil {
-		panic(err)
+		return false
+	}
+
+	switch urlObj.Scheme {
+	case "http", "https":
+		return urlObj.Host != ""
+	case "file":
+		return urlObj.Path != ""
+	default:
+		return false
+	}
+}
+
+// checkWritable checks that the database file, or the directory it will be
+// created in, can be written to.
+func checkWritable(path string) error {
+	if path == ":memory:" {
+		return nil
 	}
-	defer f.Close()
 
-	var config *Config
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		return f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
-	if err := yaml.NewDecoder(f).Decode(&config); err != nil {
-		panic(err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bookmarks-*")
+	if err != nil {
+		return err
 	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+func splitList(val string) []string {
+	res := make([]string, 0)
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+// Watch reparses the config file whenever it changes on disk, polled every
+// interval, or a value arrives on reload. Configs that fail to parse are
+// logged and skipped.
+func Watch(path string, interval time.Duration, reload <-chan os.Signal) <-chan *Config {
+	updates := make(chan *Config)
+	modTime, size := statFile(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				curModTime, curSize := statFile(path)
+				if curModTime.Equal(modTime) && curSize == size {
+					continue
+				}
+				modTime, size = curModTime, curSize
+			case <-reload:
+				modTime, size = statFile(path)
+			}
 
-	return config
+			config, err := ParseConfig(path)
+			if err != nil {
+				slog.Error("rejected config", "path", path, "error", err)
+				continue
+			}
+
+			slog.Info("reloaded config", "path", path)
+			updates <- config
+		}
+	}()
+
+	return updates
+}
+
+func statFile(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// GetAliases returns the configured event ID aliases of each schedule.
+func (c *Config) GetAliases() map[string]map[string]string {
+	aliases := make(map[string]map[string]string)
+	for scheduleId, settings := range c.Schedules {
+		if len(settings.Aliases) > 0 {
+			aliases[scheduleId] = settings.Aliases
+		}
+	}
+	return aliases
+}
+
+// PushKeys returns the VAPID keys, or nil if Web Push is not configured.
+func (c *Config) PushKeys() *push.Keys {
+	return c.pushKeys
+}
+
+// GetWebhooks returns the webhooks configured for a schedule.
+func (c *Config) GetWebhooks(scheduleId string) []webhook.Webhook {
+	settings := c.Schedules[scheduleId].Webhooks
+	hooks := make([]webhook.Webhook, 0, len(settings))
+	for _, hook := range settings {
+		hooks = append(hooks, webhook.Webhook{URL: hook.URL, Secret: hook.Secret, Events: hook.Events})
+	}
+	return hooks
 }
@@ -0,0 +1,227 @@
+package config_test
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/push"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, cfgPath string, body string) {
+	dbPath := path.Join(path.Dir(cfgPath), "db.sqlite")
+	if err := os.WriteFile(cfgPath, []byte("db_url: "+dbPath+"\n"+body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	writeConfig(t, cfgPath, "secret: a\nschedule_urls:\n  s1: http://localhost/events.json\n")
+
+	reload := make(chan os.Signal)
+	updates := config.Watch(cfgPath, time.Hour, reload)
+
+	writeConfig(t, cfgPath, "secret: a\nschedule_urls:\n  s2: http://localhost/events.json\n")
+	reload <- os.Interrupt
+
+	select {
+	case cfg := <-updates:
+		if _, ok := cfg.ScheduleURLs["s2"]; !ok {
+			t.Fatalf("expected schedule s2, got %v", cfg.ScheduleURLs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a config update")
+	}
+
+	writeConfig(t, cfgPath, "secret: a\nschedule_urls:\n  s2: not a url\n")
+	reload <- os.Interrupt
+
+	select {
+	case cfg := <-updates:
+		t.Fatalf("expected invalid config to be rejected, got %v", cfg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestParseEmptyConfig(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	if err := os.WriteFile(cfgPath, []byte(""), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.ParseConfig(cfgPath); err == nil {
+		t.Fatal("expected an error for an empty config")
+	}
+}
+
+func TestValidationReportsAllProblems(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	if err := os.WriteFile(cfgPath, []byte(
+		"db_url: /nonexistent/dir/db.sqlite\n"+
+			"allowed_origins: [\"localhost:8000\"]\n"+
+			"schedule_urls:\n  s1: ftp://localhost/events.json\n",
+	), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	if len(validationErr.Problems) != 4 {
+		t.Fatalf("expected 4 problems, got %s", err)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := path.Join(dir, "schedule.yaml")
+	secretPath := path.Join(dir, "secret")
+	writeConfig(t, cfgPath, "schedule_urls:\n  s1: http://localhost/events.json\n")
+
+	if err := os.WriteFile(secretPath, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BOOKMARKS_SECRET_FILE", secretPath)
+	t.Setenv("BOOKMARKS_ALLOWED_ORIGINS", "http://localhost:8000, https://example.net")
+	t.Setenv("BOOKMARKS_SCHEDULE_URLS", "s2=https://example.net/events.json")
+
+	cfg, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Secret != "from-file" {
+		t.Fatalf("expected secret from file, got %q", cfg.Secret)
+	}
+
+	if strings.Join(cfg.AllowedOrigins, " ") != "http://localhost:8000 https://example.net" {
+		t.Fatalf("unexpected origins %v", cfg.AllowedOrigins)
+	}
+
+	if len(cfg.ScheduleURLs) != 2 {
+		t.Fatalf("expected 2 schedules, got %v", cfg.ScheduleURLs)
+	}
+}
+
+func TestPushConfig(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	publicKey, privateKey, err := push.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, cfgPath, "secret: a\n"+
+		"schedule_urls:\n  s1: http://localhost/events.json\n"+
+		"schedules:\n  s1:\n    time_zone: Mars/Olympus_Mons\n"+
+		"vapid_public_key: "+publicKey+"\nvapid_private_key: "+privateKey+"\n"+
+		"push_hosts: [https://push.example.com/]\n",
+	)
+
+	_, err = config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 3 {
+		t.Fatalf("expected invalid time_zone and push_hosts and missing vapid_subject, got %v", err)
+	}
+
+	writeConfig(t, cfgPath, "secret: a\n"+
+		"schedule_urls:\n  s1: http://localhost/events.json\n"+
+		"schedules:\n  s1:\n    time_zone: America/New_York\n"+
+		"vapid_public_key: "+publicKey+"\nvapid_private_key: "+privateKey+"\n"+
+		"vapid_subject: mailto:admin@example.com\n"+
+		"push_hosts: [push.example.com]\n",
+	)
+
+	cfg, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.PushKeys() == nil || cfg.PushKeys().PublicKey != publicKey {
+		t.Fatalf("expected push keys to be parsed")
+	}
+	if !slices.Equal(cfg.PushHosts, []string{"push.example.com"}) {
+		t.Fatalf("expected push hosts to be parsed, got %v", cfg.PushHosts)
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	writeConfig(t, cfgPath, "secret: a\n"+
+		"schedule_urls:\n  s1: http://localhost/events.json\n"+
+		"schedules:\n  s1:\n    count_thresholds: [10, 0]\n    webhooks:\n"+
+		"      - url: https://example.com/hook\n        secret: b\n        events: [feed.changed]\n"+
+		"      - url: example.com\n        events: [unknown]\n",
+	)
+
+	_, err := config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 4 {
+		t.Fatalf("expected 4 problems, got %v", err)
+	}
+}
+
+func TestCountsConfig(t *testing.T) {
+	cfgPath := path.Join(t.TempDir(), "schedule.yaml")
+	writeConfig(t, cfgPath, "secret: a\n"+
+		"schedule_urls:\n  s1: http://localhost/events.json\n  s2: http://localhost/events.json\n"+
+		"schedules:\n  s1:\n    counts:\n      min_count: 5\n      bucket: 10\n      noise_epsilon: 0.5\n"+
+		"  s2:\n    counts:\n      min_count: -1\n      noise_epsilon: -2\n",
+	)
+
+	_, err := config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+}
+
+func TestBackupConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := path.Join(dir, "schedule.yaml")
+	writeConfig(t, cfgPath, "secret: a\nbackup_dir: "+dir+"\nbackup_interval: 6h\n")
+
+	cfg, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BackupInterval != 6*time.Hour || cfg.BackupKeep != config.DEFAULT_BACKUP_KEEP {
+		t.Fatalf("unexpected backup settings %v, %d", cfg.BackupInterval, cfg.BackupKeep)
+	}
+
+	writeConfig(t, cfgPath, "secret: a\nbackup_dir: "+path.Join(dir, "missing")+"\nbackup_interval: 1s\nbackup_keep: -1\n")
+	_, err = config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := path.Join(dir, "schedule.yaml")
+	writeConfig(t, cfgPath, "secret: a\nhistory_retention: 720h\n")
+
+	cfg, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HistoryRetention != 30*24*time.Hour {
+		t.Fatalf("expected a history retention of 720h, got %v", cfg.HistoryRetention)
+	}
+
+	t.Setenv(config.ENV_PREFIX+"HISTORY_RETENTION", "-1h")
+	_, err = config.ParseConfig(cfgPath)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package counts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// Privacy settings protect individual choices in public bookmark counts.
+type Privacy struct {
+	// MinCount suppresses counts below it.
+	MinCount int `yaml:"min_count"`
+	// Bucket rounds counts down to a multiple of it.
+	Bucket int `yaml:"bucket"`
+	// NoiseEpsilon adds Laplace noise with scale 1/NoiseEpsilon. Smaller
+	// values add more noise.
+	NoiseEpsilon float64 `yaml:"noise_epsilon"`
+}
+
+// Enabled returns whether any counts are changed.
+func (p Privacy) Enabled() bool {
+	return p.MinCount > 1 || p.Bucket > 1 || p.NoiseEpsilon > 0
+}
+
+// Apply returns the counts with noise added, rounded to buckets and with
+// small counts removed, in that order, so that suppression depends only on
+// the noisy count.
+//
+// With noise, every one of eventIds is counted, also those nobody
+// bookmarked, so that whether an event has a count does not reveal whether
+// anyone bookmarked it. The noise is derived from the key, the event ID and
+// its exact count, so that repeated requests cannot be averaged to remove it.
+// Each event a session bookmarks changes one count, so the privacy loss of a
+// session grows with the number of events it bookmarked: it is NoiseEpsilon
+// for each.
+func (p Privacy) Apply(key []byte, scheduleId string, eventIds []string, counts map[string]int) map[string]int {
+	if !p.Enabled() {
+		return counts
+	}
+
+	if p.NoiseEpsilon > 0 {
+		all := make(map[string]int, len(eventIds)+len(counts))
+		for _, eventId := range eventIds {
+			all[eventId] = 0
+		}
+		for eventId, count := range counts {
+			all[eventId] = count
+		}
+		counts = all
+	}
+
+	res := make(map[string]int, len(counts))
+	for eventId, count := range counts {
+		if p.NoiseEpsilon > 0 {
+			noise := laplace(1/p.NoiseEpsilon, uniform(key, scheduleId, eventId, count))
+			count = max(int(math.Round(float64(count)+noise)), 0)
+		}
+
+		if p.Bucket > 1 {
+			count -= count % p.Bucket
+		}
+
+		if count > 0 && count >= p.MinCount {
+			res[eventId] = count
+		}
+	}
+
+	return res
+}
+
+// uniform returns a value in (-0.5, 0.5) determined by its arguments.
+func uniform(key []byte, scheduleId string, eventId string, count int) float64 {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(scheduleId))
+	mac.Write([]byte{0})
+	mac.Write([]byte(eventId))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.Itoa(count)))
+	bits := binary.BigEndian.Uint64(mac.Sum(nil)) >> 11
+
+	return (float64(bits)+0.5)/(1<<53) - 0.5
+}
+
+// laplace maps u in (-0.5, 0.5) to a Laplace distribution with the scale.
+func laplace(scale float64, u float64) float64 {
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
@@ -0,0 +1,82 @@
+package counts_test
+
+import (
+	"bookmarks/internal/counts"
+	"fmt"
+	"maps"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	key := []byte("secret")
+	exact := map[string]int{"e1": 1, "e2": 4, "e3": 12, "e4": 27}
+
+	res := counts.Privacy{}.Apply(key, "s1", nil, exact)
+	if !maps.Equal(res, exact) {
+		t.Fatalf("expected exact counts, got %v", res)
+	}
+
+	res = counts.Privacy{MinCount: 5}.Apply(key, "s1", nil, exact)
+	if !maps.Equal(res, map[string]int{"e3": 12, "e4": 27}) {
+		t.Fatalf("unexpected counts %v", res)
+	}
+
+	res = counts.Privacy{MinCount: 5, Bucket: 10}.Apply(key, "s1", nil, exact)
+	if !maps.Equal(res, map[string]int{"e3": 10, "e4": 20}) {
+		t.Fatalf("unexpected counts %v", res)
+	}
+}
+
+func TestNoise(t *testing.T) {
+	key := []byte("secret")
+	privacy := counts.Privacy{NoiseEpsilon: 0.5}
+
+	exact := make(map[string]int)
+	for i := range 1000 {
+		exact[fmt.Sprintf("e%d", i)] = 100
+	}
+
+	res := privacy.Apply(key, "s1", nil, exact)
+	if !maps.Equal(res, privacy.Apply(key, "s1", nil, exact)) {
+		t.Fatal("expected the same noise for the same counts")
+	}
+
+	sum, changed := 0, 0
+	for _, count := range res {
+		sum += count
+		if count != 100 {
+			changed++
+		}
+	}
+
+	// the noise has a mean of 0 and a standard deviation of about 2.8
+	if mean := float64(sum) / float64(len(res)); mean < 99.5 || mean > 100.5 {
+		t.Fatalf("unexpected mean %f", mean)
+	}
+	if changed < 500 {
+		t.Fatalf("expected most counts to change, got %d", changed)
+	}
+}
+
+func TestNoiseUncounted(t *testing.T) {
+	key := []byte("secret")
+	privacy := counts.Privacy{NoiseEpsilon: 0.5}
+
+	eventIds := make([]string, 0)
+	for i := range 1000 {
+		eventIds = append(eventIds, fmt.Sprintf("e%d", i))
+	}
+
+	// events nobody bookmarked get counts too, so a count does not show
+	// that somebody did
+	res := privacy.Apply(key, "s1", eventIds, map[string]int{"e0": 1})
+	if len(res) < 100 {
+		t.Fatalf("expected events without bookmarks to have noisy counts, got %d", len(res))
+	}
+
+	// without noise they are left out
+	res = counts.Privacy{Bucket: 2}.Apply(key, "s1", eventIds, map[string]int{"e0": 3})
+	if !maps.Equal(res, map[string]int{"e0": 2}) {
+		t.Fatalf("unexpected counts %v", res)
+	}
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"bookmarks/internal/selection"
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Stats are counts of the stored data.
+type Stats struct {
+	Sessions  int
+	Revoked   int
+	Schedules []ScheduleStats
+}
+
+// ScheduleStats are counts of a schedule's stored data.
+type ScheduleStats struct {
+	ScheduleId string
+	Sessions   int
+	Selections int
+	History    int
+	LastUpdate string
+}
+
+// GarbageStats are the numbers of rows deleted by CollectGarbage.
+type GarbageStats struct {
+	History    int
+	Selections int
+}
+
+// GetStats returns the number of sessions, and of sessions, selections and
+// history entries in each schedule, ordered by schedule ID.
+func (db *DB) GetStats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{Schedules: make([]ScheduleStats, 0)}
+
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT (SELECT COUNT(DISTINCT id) FROM session), (SELECT COUNT(1) FROM revoked_session)",
+	)
+	if err := row.Scan(&stats.Sessions, &stats.Revoked); err != nil {
+		return nil, err
+	}
+
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT ids.schedule_id, "+
+			"(SELECT COUNT(1) FROM session WHERE schedule_id = ids.schedule_id), "+
+			"(SELECT COUNT(DISTINCT selection_hash) FROM schedule_selection WHERE schedule_id = ids.schedule_id), "+
+			"(SELECT COUNT(1) FROM session_history WHERE schedule_id = ids.schedule_id), "+
+			"(SELECT COALESCE(MAX(date), '') FROM session WHERE schedule_id = ids.schedule_id) "+
+			"FROM (SELECT schedule_id FROM session UNION SELECT schedule_id FROM schedule_selection) ids "+
+			"ORDER BY ids.schedule_id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var schedule ScheduleStats
+		if err := res.Scan(&schedule.ScheduleId, &schedule.Sessions, &schedule.Selections, &schedule.History, &schedule.LastUpdate); err != nil {
+			return nil, err
+		}
+		stats.Schedules = append(stats.Schedules, schedule)
+	}
+
+	return stats, res.Err()
+}
+
+// ExportSessionSelections calls fn with each session's current selection, in
+// one schedule or every schedule if scheduleId is empty, ordered by schedule
+// and session.
+func (db *DB) ExportSessionSelections(ctx context.Context, scheduleId string, fn func(sessionId string, sel SessionSelection) error) error {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT s.id, s.schedule_id, s.date, s.selection_hash, sl.event_id FROM session s "+
+			"LEFT JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+			"WHERE ? = '' OR s.schedule_id = ? ORDER BY s.schedule_id, s.id, sl.rowid",
+		scheduleId, scheduleId,
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var curId string
+	var cur *SessionSelection
+	for res.Next() {
+		var sessionId string
+		var sel SessionSelection
+		var eventId sql.NullString
+		if err := res.Scan(&sessionId, &sel.ScheduleId, &sel.Date, &sel.Hash, &eventId); err != nil {
+			return err
+		}
+
+		if cur == nil || curId != sessionId || cur.ScheduleId != sel.ScheduleId {
+			if cur != nil {
+				if err := fn(curId, *cur); err != nil {
+					return err
+				}
+			}
+			sel.Events = make([]string, 0)
+			curId, cur = sessionId, &sel
+		}
+		if eventId.Valid {
+			cur.Events = append(cur.Events, eventId.String)
+		}
+	}
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	if cur != nil {
+		return fn(curId, *cur)
+	}
+	return nil
+}
+
+// CollectGarbage deletes history entries older than historyBefore, unless it
+// is zero, and then selections no session has, currently or in its history.
+// Legacy selections, whose hashes have no selection.HASH_PREFIX, are kept: they may
+// only be referenced by links shared before hashes were versioned. If dryRun
+// is set, nothing is deleted, and the counts of what would be are returned.
+func (db *DB) CollectGarbage(ctx context.Context, historyBefore time.Time, dryRun bool) (*GarbageStats, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := &GarbageStats{}
+
+	if !historyBefore.IsZero() {
+		res, err := tx.ExecContext(ctx,
+			"DELETE FROM session_history WHERE date < ?", historyBefore.Format(time.RFC3339Nano),
+		)
+		if err != nil {
+			return nil, err
+		}
+		count, _ := res.RowsAffected()
+		stats.History = int(count)
+	}
+
+	const unreferenced = "sl.selection_hash LIKE '" + selection.HASH_PREFIX + "%' " +
+		"AND NOT EXISTS (SELECT 1 FROM session s " +
+		"WHERE s.schedule_id = sl.schedule_id AND s.selection_hash = sl.selection_hash) " +
+		"AND NOT EXISTS (SELECT 1 FROM session_history h " +
+		"WHERE h.schedule_id = sl.schedule_id AND h.selection_hash = sl.selection_hash)"
+
+	row := tx.QueryRowContext(ctx,
+		"SELECT COUNT(1) FROM (SELECT DISTINCT schedule_id, selection_hash FROM schedule_selection sl WHERE "+unreferenced+")",
+	)
+	if err := row.Scan(&stats.Selections); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schedule_selection AS sl WHERE "+unreferenced); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return stats, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "collected garbage", "history", stats.History, "selections", stats.Selections)
+	return stats, nil
+}
+
+// PruneSessionHistory deletes history entries older than the given time. The
+// selections they referenced are left for CollectGarbage.
+func (db *DB) PruneSessionHistory(ctx context.Context, before time.Time) error {
+	_, err := db.writer.ExecContext(ctx,
+		"DELETE FROM session_history WHERE date < ?", before.Format(time.RFC3339Nano),
+	)
+	return err
+}
+
+// RevokeSession makes a session ID invalid. Its data is kept.
+func (db *DB) RevokeSession(ctx context.Context, sessionId string) error {
+	_, err := db.writer.ExecContext(ctx,
+		"INSERT INTO revoked_session VALUES (?, ?) ON CONFLICT DO NOTHING",
+		sessionId, time.Now().Format(time.RFC3339Nano),
+	)
+	return err
+}
+
+// IsSessionRevoked returns whether a session ID was revoked.
+func (db *DB) IsSessionRevoked(ctx context.Context, sessionId string) (bool, error) {
+	var count int
+	row := db.conn.QueryRowContext(ctx, "SELECT COUNT(1) FROM revoked_session WHERE id = ?", sessionId)
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Vacuum rebuilds the database file, returning unused space.
+func (db *DB) Vacuum(ctx context.Context) error {
+	_, err := db.writer.ExecContext(ctx, "VACUUM")
+	return err
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Attendance is an event a session checked in to.
+type Attendance struct {
+	ScheduleId string
+	EventId    string
+	Date       string
+}
+
+// SetAttended records that a session attended an event, or forgets it.
+// Checking in again keeps the first date.
+func (db *DB) SetAttended(ctx context.Context, sessionId string, scheduleId string, eventId string, attended bool) error {
+	var err error
+	if attended {
+		_, err = db.writer.ExecContext(ctx,
+			"INSERT INTO attendance VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			sessionId, scheduleId, eventId, time.Now().Format(time.RFC3339Nano),
+		)
+	} else {
+		_, err = db.writer.ExecContext(ctx,
+			"DELETE FROM attendance WHERE session_id = ? AND schedule_id = ? AND event_id = ?",
+			sessionId, scheduleId, eventId,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.DebugContext(ctx, "set attendance", "event", eventId, "attended", attended)
+	return nil
+}
+
+// GetSessionAttendance returns the events a session checked in to in a
+// schedule, in the order it checked in.
+func (db *DB) GetSessionAttendance(ctx context.Context, sessionId string, scheduleId string) ([]Attendance, error) {
+	return db.queryAttendance(ctx,
+		"SELECT schedule_id, event_id, date FROM attendance "+
+			"WHERE session_id = ? AND schedule_id = ? ORDER BY date, event_id",
+		sessionId, scheduleId,
+	)
+}
+
+func (db *DB) queryAttendance(ctx context.Context, query string, args ...any) ([]Attendance, error) {
+	res, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	attendance := make([]Attendance, 0)
+	for res.Next() {
+		var a Attendance
+		if err := res.Scan(&a.ScheduleId, &a.EventId, &a.Date); err != nil {
+			return nil, err
+		}
+		attendance = append(attendance, a)
+	}
+	return attendance, res.Err()
+}
+
+// GetAttendanceCounts returns the number of sessions that checked in to each
+// event and still have it bookmarked, so that it can be compared with the
+// event's selection count.
+func (db *DB) GetAttendanceCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT a.event_id, COUNT(1) FROM attendance a "+
+			"JOIN session s ON s.id = a.session_id AND s.schedule_id = a.schedule_id "+
+			"JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id "+
+			"AND sl.selection_hash = s.selection_hash AND sl.event_id = a.event_id "+
+			"WHERE a.schedule_id = ? GROUP BY a.event_id",
+		scheduleId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	counts := make(map[string]int)
+	for res.Next() {
+		var eventId string
+		var count int
+		if err := res.Scan(&eventId, &count); err != nil {
+			return nil, err
+		}
+		counts[eventId] = count
+	}
+	return counts, res.Err()
+}
+
+// rewriteAttendance applies rewrite to the event ID of every check-in in the
+// schedule, so that they keep matching the rewritten selections. Check-ins
+// whose event is dropped are left as they are.
+func rewriteAttendance(ctx context.Context, tx *sql.Tx, scheduleId string, rewrite func([]string) []string) error {
+	res, err := tx.QueryContext(ctx,
+		"SELECT DISTINCT event_id FROM attendance WHERE schedule_id = ?",
+		scheduleId,
+	)
+	if err != nil {
+		return err
+	}
+
+	eventIds := make([]string, 0)
+	for res.Next() {
+		var eventId string
+		if err := res.Scan(&eventId); err != nil {
+			res.Close()
+			return err
+		}
+		eventIds = append(eventIds, eventId)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	for _, eventId := range eventIds {
+		rewritten := rewrite([]string{eventId})
+		if len(rewritten) != 1 || rewritten[0] == eventId {
+			continue
+		}
+
+		// a session that checked in to both IDs keeps the new one
+		_, err := tx.ExecContext(ctx,
+			"UPDATE OR IGNORE attendance SET event_id = ? WHERE schedule_id = ? AND event_id = ?",
+			rewritten[0], scheduleId, eventId,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM attendance WHERE schedule_id = ? AND event_id = ?",
+			scheduleId, eventId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// SCHEMA_VERSION is the version of the tables created by Init, stored as the
+// database's user_version. It must be increased when they change.
+const SCHEMA_VERSION = 5
+
+var ErrNewerSchema = errors.New("database schema is newer than this version of the service")
+var ErrOlderSchema = errors.New("database schema is older than this version of the service")
+
+// requiredTables must exist in a database, whatever its version.
+var requiredTables = []string{"schedule_selection", "session", "session_history"}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist, while it may be in use.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	_, err := db.conn.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// CheckBackup checks that the file at path is an intact database this version
+// of the service can use, and returns its schema version. Databases from
+// before schema versions have version 0.
+func CheckBackup(ctx context.Context, path string) (int, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+
+	uri := url.URL{Scheme: "file", Path: absPath, RawQuery: "mode=ro"}
+	conn, err := sql.Open(DEFAULT_DRIVER, uri.String())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&result); err != nil {
+		return 0, err
+	} else if result != "ok" {
+		return 0, fmt.Errorf("database is corrupt: %s", result)
+	}
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > SCHEMA_VERSION {
+		return version, fmt.Errorf("%w: version %d, expected at most %d", ErrNewerSchema, version, SCHEMA_VERSION)
+	}
+
+	for _, table := range requiredTables {
+		var count int
+		if err := conn.QueryRowContext(ctx,
+			"SELECT COUNT(1) FROM sqlite_schema WHERE type = 'table' AND name = ?", table,
+		).Scan(&count); err != nil {
+			return version, err
+		}
+		if count == 0 {
+			return version, fmt.Errorf("database has no %s table", table)
+		}
+	}
+
+	return version, nil
+}
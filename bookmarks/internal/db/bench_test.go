@@ -0,0 +1,135 @@
+package db_test
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/selection"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const BENCH_EVENTS = 500
+
+// benchSelection returns a random selection of up to n events.
+func benchSelection(r *rand.Rand, n int) *selection.Selection {
+	eventIds := make([]string, 0, n)
+	for range n {
+		eventIds = append(eventIds, fmt.Sprintf("event-%d", r.IntN(BENCH_EVENTS)))
+	}
+	return selection.NewSelection(eventIds)
+}
+
+// newBaselineDB creates a database with the settings used before WAL, see
+// db.NewBaselineDB.
+func newBaselineDB(b *testing.B) *db.DB {
+	database := db.NewBaselineDB(path.Join(b.TempDir(), "baseline.sqlite"), []byte(HASH_KEY))
+	database.Init()
+	b.Cleanup(func() { database.Close() })
+	return database
+}
+
+// BenchmarkSaveSessionSelection simulates many sessions saving their
+// bookmarks at once, as in PUT /bookmarks, with the selection saved and set
+// in one transaction or in two, and in two with the settings used before WAL.
+// Saves that fail, e.g. with "database is locked", are reported as
+// errors/op.
+func BenchmarkSaveSessionSelection(b *testing.B) {
+	save := map[string]func(ctx context.Context, database *db.DB, sessionId string, sel *selection.Selection) error{
+		"combined": func(ctx context.Context, database *db.DB, sessionId string, sel *selection.Selection) error {
+			_, _, err := database.SaveSessionSelection(ctx, sessionId, SCHEDULE_ID, sel)
+			return err
+		},
+		"separate": func(ctx context.Context, database *db.DB, sessionId string, sel *selection.Selection) error {
+			hash, err := database.SaveSelection(ctx, SCHEDULE_ID, sel)
+			if err != nil {
+				return err
+			}
+			_, err = database.SetSessionSelection(ctx, sessionId, SCHEDULE_ID, hash)
+			return err
+		},
+	}
+	save["baseline"] = save["separate"]
+
+	for _, name := range []string{"baseline", "combined", "separate"} {
+		for _, size := range []int{5, 50} {
+			b.Run(fmt.Sprintf("%s/events=%d", name, size), func(b *testing.B) {
+				ctx := context.Background()
+				var database *db.DB
+				if name == "baseline" {
+					database = newBaselineDB(b)
+				} else {
+					database = newTestDB(b)
+				}
+				var sessions, failed atomic.Uint64
+
+				// many more goroutines than CPUs, like concurrent requests
+				b.SetParallelism(64)
+				b.RunParallel(func(pb *testing.PB) {
+					id := sessions.Add(1)
+					r := rand.New(rand.NewPCG(id, 0))
+					sessionId := fmt.Sprintf("session-%d", id)
+					for pb.Next() {
+						if err := save[name](ctx, database, sessionId, benchSelection(r, size)); err != nil {
+							failed.Add(1)
+						}
+					}
+				})
+				b.ReportMetric(float64(failed.Load())/float64(b.N), "errors/op")
+			})
+		}
+	}
+}
+
+// BenchmarkGetSessionSelection reads sessions' bookmarks while others are
+// saved.
+func BenchmarkGetSessionSelection(b *testing.B) {
+	ctx := context.Background()
+	database := newTestDB(b)
+
+	r := rand.New(rand.NewPCG(0, 0))
+	for i := range 1000 {
+		if _, _, err := database.SaveSessionSelection(ctx, fmt.Sprintf("session-%d", i), SCHEDULE_ID, benchSelection(r, 20)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := rand.New(rand.NewPCG(1, 0))
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, _, err := database.SaveSessionSelection(ctx, fmt.Sprintf("session-%d", i%1000), SCHEDULE_ID, benchSelection(r, 20)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	var readers atomic.Uint64
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(readers.Add(1), 1))
+		for pb.Next() {
+			if _, _, err := database.GetSessionSelection(ctx, fmt.Sprintf("session-%d", r.IntN(1000)), SCHEDULE_ID); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(done)
+	wg.Wait()
+}
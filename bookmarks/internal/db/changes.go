@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// DATE_FORMAT is a fixed width format, so dates compare correctly as text.
+const DATE_FORMAT = "2006-01-02T15:04:05.000000000Z07:00"
+
+// EventChange is an entry in a schedule's change log.
+type EventChange struct {
+	// Id orders changes in the order they were added, and is set when
+	// they are read.
+	Id      int64
+	EventId string
+	Title   string
+	Kind    string
+	Old     string
+	New     string
+	Date    time.Time
+}
+
+func (db *DB) AddEventChanges(ctx context.Context, scheduleId string, changes []EventChange) error {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO event_change (schedule_id, event_id, title, kind, old_value, new_value, date) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		if _, err := stmt.ExecContext(ctx,
+			scheduleId, change.EventId, change.Title, change.Kind, change.Old, change.New,
+			change.Date.UTC().Format(DATE_FORMAT),
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// EventChangeKey returns the key a change is ordered by, for a Page.
+func EventChangeKey(change EventChange) string {
+	return strconv.FormatInt(change.Id, 10)
+}
+
+// GetSessionEventChanges returns the page of changes since the given time to
+// events the session currently has bookmarked, oldest first. Removals are
+// matched like GetSessionRemovedEvents, as removed events are dropped from
+// selections saved after them.
+func (db *DB) GetSessionEventChanges(ctx context.Context, sessionId string, scheduleId string, since time.Time, page Page) ([]EventChange, error) {
+	var after int64
+	if page.After != "" {
+		var err error
+		if after, err = strconv.ParseInt(page.After, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT c.id, c.event_id, c.title, c.kind, c.old_value, c.new_value, c.date FROM event_change c "+
+			"WHERE c.schedule_id = ? AND c.date > ? AND c.id > ? AND (c.event_id IN ("+
+			"SELECT sl.event_id FROM schedule_selection sl "+
+			"JOIN session s ON s.schedule_id = sl.schedule_id AND s.selection_hash = sl.selection_hash "+
+			"WHERE s.schedule_id = ? AND s.id = ?"+
+			") OR c.kind = 'removed' AND c.event_id IN ("+
+			"SELECT event_id FROM removed_event_session WHERE schedule_id = ? AND session_id = ?"+
+			")) ORDER BY c.id LIMIT ?",
+		scheduleId, since.UTC().Format(DATE_FORMAT), after, scheduleId, sessionId, scheduleId, sessionId, page.sqlLimit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	changes := make([]EventChange, 0)
+	for res.Next() {
+		var change EventChange
+		var date string
+		if err := res.Scan(&change.Id, &change.EventId, &change.Title, &change.Kind, &change.Old, &change.New, &date); err != nil {
+			return nil, err
+		}
+		change.Date, err = time.Parse(DATE_FORMAT, date)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, res.Err()
+}
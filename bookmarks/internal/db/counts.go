@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// eventCountsQuery selects the number of sessions that bookmarked each event,
+// in the schedules matching the condition, computed from the sessions'
+// selections.
+const eventCountsQuery = "SELECT sl.schedule_id, sl.event_id, COUNT(1) FROM schedule_selection sl " +
+	"JOIN session s ON s.schedule_id = sl.schedule_id AND s.selection_hash = sl.selection_hash " +
+	"WHERE ?1 = '' OR s.schedule_id = ?1 GROUP BY sl.schedule_id, sl.event_id"
+
+// addEventCounts adds delta to the counts of the events in a selection.
+// Counts are not deleted when they reach zero.
+func addEventCounts(ctx context.Context, tx *sql.Tx, scheduleId string, hash string, delta int) error {
+	if delta == 0 || hash == "" {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO event_count SELECT schedule_id, event_id, ?1 FROM schedule_selection "+
+			"WHERE schedule_id = ?2 AND selection_hash = ?3 "+
+			"ON CONFLICT DO UPDATE SET count = count + excluded.count",
+		delta, scheduleId, hash,
+	)
+	return err
+}
+
+// removeSessionCounts subtracts the current selections of the sessions
+// matching cond, a condition on session s, from the event counts. It must be
+// called before they are deleted.
+func removeSessionCounts(ctx context.Context, tx *sql.Tx, cond string, args ...any) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO event_count SELECT sl.schedule_id, sl.event_id, -COUNT(1) FROM session s "+
+			"JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+			"WHERE "+cond+" GROUP BY sl.schedule_id, sl.event_id "+
+			"ON CONFLICT DO UPDATE SET count = count + excluded.count",
+		args...,
+	)
+	return err
+}
+
+// RebuildEventCounts recomputes the event counts of a schedule, or of every
+// schedule if scheduleId is empty, from the sessions' selections. It returns
+// the number of events whose count was wrong.
+func (db *DB) RebuildEventCounts(ctx context.Context, scheduleId string) (int, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed, err := rebuildEventCounts(ctx, tx, scheduleId)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "rebuilt event counts", "schedule_id", scheduleId, "changed", changed)
+	return changed, nil
+}
+
+func rebuildEventCounts(ctx context.Context, tx *sql.Tx, scheduleId string) (int, error) {
+	const stored = "SELECT schedule_id, event_id, count FROM event_count " +
+		"WHERE count > 0 AND (?1 = '' OR schedule_id = ?1)"
+
+	var changed int
+	row := tx.QueryRowContext(ctx,
+		"SELECT (SELECT COUNT(1) FROM ("+eventCountsQuery+" EXCEPT "+stored+")) + "+
+			"(SELECT COUNT(1) FROM (SELECT schedule_id, event_id FROM ("+stored+") "+
+			"EXCEPT SELECT schedule_id, event_id FROM ("+eventCountsQuery+")))",
+		scheduleId,
+	)
+	if err := row.Scan(&changed); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM event_count WHERE ?1 = '' OR schedule_id = ?1", scheduleId,
+	); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO event_count "+eventCountsQuery, scheduleId); err != nil {
+		return 0, err
+	}
+
+	return changed, nil
+}
@@ -2,29 +2,89 @@ package db
 
 import (
 	"bookmarks/internal/selection"
+	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// BUSY_TIMEOUT is how long, in milliseconds, a connection waits for a lock
+// held by another process, e.g. the admin command.
+const BUSY_TIMEOUT = 5000
+
+// READ_CONNS is the size of the read connection pool.
+const READ_CONNS = 8
+
+// INSERT_BATCH_SIZE is the number of rows inserted per statement.
+const INSERT_BATCH_SIZE = 250
+
 type DB struct {
+	// conn is used for reads. With WAL, they do not wait for writes.
 	conn *sql.DB
+	// writer is used for writes. SQLite allows one writer at a time, so it
+	// has a single connection, and writes wait for it in Go rather than
+	// failing with "database is locked".
+	writer *sql.DB
+	// hashKey keys the hashes of new selections.
+	hashKey []byte
+	// batchSize is the number of selection rows inserted per statement.
+	batchSize int
 }
 
-func NewDB(path string) *DB {
-	conn, err := sql.Open("sqlite3", path)
-	if err != nil {
-		panic(err)
+// NewDB opens the database at dbURL, a path or file: URI, optionally with
+// PURE_GO_PREFIX.
+func NewDB(dbURL string, hashKey []byte) *DB {
+	driver, path := parseURL(dbURL)
+	if path == ":memory:" {
+		// every connection would have its own database
+		conn := openConn(driver, path, 1)
+		return &DB{conn: conn, writer: conn, hashKey: hashKey, batchSize: INSERT_BATCH_SIZE}
 	}
 
 	return &DB{
-		conn: conn,
+		conn:      openConn(driver, path, READ_CONNS),
+		writer:    openConn(driver, path, 1),
+		hashKey:   hashKey,
+		batchSize: INSERT_BATCH_SIZE,
+	}
+}
+
+// OpenDB opens a database without creating or migrating its tables, for
+// commands that only read it. Databases that Init would migrate are rejected
+// with ErrOlderSchema.
+func OpenDB(ctx context.Context, dbURL string, hashKey []byte) (*DB, error) {
+	db := NewDB(dbURL, hashKey)
+
+	var version int
+	if err := db.conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < SCHEMA_VERSION {
+		db.Close()
+		return nil, fmt.Errorf("%w: version %d, expected %d", ErrOlderSchema, version, SCHEMA_VERSION)
+	}
+
+	return db, nil
+}
+
+// openConn opens a connection pool in WAL mode.
+func openConn(driver string, path string, maxConns int) *sql.DB {
+	conn, err := sql.Open(driver, dsn(driver, path))
+	if err != nil {
+		panic(err)
 	}
+
+	conn.SetMaxOpenConns(maxConns)
+	conn.SetMaxIdleConns(maxConns)
+	conn.SetConnMaxIdleTime(0)
+	return conn
 }
 
 func (db *DB) Init() {
-	if _, err := db.conn.Exec(
+	if _, err := db.writer.Exec(
 		"CREATE TABLE IF NOT EXISTS schedule_selection (" +
 			"schedule_id TEXT NOT NULL, " +
 			"selection_hash TEXT NOT NULL, " +
@@ -35,14 +95,14 @@ func (db *DB) Init() {
 		panic(err)
 	}
 
-	if _, err := db.conn.Exec(
+	if _, err := db.writer.Exec(
 		"CREATE INDEX IF NOT EXISTS ix_schedule_selection_event " +
 			"ON schedule_selection (schedule_id, event_id)",
 	); err != nil {
 		panic(err)
 	}
 
-	if _, err := db.conn.Exec(
+	if _, err := db.writer.Exec(
 		"CREATE TABLE IF NOT EXISTS session (" +
 			"id TEXT NOT NULL, " +
 			"schedule_id TEXT NOT NULL, " +
@@ -54,58 +114,335 @@ func (db *DB) Init() {
 		panic(err)
 	}
 
-	if _, err := db.conn.Exec(
+	if _, err := db.writer.Exec(
 		"CREATE INDEX IF NOT EXISTS ix_session_schedule_selection_hash " +
 			"ON session (schedule_id, selection_hash)",
 	); err != nil {
 		panic(err)
 	}
 
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS session_history (" +
+			"session_id TEXT NOT NULL, " +
+			"schedule_id TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"selection_hash TEXT NOT NULL" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_session_history_session " +
+			"ON session_history (schedule_id, session_id)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS removed_event (" +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"title TEXT NOT NULL, " +
+			"start TEXT NOT NULL, " +
+			"\"end\" TEXT NOT NULL, " +
+			"location TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, event_id)" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	// the sessions that had each removed event bookmarked when it was removed
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS removed_event_session (" +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"session_id TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, event_id, session_id)" +
+			") WITHOUT ROWID;",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_removed_event_session_session_id " +
+			"ON removed_event_session (session_id)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS event_change (" +
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"title TEXT NOT NULL, " +
+			"kind TEXT NOT NULL, " +
+			"old_value TEXT NOT NULL, " +
+			"new_value TEXT NOT NULL, " +
+			"date TEXT NOT NULL" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_event_change_schedule_date " +
+			"ON event_change (schedule_id, date)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS push_subscription (" +
+			"schedule_id TEXT NOT NULL, " +
+			"session_id TEXT NOT NULL, " +
+			"endpoint TEXT NOT NULL, " +
+			"p256dh TEXT NOT NULL, " +
+			"auth TEXT NOT NULL, " +
+			"reminder_minutes INTEGER NOT NULL, " +
+			"change_alerts INTEGER NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, session_id, endpoint)" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS push_sent (" +
+			"schedule_id TEXT NOT NULL, " +
+			"endpoint TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"kind TEXT NOT NULL, " +
+			"value TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, endpoint, event_id, kind, value)" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS webhook_delivery (" +
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+			"schedule_id TEXT NOT NULL, " +
+			"url TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"event_type TEXT NOT NULL, " +
+			"attempt INTEGER NOT NULL, " +
+			"status INTEGER NOT NULL, " +
+			"error TEXT NOT NULL, " +
+			"date TEXT NOT NULL" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS webhook_threshold (" +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"threshold INTEGER NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, event_id, threshold)" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_session_history_session_id " +
+			"ON session_history (session_id)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS session_link (" +
+			"old_id TEXT NOT NULL, " +
+			"schedule_id TEXT NOT NULL, " +
+			"new_id TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (old_id, schedule_id)" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_session_history_selection_hash " +
+			"ON session_history (schedule_id, selection_hash)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS revoked_session (" +
+			"id TEXT NOT NULL PRIMARY KEY, " +
+			"date TEXT NOT NULL" +
+			");",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS event_count (" +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"count INTEGER NOT NULL, " +
+			"PRIMARY KEY (schedule_id, event_id)" +
+			") WITHOUT ROWID;",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS attendance (" +
+			"session_id TEXT NOT NULL, " +
+			"schedule_id TEXT NOT NULL, " +
+			"event_id TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (session_id, schedule_id, event_id)" +
+			") WITHOUT ROWID;",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE INDEX IF NOT EXISTS ix_attendance_schedule_event " +
+			"ON attendance (schedule_id, event_id)",
+	); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.writer.Exec(
+		"CREATE TABLE IF NOT EXISTS event_list (" +
+			"schedule_id TEXT NOT NULL, " +
+			"version TEXT NOT NULL, " +
+			"event_ids TEXT NOT NULL, " +
+			"date TEXT NOT NULL, " +
+			"PRIMARY KEY (schedule_id, version)" +
+			") WITHOUT ROWID;",
+	); err != nil {
+		panic(err)
+	}
+
+	var version int
+	if err := db.writer.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		panic(err)
+	}
+	if version > SCHEMA_VERSION {
+		slog.Warn("database schema is newer than this version of the service", "version", version, "expected", SCHEMA_VERSION)
+		return
+	}
+
+	// event counts are kept from version 2
+	if version < 2 {
+		tx, err := db.writer.Begin()
+		if err != nil {
+			panic(err)
+		}
+		defer tx.Rollback()
+
+		if _, err := rebuildEventCounts(context.Background(), tx, ""); err != nil {
+			panic(err)
+		}
+		if err := tx.Commit(); err != nil {
+			panic(err)
+		}
+	}
+
+	// the sessions of removed events are kept from version 5. For earlier
+	// removals, the selections at the time are not known, so the current
+	// ones are used.
+	if version < 5 {
+		if _, err := db.writer.Exec(
+			"INSERT OR IGNORE INTO removed_event_session " +
+				"SELECT r.schedule_id, r.event_id, s.id FROM removed_event r " +
+				"JOIN session s ON s.schedule_id = r.schedule_id " +
+				"JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash " +
+				"AND sl.event_id = r.event_id",
+		); err != nil {
+			panic(err)
+		}
+	}
+
+	if _, err := db.writer.Exec(fmt.Sprintf("PRAGMA user_version = %d", SCHEMA_VERSION)); err != nil {
+		panic(err)
+	}
 }
 
 func (db *DB) Close() error {
+	if db.writer != db.conn {
+		if err := db.writer.Close(); err != nil {
+			db.conn.Close()
+			return err
+		}
+	}
 	return db.conn.Close()
 }
 
-func (db *DB) SaveSelection(scheduleId string, set *selection.Selection) (string, error) {
-	hash := set.Hash()
-
-	tx, err := db.conn.Begin()
+func (db *DB) SaveSelection(ctx context.Context, scheduleId string, set *selection.Selection) (string, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
 
-	cur := tx.QueryRow("SELECT COUNT(1) FROM schedule_selection WHERE schedule_id = ? AND selection_hash = ?", scheduleId, hash)
+	hash, err := db.saveSelection(ctx, tx, scheduleId, set)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	slog.DebugContext(ctx, "saved selection", "hash", hash, "events", len(set.GetEventIds()))
+	return hash, nil
+}
+
+// saveSelection stores the selection with a hash in the current scheme. The
+// same events may also be stored under a legacy hash.
+func (db *DB) saveSelection(ctx context.Context, tx *sql.Tx, scheduleId string, set *selection.Selection) (string, error) {
+	hash := set.Hash(db.hashKey)
+
+	cur := tx.QueryRowContext(ctx, "SELECT COUNT(1) FROM schedule_selection WHERE schedule_id = ? AND selection_hash = ?", scheduleId, hash)
 	var curCount int
-	err = cur.Scan(&curCount)
+	err := cur.Scan(&curCount)
 	if err == nil && curCount > 0 {
 		return hash, nil
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO schedule_selection VALUES (?, ?, ?) ON CONFLICT DO NOTHING")
-	if err != nil {
-		return "", err
-	}
+	eventIds := set.GetEventIds()
+	for start := 0; start < len(eventIds); start += db.batchSize {
+		batch := eventIds[start:min(start+db.batchSize, len(eventIds))]
 
-	for _, eventId := range set.GetEventIds() {
-		if _, err := stmt.Exec(scheduleId, hash, eventId); err != nil {
-			return "", err
+		args := make([]any, 0, len(batch)*3)
+		for _, eventId := range batch {
+			args = append(args, scheduleId, hash, eventId)
 		}
-	}
 
-	if err = tx.Commit(); err != nil {
-		return "", err
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schedule_selection VALUES "+strings.Repeat("(?, ?, ?), ", len(batch)-1)+"(?, ?, ?) ON CONFLICT DO NOTHING",
+			args...,
+		); err != nil {
+			return "", err
+		}
 	}
 
 	return hash, nil
 }
 
-func (db *DB) GetSelection(scheduleId string, hash string) (*selection.Selection, error) {
-	res, err := db.conn.Query("SELECT event_id FROM schedule_selection WHERE schedule_id = ? AND selection_hash = ?", scheduleId, hash)
+func (db *DB) GetSelection(ctx context.Context, scheduleId string, hash string) (*selection.Selection, error) {
+	res, err := db.conn.QueryContext(ctx, "SELECT event_id FROM schedule_selection WHERE schedule_id = ? AND selection_hash = ?", scheduleId, hash)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Close()
 
 	results := make([]string, 0)
 
@@ -117,30 +454,94 @@ func (db *DB) GetSelection(scheduleId string, hash string) (*selection.Selection
 		results = append(results, event)
 	}
 
-	return selection.NewSelection(results), nil
+	return selection.LoadSelection(hash, results), nil
 }
 
-func (db *DB) SetSessionSelection(sessionId string, scheduleId string, hash string) (string, error) {
-	tx, err := db.conn.Begin()
+func (db *DB) SetSessionSelection(ctx context.Context, sessionId string, scheduleId string, hash string) (string, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
 
+	now, err := setSessionSelection(ctx, tx, sessionId, scheduleId, hash)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	slog.DebugContext(ctx, "set session selection", "hash", hash)
+	return now, nil
+}
+
+// SaveSessionSelection saves a selection and sets it as the session's, in one
+// transaction. It returns the selection's hash and the date.
+func (db *DB) SaveSessionSelection(ctx context.Context, sessionId string, scheduleId string, set *selection.Selection) (string, string, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	hash, err := db.saveSelection(ctx, tx, scheduleId, set)
+	if err != nil {
+		return "", "", err
+	}
+
+	now, err := setSessionSelection(ctx, tx, sessionId, scheduleId, hash)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	slog.DebugContext(ctx, "saved session selection", "hash", hash, "events", len(set.GetEventIds()))
+	return hash, now, nil
+}
+
+func setSessionSelection(ctx context.Context, tx *sql.Tx, sessionId string, scheduleId string, hash string) (string, error) {
 	now := time.Now().Format(time.RFC3339Nano)
 
-	if _, err = tx.Exec(
+	var oldHash string
+	row := tx.QueryRowContext(ctx, "SELECT selection_hash FROM session WHERE id = ? AND schedule_id = ?", sessionId, scheduleId)
+	if err := row.Scan(&oldHash); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if oldHash != hash {
+		if err := addEventCounts(ctx, tx, scheduleId, oldHash, -1); err != nil {
+			return "", err
+		}
+		if err := addEventCounts(ctx, tx, scheduleId, hash, 1); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
 		"INSERT INTO session VALUES (?, ?, ?, ?) ON CONFLICT DO UPDATE SET selection_hash = ?, date = ?", sessionId, scheduleId, now, hash, hash, now,
 	); err != nil {
 		return "", err
 	}
-	tx.Commit()
+
+	if oldHash == hash {
+		return now, nil
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO session_history VALUES (?, ?, ?, ?)", sessionId, scheduleId, now, hash,
+	); err != nil {
+		return "", err
+	}
 
 	return now, nil
 }
 
-func (db *DB) GetSessionSelection(sessionId string, scheduleId string) (*selection.Selection, string, error) {
-	hashRow := db.conn.QueryRow("SELECT selection_hash, date FROM session WHERE schedule_id = ? AND id = ?", scheduleId, sessionId)
+func (db *DB) GetSessionSelection(ctx context.Context, sessionId string, scheduleId string) (*selection.Selection, string, error) {
+	hashRow := db.conn.QueryRowContext(ctx, "SELECT selection_hash, date FROM session WHERE schedule_id = ? AND id = ?", scheduleId, sessionId)
 	var hash string
 	var date string
 	var err error
@@ -152,22 +553,20 @@ func (db *DB) GetSessionSelection(sessionId string, scheduleId string) (*selecti
 		return nil, "", nil
 	}
 
-	selection, err := db.GetSelection(scheduleId, hash)
+	selection, err := db.GetSelection(ctx, scheduleId, hash)
 
 	return selection, date, err
 }
 
-func (db *DB) GetEventSelectionCounts(scheduleId string) (map[string]int, error) {
-	res, err := db.conn.Query(
-		"SELECT sl.event_id, COUNT(1) FROM schedule_selection sl "+
-			"JOIN session s ON s.schedule_id = sl.schedule_ID "+
-			"AND s.selection_hash=sl.selection_hash "+
-			"WHERE s.schedule_id = ? GROUP BY sl.event_id",
+func (db *DB) GetEventSelectionCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT event_id, count FROM event_count WHERE schedule_id = ? AND count > 0",
 		scheduleId,
 	)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Close()
 
 	counts := make(map[string]int)
 	for res.Next() {
@@ -182,3 +581,94 @@ func (db *DB) GetEventSelectionCounts(scheduleId string) (map[string]int, error)
 
 	return counts, nil
 }
+
+// GetEventSelectionCount returns the number of sessions that bookmarked an
+// event.
+func (db *DB) GetEventSelectionCount(ctx context.Context, scheduleId string, eventId string) (int, error) {
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(count), 0) FROM event_count WHERE schedule_id = ? AND event_id = ? AND count > 0",
+		scheduleId, eventId,
+	)
+
+	var count int
+	err := row.Scan(&count)
+	return count, err
+}
+
+// RewriteSelections applies rewrite to the event IDs of every selection in the
+// schedule. Changed selections are saved under their new hash and sessions
+// are moved to it, and check-ins are moved to the rewritten event IDs. The
+// old selections are kept so shared links still work. It returns the number
+// of changed selections.
+func (db *DB) RewriteSelections(ctx context.Context, scheduleId string, rewrite func([]string) []string) (int, error) {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.QueryContext(ctx,
+		"SELECT selection_hash, event_id FROM schedule_selection WHERE schedule_id = ? ORDER BY selection_hash",
+		scheduleId,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	selections := make(map[string][]string)
+	for res.Next() {
+		var hash string
+		var eventId string
+		if err := res.Scan(&hash, &eventId); err != nil {
+			res.Close()
+			return 0, err
+		}
+		selections[hash] = append(selections[hash], eventId)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for hash, eventIds := range selections {
+		newSel := selection.NewSelection(rewrite(eventIds))
+		if newSel.Hash(db.hashKey) == selection.NewSelection(eventIds).Hash(db.hashKey) {
+			continue
+		}
+
+		newHash, err := db.saveSelection(ctx, tx, scheduleId, newSel)
+		if err != nil {
+			return 0, err
+		}
+
+		moved, err := tx.ExecContext(ctx,
+			"UPDATE session SET selection_hash = ? WHERE schedule_id = ? AND selection_hash = ?",
+			newHash, scheduleId, hash,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		sessions, _ := moved.RowsAffected()
+		if err := addEventCounts(ctx, tx, scheduleId, hash, -int(sessions)); err != nil {
+			return 0, err
+		}
+		if err := addEventCounts(ctx, tx, scheduleId, newHash, int(sessions)); err != nil {
+			return 0, err
+		}
+
+		slog.DebugContext(ctx, "rewrote selection", "hash", hash, "new_hash", newHash)
+		changed++
+	}
+
+	if err := rewriteAttendance(ctx, tx, scheduleId, rewrite); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return changed, nil
+}
@@ -2,34 +2,50 @@ package db_test
 
 import (
 	"bookmarks/internal/db"
+	"bookmarks/internal/db/storetest"
 	"bookmarks/internal/selection"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"path"
 	"slices"
+	"sync"
 	"testing"
+	"time"
 )
 
 const SCHEDULE_ID = "test-schedule"
 const SESSION_ID = "test-session"
+const HASH_KEY = "test-key"
 
-func TestDB(t *testing.T) {
-	fn, err := os.CreateTemp(".", "*.sqlite")
+func newTestDB(t testing.TB) *db.DB {
+	fn, err := os.CreateTemp(t.TempDir(), "*.sqlite")
 	if err != nil {
 		t.Fatalf("error: %v", err)
 	}
-	defer fn.Close()
-	defer os.Remove(fn.Name())
+	fn.Close()
+
+	database := db.NewDB(fn.Name(), []byte(HASH_KEY))
+	database.Init()
+	t.Cleanup(func() { database.Close() })
+	return database
+}
 
-	db := db.NewDB(fn.Name())
-	db.Init()
+func TestDB(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
 
 	selection := selection.NewSelection([]string{"e1", "e2", "e3"})
 
-	hash, err := db.SaveSelection(SCHEDULE_ID, selection)
+	hash, err := db.SaveSelection(ctx, SCHEDULE_ID, selection)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	retrieved, err := db.GetSelection(SCHEDULE_ID, hash)
+	retrieved, err := db.GetSelection(ctx, SCHEDULE_ID, hash)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -38,17 +54,17 @@ func TestDB(t *testing.T) {
 		t.Fatalf("expected %v, got %v", selection.GetEventIds(), retrieved.GetEventIds())
 	}
 
-	_, err = db.SaveSelection(SCHEDULE_ID, selection)
+	_, err = db.SaveSelection(ctx, SCHEDULE_ID, selection)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	date, err := db.SetSessionSelection(SESSION_ID, SCHEDULE_ID, hash)
+	date, err := db.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, hash)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	retrieved, retrievedDate, err := db.GetSessionSelection(SESSION_ID, SCHEDULE_ID)
+	retrieved, retrievedDate, err := db.GetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,3 +77,848 @@ func TestDB(t *testing.T) {
 		t.Fatalf("expected %v, got %v", date, retrievedDate)
 	}
 }
+
+func TestRewriteSelections(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	oldSel := selection.NewSelection([]string{"e1", "old"})
+	oldHash, err := db.SaveSelection(ctx, SCHEDULE_ID, oldSel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, oldHash); err != nil {
+		t.Fatal(err)
+	}
+	for _, eventId := range []string{"e1", "old"} {
+		if err := db.SetAttended(ctx, SESSION_ID, SCHEDULE_ID, eventId, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := db.RewriteSelections(ctx, SCHEDULE_ID, func(eventIds []string) []string {
+		res := make([]string, 0, len(eventIds))
+		for _, id := range eventIds {
+			if id == "old" {
+				id = "new"
+			}
+			res = append(res, id)
+		}
+		return res
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed != 1 {
+		t.Fatalf("expected 1 changed selection, got %d", changed)
+	}
+
+	retrieved, _, err := db.GetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := selection.NewSelection([]string{"e1", "new"})
+	if retrieved.Hash(nil) != expected.Hash(nil) {
+		t.Fatalf("expected %v, got %v", expected.GetEventIds(), retrieved.GetEventIds())
+	}
+
+	shared, err := db.GetSelection(ctx, SCHEDULE_ID, oldHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shared.Hash([]byte(HASH_KEY)) != oldHash {
+		t.Fatalf("expected old selection to be kept, got %v", shared.GetEventIds())
+	}
+
+	// check-ins follow the rewritten event IDs
+	counts, err := db.GetAttendanceCounts(ctx, SCHEDULE_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(counts, map[string]int{"e1": 1, "new": 1}) {
+		t.Fatalf("expected check-ins for e1 and new, got %v", counts)
+	}
+}
+
+func TestLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	defer database.Close()
+
+	// a selection saved before hashes were versioned
+	legacySel := selection.NewSelection([]string{"e1", "e2"})
+	conn, err := sql.Open(db.DEFAULT_DRIVER, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, eventId := range legacySel.GetEventIds() {
+		if _, err := conn.Exec("INSERT INTO schedule_selection VALUES (?, ?, ?)", SCHEDULE_ID, legacySel.LegacyHash(), eventId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retrieved, err := database.GetSelection(ctx, SCHEDULE_ID, legacySel.LegacyHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(retrieved.GetEventIds(), legacySel.GetEventIds()) || retrieved.Id() != legacySel.LegacyHash() {
+		t.Fatalf("expected legacy selection, got %v", retrieved.GetEventIds())
+	}
+
+	hash, err := database.SaveSelection(ctx, SCHEDULE_ID, legacySel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != legacySel.Hash([]byte(HASH_KEY)) {
+		t.Fatalf("expected a hash in the current scheme, got %s", hash)
+	}
+}
+
+func TestRemovedEvents(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	for _, events := range [][]string{{"e1", "e2"}, {"e2", "e3"}} {
+		hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection(events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// e1 was un-bookmarked before it was removed
+	err := database.SetRemovedEvents(ctx, SCHEDULE_ID, []db.RemovedEvent{
+		{EventId: "e1", Title: "Event 1", Date: "2025-01-01T00:00:00Z"},
+		{EventId: "e2", Title: "Event 2", Date: "2025-01-01T00:00:00Z"},
+		{EventId: "e3", Title: "Event 3", Date: "2025-01-01T00:00:00Z"},
+		{EventId: "e4", Title: "Event 4", Date: "2025-01-01T00:00:00Z"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.SetRemovedEvents(ctx, SCHEDULE_ID, nil, []string{"e3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := database.GetSessionRemovedEvents(ctx, SESSION_ID, SCHEDULE_ID, db.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 1 || removed[0].EventId != "e2" || removed[0].Title != "Event 2" {
+		t.Fatalf("expected e2 to be removed, got %v", removed)
+	}
+}
+
+func TestRemovedEventSessionsMigration(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	t.Cleanup(func() { database.Close() })
+
+	if _, _, err := database.SaveSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, selection.NewSelection([]string{"e1"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetRemovedEvents(ctx, SCHEDULE_ID, []db.RemovedEvent{{EventId: "e1", Title: "Event 1"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// removals from before version 5 are matched with the current selections
+	conn, err := sql.Open(db.DEFAULT_DRIVER, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("DELETE FROM removed_event_session; PRAGMA user_version = 4"); err != nil {
+		t.Fatal(err)
+	}
+	database.Init()
+
+	removed, err := database.GetSessionRemovedEvents(ctx, SESSION_ID, SCHEDULE_ID, db.Page{})
+	if err != nil || len(removed) != 1 || removed[0].EventId != "e1" {
+		t.Fatalf("expected e1 to be removed, got %v, %v", removed, err)
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+
+	// the tables are not created
+	if _, err := db.OpenDB(ctx, dbPath, []byte(HASH_KEY)); !errors.Is(err, db.ErrOlderSchema) {
+		t.Fatalf("expected ErrOlderSchema, got %v", err)
+	}
+
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	database.Close()
+
+	opened, err := db.OpenDB(ctx, dbPath, []byte(HASH_KEY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if _, err := opened.GetStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventChanges(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	err = database.AddEventChanges(ctx, SCHEDULE_ID, []db.EventChange{
+		{EventId: "e1", Kind: "location", Old: "Room 1", New: "Room 2", Date: start},
+		{EventId: "e2", Kind: "location", Old: "Room 1", New: "Room 2", Date: start},
+		{EventId: "e1", Kind: "title", Old: "Event", New: "Event 1", Date: start.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := database.GetSessionEventChanges(ctx, SESSION_ID, SCHEDULE_ID, time.Time{}, db.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 2 || changes[0].Kind != "location" || changes[1].Kind != "title" {
+		t.Fatalf("expected 2 changes to e1, got %v", changes)
+	}
+
+	changes, err = database.GetSessionEventChanges(ctx, SESSION_ID, SCHEDULE_ID, start, db.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 1 || !changes[0].Date.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected 1 change since %v, got %v", start, changes)
+	}
+}
+
+func TestEventLists(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	for i := range selection.MAX_EVENT_LISTS + 1 {
+		if err := database.SaveEventList(ctx, SCHEDULE_ID, fmt.Sprint(i), []string{fmt.Sprint("e", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the newest lists are kept
+	if eventIds, err := database.GetEventList(ctx, SCHEDULE_ID, "0"); err != nil || eventIds != nil {
+		t.Fatalf("expected the oldest list to be deleted, got %v, %v", eventIds, err)
+	}
+	if eventIds, err := database.GetEventList(ctx, SCHEDULE_ID, "1"); err != nil || !slices.Equal(eventIds, []string{"e1"}) {
+		t.Fatalf("expected [e1], got %v, %v", eventIds, err)
+	}
+}
+
+func TestPushSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1", "e2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []db.PushSubscription{
+		{SessionId: SESSION_ID, Endpoint: "https://push.example.com/1", ReminderMinutes: 5},
+		{SessionId: SESSION_ID, Endpoint: "https://push.example.com/1", ReminderMinutes: 15, ChangeAlerts: true},
+		{SessionId: "other-session", Endpoint: "https://push.example.com/2"},
+	} {
+		if err := database.SetPushSubscription(ctx, SCHEDULE_ID, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, err := database.GetPushSubscriptions(ctx, SCHEDULE_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subs) != 2 || subs[0].SessionId != "other-session" || len(subs[0].Events) != 0 {
+		t.Fatalf("expected 2 subscriptions, got %v", subs)
+	}
+
+	if subs[1].ReminderMinutes != 15 || !subs[1].ChangeAlerts || !slices.Equal(subs[1].Events, []string{"e1", "e2"}) {
+		t.Fatalf("expected updated subscription with events [e1 e2], got %v", subs[1])
+	}
+
+	for i, expected := range []bool{true, false} {
+		sent, err := database.MarkPushSent(ctx, SCHEDULE_ID, "https://push.example.com/1", "e1", "reminder", "2025-01-01T12:00:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != expected {
+			t.Fatalf("expected %v for attempt %d, got %v", expected, i+1, sent)
+		}
+	}
+
+	if err := database.DeletePushSubscription(ctx, SCHEDULE_ID, SESSION_ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err = database.GetPushSubscriptions(ctx, SCHEDULE_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subs) != 1 {
+		t.Fatalf("expected 1 subscription, got %v", subs)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sessionId := range []string{SESSION_ID, "other-session"} {
+		if _, err := database.SetSessionSelection(ctx, sessionId, SCHEDULE_ID, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := database.GetEventSelectionCount(ctx, SCHEDULE_ID, "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected count 2, got %d", count)
+	}
+
+	for i, expected := range []bool{true, false} {
+		crossed, err := database.MarkCountThreshold(ctx, SCHEDULE_ID, "e1", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crossed != expected {
+			t.Fatalf("expected %v for attempt %d, got %v", expected, i+1, crossed)
+		}
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := database.AddWebhookDelivery(ctx, db.WebhookDelivery{
+			ScheduleId: SCHEDULE_ID, URL: "https://example.com/hook", EventId: "ev1",
+			EventType: "feed.changed", Attempt: attempt, Date: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := database.GetWebhookDeliveries(ctx, SCHEDULE_ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Attempt != 2 {
+		t.Fatalf("expected 2 deliveries, newest first, got %v", deliveries)
+	}
+
+	if err := database.PruneWebhookDeliveries(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err = database.GetWebhookDeliveries(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Fatalf("expected deliveries to be pruned, got %v", deliveries)
+	}
+}
+
+func TestMergeSessions(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	for _, sel := range []struct {
+		sessionId  string
+		scheduleId string
+		events     []string
+	}{
+		{"old", "s1", []string{"e1"}},
+		{"old", "s2", []string{"e2"}},
+		{"global", "s2", []string{"e3"}},
+	} {
+		hash, err := database.SaveSelection(ctx, sel.scheduleId, selection.NewSelection(sel.events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.SetSessionSelection(ctx, sel.sessionId, sel.scheduleId, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := database.MergeSessions(ctx, "old", "global", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	schedules, err := database.GetSessionSchedules(ctx, "global")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 {
+		t.Fatalf("expected 2 schedules, got %v", schedules)
+	}
+
+	// the target's selection is kept
+	sel, _, err := database.GetSessionSelection(ctx, "global", "s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sel.GetEventIds(), []string{"e3"}) {
+		t.Fatalf("expected [e3], got %v", sel.GetEventIds())
+	}
+
+	if err := database.MergeSessions(ctx, "global", "newest", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"old", "global"} {
+		resolved, err := database.ResolveSessionLink(ctx, id, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved != "newest" {
+			t.Fatalf("expected %s to resolve to newest, got %s", id, resolved)
+		}
+	}
+
+	if resolved, _ := database.ResolveSessionLink(ctx, "unlinked", "s1"); resolved != "unlinked" {
+		t.Fatalf("expected unlinked ID to be unchanged, got %s", resolved)
+	}
+}
+
+func TestDeleteSessionData(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	shared, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1", "e2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another session bookmarked the shared selection, then moved on
+	for _, set := range []struct {
+		sessionId string
+		hash      string
+	}{{"other-session", shared}, {"other-session", own}, {SESSION_ID, shared}, {SESSION_ID, own}} {
+		if _, err := database.SetSessionSelection(ctx, set.sessionId, SCHEDULE_ID, set.hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.SetSessionSelection(ctx, "other-session", SCHEDULE_ID, shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.MergeSessions(ctx, "old-session", SESSION_ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := database.GetSessionData(ctx, SESSION_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Selections) != 1 || !slices.Equal(data.Selections[0].Events, []string{"e1", "e2"}) {
+		t.Fatalf("expected current selection [e1 e2], got %v", data.Selections)
+	}
+	if len(data.History) != 2 || data.History[0].Hash != shared {
+		t.Fatalf("expected 2 history entries, got %v", data.History)
+	}
+	if !slices.Equal(data.LinkedIds, []string{"old-session"}) {
+		t.Fatalf("expected linked old-session, got %v", data.LinkedIds)
+	}
+
+	if err := database.DeleteSessionData(ctx, SESSION_ID); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = database.GetSessionData(ctx, SESSION_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Selections) != 0 || len(data.History) != 0 || len(data.LinkedIds) != 0 {
+		t.Fatalf("expected session to be erased, got %v", data)
+	}
+
+	// the other session's selections, current and previous, are kept
+	for _, hash := range []string{shared, own} {
+		sel, err := database.GetSelection(ctx, SCHEDULE_ID, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sel.GetEventIds()) == 0 {
+			t.Fatalf("expected selection %s to be kept", hash)
+		}
+	}
+
+	if err := database.DeleteSessionData(ctx, "other-session"); err != nil {
+		t.Fatal(err)
+	}
+
+	sel, err := database.GetSelection(ctx, SCHEDULE_ID, own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel.GetEventIds()) != 0 {
+		t.Fatalf("expected orphaned selection to be deleted, got %v", sel.GetEventIds())
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	defer database.Close()
+
+	for _, events := range [][]string{{"e1"}, {"e1", "e2"}} {
+		hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection(events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.SetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a selection no session has
+	if _, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e3"})); err != nil {
+		t.Fatal(err)
+	}
+
+	// a selection saved before hashes were versioned, which only a shared
+	// link may have
+	legacySel := selection.NewSelection([]string{"e4"})
+	conn, err := sql.Open(db.DEFAULT_DRIVER, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("INSERT INTO schedule_selection VALUES (?, ?, ?)", SCHEDULE_ID, legacySel.LegacyHash(), "e4"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := database.CollectGarbage(ctx, time.Time{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.History != 0 || stats.Selections != 1 {
+		t.Fatalf("expected 1 selection, got %v", stats)
+	}
+
+	stats, err = database.CollectGarbage(ctx, time.Now().Add(time.Minute), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.History != 2 || stats.Selections != 2 {
+		t.Fatalf("expected 2 history entries and 2 selections, got %v", stats)
+	}
+
+	sel, _, err := database.GetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sel.GetEventIds(), []string{"e1", "e2"}) {
+		t.Fatalf("expected the current selection to be kept, got %v", sel.GetEventIds())
+	}
+
+	shared, err := database.GetSelection(ctx, SCHEDULE_ID, legacySel.LegacyHash())
+	if err != nil || !slices.Equal(shared.GetEventIds(), []string{"e4"}) {
+		t.Fatalf("expected the legacy selection to be kept, got %v, %v", shared, err)
+	}
+
+	dbStats, err := database.GetStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbStats.Sessions != 1 || len(dbStats.Schedules) != 1 || dbStats.Schedules[0].Selections != 2 {
+		t.Fatalf("unexpected stats %v", dbStats)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	if err := database.RevokeSession(ctx, SESSION_ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for sessionId, expected := range map[string]bool{SESSION_ID: true, "other": false} {
+		revoked, err := database.IsSessionRevoked(ctx, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != expected {
+			t.Fatalf("expected %s revoked to be %v", sessionId, expected)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	hash, err := database.SaveSelection(ctx, SCHEDULE_ID, selection.NewSelection([]string{"e1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := path.Join(t.TempDir(), "backup dir", "backup.sqlite")
+	os.Mkdir(path.Dir(backupPath), 0o755)
+	if err := database.Backup(ctx, backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := db.CheckBackup(ctx, backupPath)
+	if err != nil || version != db.SCHEMA_VERSION {
+		t.Fatalf("expected version %d, got %d, %v", db.SCHEMA_VERSION, version, err)
+	}
+
+	restored := db.NewDB(backupPath, []byte(HASH_KEY))
+	defer restored.Close()
+	sel, err := restored.GetSelection(ctx, SCHEDULE_ID, hash)
+	if err != nil || !slices.Equal(sel.GetEventIds(), []string{"e1"}) {
+		t.Fatalf("expected the selection in the backup, got %v, %v", sel, err)
+	}
+
+	conn, err := sql.Open(db.DEFAULT_DRIVER, backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("PRAGMA user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CheckBackup(ctx, backupPath); !errors.Is(err, db.ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+
+	notDB := path.Join(t.TempDir(), "notdb.sqlite")
+	os.WriteFile(notDB, []byte("not a database"), 0o644)
+	if _, err := db.CheckBackup(ctx, notDB); err == nil {
+		t.Fatal("expected an error for an invalid file")
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2000)
+	for i := range 2000 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sessionId := fmt.Sprintf("session-%d", i%500)
+			sel := selection.NewSelection([]string{fmt.Sprintf("e%d", i%7), fmt.Sprintf("e%d", i%11)})
+			if _, _, err := database.SaveSessionSelection(ctx, sessionId, SCHEDULE_ID, sel); err != nil {
+				errs <- err
+				return
+			}
+			if _, _, err := database.GetSessionSelection(ctx, sessionId, SCHEDULE_ID); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stats, err := database.GetStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sessions != 500 || stats.Schedules[0].History != 2000 {
+		t.Fatalf("unexpected stats %v", stats.Schedules)
+	}
+
+	if changed, err := database.RebuildEventCounts(ctx, ""); err != nil || changed != 0 {
+		t.Fatalf("expected no changes on rebuild, got %d, %v", changed, err)
+	}
+}
+
+func TestEventCounts(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	defer database.Close()
+
+	expectCounts := func(expected map[string]int) {
+		t.Helper()
+		counts, err := database.GetEventSelectionCounts(ctx, SCHEDULE_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != len(expected) {
+			t.Fatalf("expected counts %v, got %v", expected, counts)
+		}
+		for eventId, count := range expected {
+			if counts[eventId] != count {
+				t.Fatalf("expected counts %v, got %v", expected, counts)
+			}
+		}
+
+		// the incremental counts match a recount
+		if changed, err := database.RebuildEventCounts(ctx, SCHEDULE_ID); err != nil || changed != 0 {
+			t.Fatalf("expected no changes on rebuild, got %d, %v", changed, err)
+		}
+	}
+
+	save := func(sessionId string, eventIds ...string) {
+		t.Helper()
+		if _, _, err := database.SaveSessionSelection(ctx, sessionId, SCHEDULE_ID, selection.NewSelection(eventIds)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save("s1", "e1", "e2")
+	save("s2", "e1")
+	save("s3", "e1", "e2")
+	expectCounts(map[string]int{"e1": 3, "e2": 2})
+
+	save("s1", "e1", "e2")
+	save("s2", "e3")
+	expectCounts(map[string]int{"e1": 2, "e2": 2, "e3": 1})
+
+	count, err := database.GetEventSelectionCount(ctx, SCHEDULE_ID, "e2")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2, got %d, %v", count, err)
+	}
+	count, err = database.GetEventSelectionCount(ctx, SCHEDULE_ID, "unknown")
+	if err != nil || count != 0 {
+		t.Fatalf("expected 0, got %d, %v", count, err)
+	}
+
+	if _, err := database.RewriteSelections(ctx, SCHEDULE_ID, func(eventIds []string) []string {
+		return slices.DeleteFunc(eventIds, func(eventId string) bool { return eventId == "e2" })
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(map[string]int{"e1": 2, "e3": 1})
+
+	// s2's selection is dropped in favour of s1's
+	if err := database.MergeSessions(ctx, "s2", "s1", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(map[string]int{"e1": 2})
+
+	save("s4", "e4")
+	if err := database.MergeSessions(ctx, "s4", "s5", SCHEDULE_ID); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(map[string]int{"e1": 2, "e4": 1})
+
+	if err := database.DeleteSessionData(ctx, "s3"); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(map[string]int{"e1": 1, "e4": 1})
+
+	// counts are recomputed when upgrading from a version without them
+	conn, err := sql.Open(db.DEFAULT_DRIVER, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("DELETE FROM event_count"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := database.RebuildEventCounts(ctx, ""); err != nil || changed != 2 {
+		t.Fatalf("expected 2 changes on rebuild, got %d, %v", changed, err)
+	}
+	if _, err := conn.Exec("DELETE FROM event_count; PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	database.Init()
+	expectCounts(map[string]int{"e1": 1, "e4": 1})
+}
+
+func TestStore(t *testing.T) {
+	for name, prefix := range map[string]string{"default": "", "purego": db.PURE_GO_PREFIX} {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) db.Store {
+				database := db.NewDB(prefix+path.Join(t.TempDir(), "db.sqlite"), []byte(storetest.HASH_KEY))
+				database.Init()
+				t.Cleanup(func() { database.Close() })
+				return database
+			})
+		})
+	}
+}
+
+func TestPureGoMigration(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+
+	// a database written with the default driver
+	database := db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	hash, _, err := database.SaveSessionSelection(ctx, SESSION_ID, SCHEDULE_ID, selection.NewSelection([]string{"e1", "e2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pureGo := db.NewDB(db.PURE_GO_PREFIX+dbPath, []byte(HASH_KEY))
+	pureGo.Init()
+	sel, _, err := pureGo.GetSessionSelection(ctx, SESSION_ID, SCHEDULE_ID)
+	if err != nil || sel.Id() != hash || !slices.Equal(sel.GetEventIds(), []string{"e1", "e2"}) {
+		t.Fatalf("expected the saved selection, got %v, %v", sel, err)
+	}
+	if _, _, err := pureGo.SaveSessionSelection(ctx, "other", SCHEDULE_ID, selection.NewSelection([]string{"e1"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := pureGo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// and back
+	database = db.NewDB(dbPath, []byte(HASH_KEY))
+	database.Init()
+	defer database.Close()
+	count, err := database.GetEventSelectionCount(ctx, SCHEDULE_ID, "e1")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2, got %d, %v", count, err)
+	}
+	if _, err := db.CheckBackup(ctx, dbPath); err != nil {
+		t.Fatal(err)
+	}
+}
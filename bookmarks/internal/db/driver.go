@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// PURE_GO_PREFIX starts a db_url to use the pure-Go SQLite driver, e.g.
+// "purego:/data/bookmarks.db". Both drivers use the same file format.
+const PURE_GO_PREFIX = "purego:"
+
+// PURE_GO_DRIVER is the name of the pure-Go SQLite driver, which needs no cgo.
+const PURE_GO_DRIVER = "sqlite"
+
+// parseURL returns the SQL driver and path of a db_url.
+func parseURL(dbURL string) (string, string) {
+	if path, ok := strings.CutPrefix(dbURL, PURE_GO_PREFIX); ok {
+		return PURE_GO_DRIVER, path
+	}
+	return DEFAULT_DRIVER, dbURL
+}
+
+// FilePath returns the path of the database of a db_url, which may be a
+// file: URI.
+func FilePath(dbURL string) string {
+	_, path := parseURL(dbURL)
+	return path
+}
+
+// dsn returns the data source name that opens path with the driver in WAL
+// mode. Transactions take the write lock when they begin, so they cannot fail
+// to upgrade to a write.
+func dsn(driver string, path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	if driver == PURE_GO_DRIVER {
+		return fmt.Sprintf(
+			"%s%s_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(%d)&_txlock=immediate",
+			path, sep, BUSY_TIMEOUT,
+		)
+	}
+	return fmt.Sprintf(
+		"%s%s_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d&_txlock=immediate", path, sep, BUSY_TIMEOUT,
+	)
+}
@@ -0,0 +1,11 @@
+//go:build cgo
+
+package db
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DEFAULT_DRIVER is the SQLite driver used unless the db_url selects the
+// pure-Go one.
+const DEFAULT_DRIVER = "sqlite3"
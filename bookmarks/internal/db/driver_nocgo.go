@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package db
+
+// DEFAULT_DRIVER is the SQLite driver used unless the db_url selects the
+// pure-Go one. Without cgo, it is the pure-Go one.
+const DEFAULT_DRIVER = PURE_GO_DRIVER
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"bookmarks/internal/selection"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SaveEventList records a schedule's sorted event IDs at a share code
+// version, so that legacy codes made with them can be read after a restart.
+// Only the last selection.MAX_EVENT_LISTS versions of each schedule are kept.
+func (db *DB) SaveEventList(ctx context.Context, scheduleId string, version string, eventIds []string) error {
+	data, err := json.Marshal(eventIds)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO event_list VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT DO UPDATE SET event_ids = excluded.event_ids, date = excluded.date",
+		scheduleId, version, string(data), time.Now().UTC().Format(DATE_FORMAT),
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM event_list WHERE schedule_id = ? AND version NOT IN ("+
+			"SELECT version FROM event_list WHERE schedule_id = ? ORDER BY date DESC LIMIT ?)",
+		scheduleId, scheduleId, selection.MAX_EVENT_LISTS,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetEventList returns a schedule's sorted event IDs at a share code version,
+// or nil if the version is not known.
+func (db *DB) GetEventList(ctx context.Context, scheduleId string, version string) ([]string, error) {
+	var data string
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT event_ids FROM event_list WHERE schedule_id = ? AND version = ?",
+		scheduleId, version,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var eventIds []string
+	if err := json.Unmarshal([]byte(data), &eventIds); err != nil {
+		return nil, err
+	}
+	return eventIds, nil
+}
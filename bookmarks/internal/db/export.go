@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// SessionData is everything stored about a session.
+type SessionData struct {
+	Selections        []SessionSelection
+	History           []SessionSelection
+	PushSubscriptions []PushSubscription
+	Attendance        []Attendance
+	// LinkedIds are the session IDs that were merged into the session.
+	LinkedIds []string
+}
+
+// SessionSelection is a session's selection in a schedule.
+type SessionSelection struct {
+	ScheduleId string
+	Date       string
+	Hash       string
+	Events     []string
+}
+
+// GetSessionData returns the session's selections in every schedule, its
+// selection history, push subscriptions, attendance and linked session IDs.
+func (db *DB) GetSessionData(ctx context.Context, sessionId string) (*SessionData, error) {
+	data := &SessionData{}
+	var err error
+
+	data.Selections, err = db.querySessionSelections(ctx,
+		"SELECT s.schedule_id, s.date, s.selection_hash, sl.event_id FROM session s "+
+			"LEFT JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+			"WHERE s.id = ? ORDER BY s.schedule_id, sl.rowid",
+		sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	data.History, err = db.querySessionSelections(ctx,
+		"SELECT h.schedule_id, h.date, h.selection_hash, sl.event_id FROM session_history h "+
+			"LEFT JOIN schedule_selection sl ON sl.schedule_id = h.schedule_id AND sl.selection_hash = h.selection_hash "+
+			"WHERE h.session_id = ? ORDER BY h.rowid, sl.rowid",
+		sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT schedule_id, endpoint, reminder_minutes, change_alerts FROM push_subscription "+
+			"WHERE session_id = ? ORDER BY schedule_id, endpoint",
+		sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	data.PushSubscriptions = make([]PushSubscription, 0)
+	for res.Next() {
+		sub := PushSubscription{SessionId: sessionId}
+		if err := res.Scan(&sub.ScheduleId, &sub.Endpoint, &sub.ReminderMinutes, &sub.ChangeAlerts); err != nil {
+			return nil, err
+		}
+		data.PushSubscriptions = append(data.PushSubscriptions, sub)
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	data.Attendance, err = db.queryAttendance(ctx,
+		"SELECT schedule_id, event_id, date FROM attendance WHERE session_id = ? ORDER BY schedule_id, date, event_id",
+		sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	data.LinkedIds, err = db.GetLinkedSessionIds(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// querySessionSelections reads rows of schedule ID, date, hash and event ID,
+// ordered by selection, into selections.
+func (db *DB) querySessionSelections(ctx context.Context, query string, args ...any) ([]SessionSelection, error) {
+	res, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	selections := make([]SessionSelection, 0)
+	for res.Next() {
+		var sel SessionSelection
+		var eventId sql.NullString
+		if err := res.Scan(&sel.ScheduleId, &sel.Date, &sel.Hash, &eventId); err != nil {
+			return nil, err
+		}
+
+		if n := len(selections); n == 0 || selections[n-1].ScheduleId != sel.ScheduleId ||
+			selections[n-1].Date != sel.Date || selections[n-1].Hash != sel.Hash {
+			sel.Events = make([]string, 0)
+			selections = append(selections, sel)
+		}
+		if eventId.Valid {
+			selections[len(selections)-1].Events = append(selections[len(selections)-1].Events, eventId.String)
+		}
+	}
+
+	return selections, res.Err()
+}
+
+// GetLinkedSessionIds returns the session IDs that were merged into the
+// session.
+func (db *DB) GetLinkedSessionIds(ctx context.Context, sessionId string) ([]string, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT DISTINCT old_id FROM session_link WHERE new_id = ? ORDER BY old_id", sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	ids := make([]string, 0)
+	for res.Next() {
+		var id string
+		if err := res.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, res.Err()
+}
+
+// DeleteSessionData erases a session: its selections, history, push
+// subscriptions, attendance and links. Selections no other session has, currently or in
+// its history, are deleted too; others are kept so that shared links to them
+// still work.
+func (db *DB) DeleteSessionData(ctx context.Context, sessionId string) error {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type scheduleHash struct {
+		scheduleId string
+		hash       string
+	}
+
+	res, err := tx.QueryContext(ctx,
+		"SELECT schedule_id, selection_hash FROM session WHERE id = ? "+
+			"UNION SELECT schedule_id, selection_hash FROM session_history WHERE session_id = ?",
+		sessionId, sessionId,
+	)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]scheduleHash, 0)
+	for res.Next() {
+		var h scheduleHash
+		if err := res.Scan(&h.scheduleId, &h.hash); err != nil {
+			res.Close()
+			return err
+		}
+		hashes = append(hashes, h)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	if err := removeSessionCounts(ctx, tx, "s.id = ?", sessionId); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM push_sent WHERE EXISTS (SELECT 1 FROM push_subscription p " +
+			"WHERE p.session_id = ? AND p.schedule_id = push_sent.schedule_id AND p.endpoint = push_sent.endpoint)",
+		"DELETE FROM push_subscription WHERE session_id = ?",
+		"DELETE FROM attendance WHERE session_id = ?",
+		"DELETE FROM removed_event_session WHERE session_id = ?",
+		"DELETE FROM session WHERE id = ?",
+		"DELETE FROM session_history WHERE session_id = ?",
+		"DELETE FROM session_link WHERE old_id = ?1 OR new_id = ?1",
+	} {
+		if _, err := tx.ExecContext(ctx, query, sessionId); err != nil {
+			return err
+		}
+	}
+
+	deleted := 0
+	for _, h := range hashes {
+		res, err := tx.ExecContext(ctx,
+			"DELETE FROM schedule_selection WHERE schedule_id = ?1 AND selection_hash = ?2 "+
+				"AND NOT EXISTS (SELECT 1 FROM session WHERE schedule_id = ?1 AND selection_hash = ?2) "+
+				"AND NOT EXISTS (SELECT 1 FROM session_history WHERE schedule_id = ?1 AND selection_hash = ?2)",
+			h.scheduleId, h.hash,
+		)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count > 0 {
+			deleted++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "erased session", "selections", len(hashes), "deleted_selections", deleted)
+	return nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// NewBaselineDB opens the database at path with the settings used before WAL,
+// for comparison in benchmarks: a rollback journal, no busy timeout, one pool
+// of unlimited connections for reads and writes, and one INSERT per event.
+func NewBaselineDB(path string, hashKey []byte) *DB {
+	params := "_journal_mode=DELETE&_busy_timeout=0"
+	if DEFAULT_DRIVER == PURE_GO_DRIVER {
+		params = "_pragma=journal_mode(DELETE)&_pragma=busy_timeout(0)"
+	}
+
+	conn, err := sql.Open(DEFAULT_DRIVER, fmt.Sprintf("%s?%s", path, params))
+	if err != nil {
+		panic(err)
+	}
+	return &DB{conn: conn, writer: conn, hashKey: hashKey, batchSize: 1}
+}
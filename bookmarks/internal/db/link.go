@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// SessionSchedule is a schedule a session has bookmarks in.
+type SessionSchedule struct {
+	ScheduleId string
+	Date       string
+	Count      int
+}
+
+// MergeSessions moves a session's selections, history, push subscriptions,
+// attendance and removed events to another session, in one schedule or in
+// every schedule if scheduleId is empty. Where both sessions have a
+// selection, the target's is kept. The old ID is linked to the new one, see
+// ResolveSessionLink.
+func (db *DB) MergeSessions(ctx context.Context, fromId string, toId string, scheduleId string) error {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the target's selection is kept where both sessions have one
+	if err := removeSessionCounts(ctx, tx,
+		"s.id = ? AND (? = '' OR s.schedule_id = ?) "+
+			"AND s.schedule_id IN (SELECT schedule_id FROM session WHERE id = ?)",
+		fromId, scheduleId, scheduleId, toId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM session WHERE id = ? AND (? = '' OR schedule_id = ?) "+
+			"AND schedule_id IN (SELECT schedule_id FROM session WHERE id = ?)",
+		fromId, scheduleId, scheduleId, toId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE session SET id = ? WHERE id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE session_history SET session_id = ? WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE OR IGNORE push_subscription SET session_id = ? WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM push_subscription WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE OR IGNORE attendance SET session_id = ? WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM attendance WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE OR IGNORE removed_event_session SET session_id = ? WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM removed_event_session WHERE session_id = ? AND (? = '' OR schedule_id = ?)",
+		fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	// IDs linked to the old session now lead to the new one
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE session_link SET new_id = ? WHERE new_id = ? AND (? = '' OR schedule_id = ?)",
+		toId, fromId, scheduleId, scheduleId,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO session_link VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT DO UPDATE SET new_id = excluded.new_id, date = excluded.date",
+		fromId, scheduleId, toId, time.Now().Format(time.RFC3339Nano),
+	); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.DebugContext(ctx, "merged sessions")
+	return nil
+}
+
+// ResolveSessionLink returns the session a session ID was merged into for a
+// schedule, or the ID itself if it was not merged.
+func (db *DB) ResolveSessionLink(ctx context.Context, sessionId string, scheduleId string) (string, error) {
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT new_id FROM session_link WHERE old_id = ? AND schedule_id IN (?, '') "+
+			"ORDER BY schedule_id DESC LIMIT 1",
+		sessionId, scheduleId,
+	)
+
+	var newId string
+	if err := row.Scan(&newId); err == sql.ErrNoRows {
+		return sessionId, nil
+	} else if err != nil {
+		return "", err
+	}
+	return newId, nil
+}
+
+// GetSessionSchedules returns the schedules the session has bookmarked events
+// in.
+func (db *DB) GetSessionSchedules(ctx context.Context, sessionId string) ([]SessionSchedule, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT s.schedule_id, s.date, COUNT(sl.event_id) FROM session s "+
+			"JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+			"WHERE s.id = ? GROUP BY s.schedule_id, s.date",
+		sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	schedules := make([]SessionSchedule, 0)
+	for res.Next() {
+		var schedule SessionSchedule
+		if err := res.Scan(&schedule.ScheduleId, &schedule.Date, &schedule.Count); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, res.Err()
+}
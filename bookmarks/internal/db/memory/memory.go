@@ -0,0 +1,756 @@
+// Package memory is a db.Store that keeps everything in memory, for tests.
+package memory
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/selection"
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type sessionKey struct {
+	id         string
+	scheduleId string
+}
+
+type session struct {
+	date string
+	hash string
+}
+
+type selectionKey struct {
+	scheduleId string
+	hash       string
+}
+
+type historyEntry struct {
+	sessionKey
+	date string
+	hash string
+}
+
+type pushKey struct {
+	scheduleId string
+	sessionId  string
+	endpoint   string
+}
+
+type pushSentKey struct {
+	scheduleId string
+	endpoint   string
+	eventId    string
+	kind       string
+	value      string
+}
+
+// removedKey is a session that had a removed event bookmarked.
+type removedKey struct {
+	sessionKey
+	eventId string
+}
+
+type thresholdKey struct {
+	scheduleId string
+	eventId    string
+	threshold  int
+}
+
+type attendanceKey struct {
+	sessionKey
+	eventId string
+}
+
+type eventChange struct {
+	scheduleId string
+	db.EventChange
+}
+
+// Store is a db.Store in memory. The zero value is not usable; use NewStore.
+type Store struct {
+	hashKey []byte
+	lock    sync.RWMutex
+
+	// selections are the event IDs of each schedule's selections by hash
+	selections map[string]map[string][]string
+	sessions   map[sessionKey]session
+	history    []historyEntry
+	removed    map[string]map[string]db.RemovedEvent
+	// removedSessions are the sessions that had each removed event
+	// bookmarked when it was removed
+	removedSessions map[removedKey]struct{}
+	changes         []eventChange
+	// eventLists are each schedule's event lists by version, kept without
+	// a limit
+	eventLists map[string]map[string][]string
+	push       map[pushKey]db.PushSubscription
+	pushSent   map[pushSentKey]time.Time
+	deliveries []db.WebhookDelivery
+	thresholds map[thresholdKey]struct{}
+	// attendance is the date of each check-in
+	attendance map[attendanceKey]string
+	// links are the sessions old IDs were merged into, by old ID and
+	// schedule, which is empty for every schedule
+	links   map[sessionKey]string
+	revoked map[string]struct{}
+}
+
+var _ db.Store = (*Store)(nil)
+
+// NewStore creates an empty store. Selections are hashed with hashKey, as by
+// db.NewDB.
+func NewStore(hashKey []byte) *Store {
+	return &Store{
+		hashKey:         hashKey,
+		selections:      make(map[string]map[string][]string),
+		sessions:        make(map[sessionKey]session),
+		removed:         make(map[string]map[string]db.RemovedEvent),
+		removedSessions: make(map[removedKey]struct{}),
+		eventLists:      make(map[string]map[string][]string),
+		push:            make(map[pushKey]db.PushSubscription),
+		pushSent:        make(map[pushSentKey]time.Time),
+		thresholds:      make(map[thresholdKey]struct{}),
+		attendance:      make(map[attendanceKey]string),
+		links:           make(map[sessionKey]string),
+		revoked:         make(map[string]struct{}),
+	}
+}
+
+// events returns a copy of a selection's event IDs, empty if there is no
+// such selection. The store must be locked.
+func (s *Store) events(scheduleId string, hash string) []string {
+	return slices.Clone(s.selections[scheduleId][hash])
+}
+
+// sessionEvents returns the event IDs a session has bookmarked in a
+// schedule. The store must be locked.
+func (s *Store) sessionEvents(sessionId string, scheduleId string) map[string]struct{} {
+	events := make(map[string]struct{})
+	if cur, ok := s.sessions[sessionKey{sessionId, scheduleId}]; ok {
+		for _, eventId := range s.selections[scheduleId][cur.hash] {
+			events[eventId] = struct{}{}
+		}
+	}
+	return events
+}
+
+func (s *Store) GetSelection(ctx context.Context, scheduleId string, hash string) (*selection.Selection, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return selection.LoadSelection(hash, s.events(scheduleId, hash)), nil
+}
+
+func (s *Store) GetSessionSelection(ctx context.Context, sessionId string, scheduleId string) (*selection.Selection, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	cur, ok := s.sessions[sessionKey{sessionId, scheduleId}]
+	if !ok {
+		return nil, "", nil
+	}
+	return selection.LoadSelection(cur.hash, s.events(scheduleId, cur.hash)), cur.date, nil
+}
+
+func (s *Store) SaveSessionSelection(ctx context.Context, sessionId string, scheduleId string, set *selection.Selection) (string, string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := set.Hash(s.hashKey)
+	if s.selections[scheduleId] == nil {
+		s.selections[scheduleId] = make(map[string][]string)
+	}
+	if _, ok := s.selections[scheduleId][hash]; !ok {
+		eventIds := set.GetEventIds()
+		slices.Sort(eventIds)
+		s.selections[scheduleId][hash] = eventIds
+	}
+
+	now := time.Now().Format(time.RFC3339Nano)
+	key := sessionKey{sessionId, scheduleId}
+	if cur, ok := s.sessions[key]; !ok || cur.hash != hash {
+		s.history = append(s.history, historyEntry{key, now, hash})
+	}
+	s.sessions[key] = session{date: now, hash: hash}
+
+	return hash, now, nil
+}
+
+func (s *Store) PruneSessionHistory(ctx context.Context, before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.history = slices.DeleteFunc(s.history, func(entry historyEntry) bool {
+		date, err := time.Parse(time.RFC3339Nano, entry.date)
+		return err == nil && date.Before(before)
+	})
+	return nil
+}
+
+func (s *Store) GetEventSelectionCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	counts := make(map[string]int)
+	for key, cur := range s.sessions {
+		if key.scheduleId != scheduleId {
+			continue
+		}
+		for _, eventId := range s.selections[scheduleId][cur.hash] {
+			counts[eventId]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *Store) GetEventSelectionCount(ctx context.Context, scheduleId string, eventId string) (int, error) {
+	counts, err := s.GetEventSelectionCounts(ctx, scheduleId)
+	return counts[eventId], err
+}
+
+func (s *Store) SetRemovedEvents(ctx context.Context, scheduleId string, removed []db.RemovedEvent, restored []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.removed[scheduleId] == nil {
+		s.removed[scheduleId] = make(map[string]db.RemovedEvent)
+	}
+	for _, event := range removed {
+		s.removed[scheduleId][event.EventId] = event
+		for key, cur := range s.sessions {
+			if key.scheduleId == scheduleId && slices.Contains(s.selections[scheduleId][cur.hash], event.EventId) {
+				s.removedSessions[removedKey{key, event.EventId}] = struct{}{}
+			}
+		}
+	}
+	for _, eventId := range restored {
+		delete(s.removed[scheduleId], eventId)
+		for key := range s.removedSessions {
+			if key.scheduleId == scheduleId && key.eventId == eventId {
+				delete(s.removedSessions, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetSessionRemovedEvents(ctx context.Context, sessionId string, scheduleId string, page db.Page) ([]db.RemovedEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	events := make([]db.RemovedEvent, 0)
+	for eventId, event := range s.removed[scheduleId] {
+		_, ok := s.removedSessions[removedKey{sessionKey{sessionId, scheduleId}, eventId}]
+		if ok && (page.After == "" || db.RemovedEventKey(event) > page.After) {
+			events = append(events, event)
+		}
+	}
+
+	slices.SortFunc(events, func(a db.RemovedEvent, b db.RemovedEvent) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(a.EventId, b.EventId))
+	})
+	return limit(events, page), nil
+}
+
+// limit returns the first items up to the page's limit.
+func limit[T any](items []T, page db.Page) []T {
+	if page.Limit > 0 && len(items) > page.Limit {
+		return items[:page.Limit]
+	}
+	return items
+}
+
+func (s *Store) AddEventChanges(ctx context.Context, scheduleId string, changes []db.EventChange) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, change := range changes {
+		change.Id = int64(len(s.changes) + 1)
+		change.Date = change.Date.UTC()
+		s.changes = append(s.changes, eventChange{scheduleId, change})
+	}
+	return nil
+}
+
+func (s *Store) GetSessionEventChanges(ctx context.Context, sessionId string, scheduleId string, since time.Time, page db.Page) ([]db.EventChange, error) {
+	var after int64
+	if page.After != "" {
+		var err error
+		if after, err = strconv.ParseInt(page.After, 10, 64); err != nil {
+			return nil, db.ErrInvalidCursor
+		}
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	bookmarked := s.sessionEvents(sessionId, scheduleId)
+	changes := make([]db.EventChange, 0)
+	for _, change := range s.changes {
+		_, ok := bookmarked[change.EventId]
+		if !ok && change.Kind == "removed" {
+			_, ok = s.removedSessions[removedKey{sessionKey{sessionId, scheduleId}, change.EventId}]
+		}
+		if ok && change.scheduleId == scheduleId && change.Date.After(since) && change.Id > after {
+			changes = append(changes, change.EventChange)
+		}
+	}
+	return limit(changes, page), nil
+}
+
+func (s *Store) SaveEventList(ctx context.Context, scheduleId string, version string, eventIds []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.eventLists[scheduleId] == nil {
+		s.eventLists[scheduleId] = make(map[string][]string)
+	}
+	s.eventLists[scheduleId][version] = slices.Clone(eventIds)
+	return nil
+}
+
+func (s *Store) GetEventList(ctx context.Context, scheduleId string, version string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return slices.Clone(s.eventLists[scheduleId][version]), nil
+}
+
+func (s *Store) SetPushSubscription(ctx context.Context, scheduleId string, sub db.PushSubscription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub.ScheduleId = scheduleId
+	sub.Events = nil
+	s.push[pushKey{scheduleId, sub.SessionId, sub.Endpoint}] = sub
+	return nil
+}
+
+func (s *Store) DeletePushSubscription(ctx context.Context, scheduleId string, sessionId string, endpoint string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key := range s.push {
+		if key.scheduleId == scheduleId && key.sessionId == sessionId && (endpoint == "" || key.endpoint == endpoint) {
+			delete(s.push, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetPushSubscriptions(ctx context.Context, scheduleId string) ([]db.PushSubscription, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	subs := make([]db.PushSubscription, 0)
+	for key, sub := range s.push {
+		if key.scheduleId != scheduleId {
+			continue
+		}
+		sub.Events = make([]string, 0)
+		if cur, ok := s.sessions[sessionKey{sub.SessionId, scheduleId}]; ok {
+			sub.Events = s.events(scheduleId, cur.hash)
+		}
+		subs = append(subs, sub)
+	}
+
+	slices.SortFunc(subs, func(a db.PushSubscription, b db.PushSubscription) int {
+		return cmp.Or(cmp.Compare(a.SessionId, b.SessionId), cmp.Compare(a.Endpoint, b.Endpoint))
+	})
+	return subs, nil
+}
+
+func (s *Store) MarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := pushSentKey{scheduleId, endpoint, eventId, kind, value}
+	if _, ok := s.pushSent[key]; ok {
+		return false, nil
+	}
+	s.pushSent[key] = time.Now()
+	return true, nil
+}
+
+func (s *Store) UnmarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.pushSent, pushSentKey{scheduleId, endpoint, eventId, kind, value})
+	return nil
+}
+
+func (s *Store) PrunePushSent(ctx context.Context, before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, date := range s.pushSent {
+		if date.Before(before) {
+			delete(s.pushSent, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) AddWebhookDelivery(ctx context.Context, delivery db.WebhookDelivery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts, newest first, of
+// a schedule or of every schedule if scheduleId is empty.
+func (s *Store) GetWebhookDeliveries(ctx context.Context, scheduleId string, limit int) ([]db.WebhookDelivery, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	deliveries := make([]db.WebhookDelivery, 0)
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if scheduleId == "" || s.deliveries[i].ScheduleId == scheduleId {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *Store) PruneWebhookDeliveries(ctx context.Context, before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery db.WebhookDelivery) bool {
+		return delivery.Date.Before(before)
+	})
+	return nil
+}
+
+func (s *Store) MarkCountThreshold(ctx context.Context, scheduleId string, eventId string, threshold int) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := thresholdKey{scheduleId, eventId, threshold}
+	if _, ok := s.thresholds[key]; ok {
+		return false, nil
+	}
+	s.thresholds[key] = struct{}{}
+	return true, nil
+}
+
+func (s *Store) SetAttended(ctx context.Context, sessionId string, scheduleId string, eventId string, attended bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := attendanceKey{sessionKey{sessionId, scheduleId}, eventId}
+	if !attended {
+		delete(s.attendance, key)
+	} else if _, ok := s.attendance[key]; !ok {
+		s.attendance[key] = time.Now().Format(time.RFC3339Nano)
+	}
+	return nil
+}
+
+func (s *Store) GetSessionAttendance(ctx context.Context, sessionId string, scheduleId string) ([]db.Attendance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	attendance := make([]db.Attendance, 0)
+	for key, date := range s.attendance {
+		if key.id == sessionId && key.scheduleId == scheduleId {
+			attendance = append(attendance, db.Attendance{ScheduleId: scheduleId, EventId: key.eventId, Date: date})
+		}
+	}
+	slices.SortFunc(attendance, compareAttendance)
+	return attendance, nil
+}
+
+func compareAttendance(a db.Attendance, b db.Attendance) int {
+	return cmp.Or(cmp.Compare(a.ScheduleId, b.ScheduleId), cmp.Compare(a.Date, b.Date), cmp.Compare(a.EventId, b.EventId))
+}
+
+func (s *Store) GetAttendanceCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	counts := make(map[string]int)
+	for key := range s.attendance {
+		cur, ok := s.sessions[key.sessionKey]
+		if key.scheduleId == scheduleId && ok && slices.Contains(s.selections[scheduleId][cur.hash], key.eventId) {
+			counts[key.eventId]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *Store) MergeSessions(ctx context.Context, fromId string, toId string, scheduleId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	inScope := func(id string, sched string) bool {
+		return id == fromId && (scheduleId == "" || sched == scheduleId)
+	}
+
+	for key, cur := range s.sessions {
+		if !inScope(key.id, key.scheduleId) {
+			continue
+		}
+		delete(s.sessions, key)
+		// the target's selection is kept where both sessions have one
+		if _, ok := s.sessions[sessionKey{toId, key.scheduleId}]; !ok {
+			s.sessions[sessionKey{toId, key.scheduleId}] = cur
+		}
+	}
+
+	for i, entry := range s.history {
+		if inScope(entry.id, entry.scheduleId) {
+			s.history[i].id = toId
+		}
+	}
+
+	for key, sub := range s.push {
+		if !inScope(key.sessionId, key.scheduleId) {
+			continue
+		}
+		delete(s.push, key)
+		newKey := pushKey{key.scheduleId, toId, key.endpoint}
+		if _, ok := s.push[newKey]; !ok {
+			sub.SessionId = toId
+			s.push[newKey] = sub
+		}
+	}
+
+	for key, date := range s.attendance {
+		if !inScope(key.id, key.scheduleId) {
+			continue
+		}
+		delete(s.attendance, key)
+		newKey := attendanceKey{sessionKey{toId, key.scheduleId}, key.eventId}
+		if _, ok := s.attendance[newKey]; !ok {
+			s.attendance[newKey] = date
+		}
+	}
+
+	for key := range s.removedSessions {
+		if inScope(key.id, key.scheduleId) {
+			delete(s.removedSessions, key)
+			s.removedSessions[removedKey{sessionKey{toId, key.scheduleId}, key.eventId}] = struct{}{}
+		}
+	}
+
+	// IDs linked to the old session now lead to the new one
+	for key, newId := range s.links {
+		if newId == fromId && (scheduleId == "" || key.scheduleId == scheduleId) {
+			s.links[key] = toId
+		}
+	}
+	s.links[sessionKey{fromId, scheduleId}] = toId
+
+	return nil
+}
+
+func (s *Store) ResolveSessionLink(ctx context.Context, sessionId string, scheduleId string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if newId, ok := s.links[sessionKey{sessionId, scheduleId}]; ok {
+		return newId, nil
+	} else if newId, ok := s.links[sessionKey{sessionId, ""}]; ok {
+		return newId, nil
+	}
+	return sessionId, nil
+}
+
+func (s *Store) GetLinkedSessionIds(ctx context.Context, sessionId string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.linkedIds(sessionId), nil
+}
+
+// linkedIds returns the sorted IDs merged into the session. The store must be
+// locked.
+func (s *Store) linkedIds(sessionId string) []string {
+	ids := make([]string, 0)
+	for key, newId := range s.links {
+		if newId == sessionId && !slices.Contains(ids, key.id) {
+			ids = append(ids, key.id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (s *Store) GetSessionSchedules(ctx context.Context, sessionId string) ([]db.SessionSchedule, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	schedules := make([]db.SessionSchedule, 0)
+	for key, cur := range s.sessions {
+		if key.id != sessionId || len(s.selections[key.scheduleId][cur.hash]) == 0 {
+			continue
+		}
+		schedules = append(schedules, db.SessionSchedule{
+			ScheduleId: key.scheduleId,
+			Date:       cur.date,
+			Count:      len(s.selections[key.scheduleId][cur.hash]),
+		})
+	}
+
+	slices.SortFunc(schedules, func(a db.SessionSchedule, b db.SessionSchedule) int {
+		return cmp.Compare(a.ScheduleId, b.ScheduleId)
+	})
+	return schedules, nil
+}
+
+func (s *Store) GetSessionData(ctx context.Context, sessionId string) (*db.SessionData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data := &db.SessionData{
+		Selections:        make([]db.SessionSelection, 0),
+		History:           make([]db.SessionSelection, 0),
+		PushSubscriptions: make([]db.PushSubscription, 0),
+		Attendance:        make([]db.Attendance, 0),
+		LinkedIds:         s.linkedIds(sessionId),
+	}
+
+	for key, cur := range s.sessions {
+		if key.id == sessionId {
+			data.Selections = append(data.Selections, db.SessionSelection{
+				ScheduleId: key.scheduleId, Date: cur.date, Hash: cur.hash, Events: s.events(key.scheduleId, cur.hash),
+			})
+		}
+	}
+	slices.SortFunc(data.Selections, func(a db.SessionSelection, b db.SessionSelection) int {
+		return cmp.Compare(a.ScheduleId, b.ScheduleId)
+	})
+
+	for _, entry := range s.history {
+		if entry.id == sessionId {
+			data.History = append(data.History, db.SessionSelection{
+				ScheduleId: entry.scheduleId, Date: entry.date, Hash: entry.hash, Events: s.events(entry.scheduleId, entry.hash),
+			})
+		}
+	}
+
+	for key, sub := range s.push {
+		if key.sessionId == sessionId {
+			data.PushSubscriptions = append(data.PushSubscriptions, db.PushSubscription{
+				ScheduleId:      key.scheduleId,
+				SessionId:       sessionId,
+				Endpoint:        key.endpoint,
+				ReminderMinutes: sub.ReminderMinutes,
+				ChangeAlerts:    sub.ChangeAlerts,
+			})
+		}
+	}
+	slices.SortFunc(data.PushSubscriptions, func(a db.PushSubscription, b db.PushSubscription) int {
+		return cmp.Or(cmp.Compare(a.ScheduleId, b.ScheduleId), cmp.Compare(a.Endpoint, b.Endpoint))
+	})
+
+	for key, date := range s.attendance {
+		if key.id == sessionId {
+			data.Attendance = append(data.Attendance, db.Attendance{ScheduleId: key.scheduleId, EventId: key.eventId, Date: date})
+		}
+	}
+	slices.SortFunc(data.Attendance, compareAttendance)
+
+	return data, nil
+}
+
+func (s *Store) DeleteSessionData(ctx context.Context, sessionId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hashes := make(map[selectionKey]struct{})
+	for key, cur := range s.sessions {
+		if key.id == sessionId {
+			hashes[selectionKey{key.scheduleId, cur.hash}] = struct{}{}
+			delete(s.sessions, key)
+		}
+	}
+	s.history = slices.DeleteFunc(s.history, func(entry historyEntry) bool {
+		if entry.id == sessionId {
+			hashes[selectionKey{entry.scheduleId, entry.hash}] = struct{}{}
+		}
+		return entry.id == sessionId
+	})
+
+	for key := range s.push {
+		if key.sessionId != sessionId {
+			continue
+		}
+		for sent := range s.pushSent {
+			if sent.scheduleId == key.scheduleId && sent.endpoint == key.endpoint {
+				delete(s.pushSent, sent)
+			}
+		}
+		delete(s.push, key)
+	}
+
+	for key := range s.attendance {
+		if key.id == sessionId {
+			delete(s.attendance, key)
+		}
+	}
+
+	for key := range s.removedSessions {
+		if key.id == sessionId {
+			delete(s.removedSessions, key)
+		}
+	}
+
+	for key, newId := range s.links {
+		if key.id == sessionId || newId == sessionId {
+			delete(s.links, key)
+		}
+	}
+
+	// selections others have are kept, so that shared links still work
+	for key := range hashes {
+		if !s.isReferenced(key.scheduleId, key.hash) {
+			delete(s.selections[key.scheduleId], key.hash)
+		}
+	}
+
+	return nil
+}
+
+// isReferenced returns whether a session has the selection, currently or in
+// its history. The store must be locked.
+func (s *Store) isReferenced(scheduleId string, hash string) bool {
+	for key, cur := range s.sessions {
+		if key.scheduleId == scheduleId && cur.hash == hash {
+			return true
+		}
+	}
+	return slices.ContainsFunc(s.history, func(entry historyEntry) bool {
+		return entry.scheduleId == scheduleId && entry.hash == hash
+	})
+}
+
+// RevokeSession makes a session ID invalid. Its data is kept.
+func (s *Store) RevokeSession(ctx context.Context, sessionId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.revoked[sessionId] = struct{}{}
+	return nil
+}
+
+func (s *Store) IsSessionRevoked(ctx context.Context, sessionId string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.revoked[sessionId]
+	return ok, nil
+}
+
+// Backup is not supported in memory.
+func (s *Store) Backup(ctx context.Context, path string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/db/memory"
+	"bookmarks/internal/db/storetest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return memory.NewStore([]byte(storetest.HASH_KEY))
+	})
+}
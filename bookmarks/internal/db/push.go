@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PushSubscription is a session's Web Push subscription for a schedule.
+type PushSubscription struct {
+	ScheduleId string
+	SessionId  string
+	Endpoint   string
+	P256dh     string
+	Auth       string
+	// ReminderMinutes is how long before a bookmarked event starts to send
+	// a reminder, or 0 for no reminders.
+	ReminderMinutes int
+	ChangeAlerts    bool
+	// Events are the session's currently bookmarked events.
+	Events []string
+}
+
+// SetPushSubscription adds a session's push subscription, or updates its
+// keys and settings.
+func (db *DB) SetPushSubscription(ctx context.Context, scheduleId string, sub PushSubscription) error {
+	_, err := db.writer.ExecContext(ctx,
+		"INSERT INTO push_subscription VALUES (?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT DO UPDATE SET p256dh = excluded.p256dh, auth = excluded.auth, "+
+			"reminder_minutes = excluded.reminder_minutes, change_alerts = excluded.change_alerts, date = excluded.date",
+		scheduleId, sub.SessionId, sub.Endpoint, sub.P256dh, sub.Auth, sub.ReminderMinutes, sub.ChangeAlerts,
+		time.Now().Format(time.RFC3339Nano),
+	)
+	return err
+}
+
+// DeletePushSubscription removes a session's push subscription, or all of
+// its subscriptions if endpoint is empty.
+func (db *DB) DeletePushSubscription(ctx context.Context, scheduleId string, sessionId string, endpoint string) error {
+	var err error
+	if endpoint == "" {
+		_, err = db.writer.ExecContext(ctx,
+			"DELETE FROM push_subscription WHERE schedule_id = ? AND session_id = ?",
+			scheduleId, sessionId,
+		)
+	} else {
+		_, err = db.writer.ExecContext(ctx,
+			"DELETE FROM push_subscription WHERE schedule_id = ? AND session_id = ? AND endpoint = ?",
+			scheduleId, sessionId, endpoint,
+		)
+	}
+	return err
+}
+
+// GetPushSubscriptions returns a schedule's push subscriptions with their
+// sessions' bookmarked events.
+func (db *DB) GetPushSubscriptions(ctx context.Context, scheduleId string) ([]PushSubscription, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT p.session_id, p.endpoint, p.p256dh, p.auth, p.reminder_minutes, p.change_alerts, sl.event_id "+
+			"FROM push_subscription p "+
+			"LEFT JOIN session s ON s.id = p.session_id AND s.schedule_id = p.schedule_id "+
+			"LEFT JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+			"WHERE p.schedule_id = ? ORDER BY p.session_id, p.endpoint",
+		scheduleId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	subs := make([]PushSubscription, 0)
+	for res.Next() {
+		sub := PushSubscription{ScheduleId: scheduleId}
+		var eventId sql.NullString
+		if err := res.Scan(
+			&sub.SessionId, &sub.Endpoint, &sub.P256dh, &sub.Auth, &sub.ReminderMinutes, &sub.ChangeAlerts, &eventId,
+		); err != nil {
+			return nil, err
+		}
+
+		if n := len(subs); n == 0 || subs[n-1].SessionId != sub.SessionId || subs[n-1].Endpoint != sub.Endpoint {
+			sub.Events = make([]string, 0)
+			subs = append(subs, sub)
+		}
+		if eventId.Valid {
+			subs[len(subs)-1].Events = append(subs[len(subs)-1].Events, eventId.String)
+		}
+	}
+
+	return subs, res.Err()
+}
+
+// MarkPushSent records that a notification was sent to an endpoint, so it is
+// only sent once. It returns false if it was already recorded.
+func (db *DB) MarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) (bool, error) {
+	res, err := db.writer.ExecContext(ctx,
+		"INSERT INTO push_sent VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		scheduleId, endpoint, eventId, kind, value, time.Now().UTC().Format(DATE_FORMAT),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// UnmarkPushSent forgets a notification marked by MarkPushSent, so that it
+// is sent again, e.g. after the push service failed.
+func (db *DB) UnmarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) error {
+	_, err := db.writer.ExecContext(ctx,
+		"DELETE FROM push_sent WHERE schedule_id = ? AND endpoint = ? AND event_id = ? AND kind = ? AND value = ?",
+		scheduleId, endpoint, eventId, kind, value,
+	)
+	return err
+}
+
+// PrunePushSent forgets notifications sent before the given time.
+func (db *DB) PrunePushSent(ctx context.Context, before time.Time) error {
+	_, err := db.writer.ExecContext(ctx,
+		"DELETE FROM push_sent WHERE date < ?", before.UTC().Format(DATE_FORMAT),
+	)
+	return err
+}
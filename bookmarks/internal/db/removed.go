@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// RemovedEvent is the last known state of an event removed from its feed.
+type RemovedEvent struct {
+	EventId  string
+	Title    string
+	Start    string
+	End      string
+	Location string
+	Date     string
+}
+
+// SetRemovedEvents records events removed from a schedule's feed, along with
+// the sessions that currently have them bookmarked, and forgets removed events
+// that were added back.
+func (db *DB) SetRemovedEvents(ctx context.Context, scheduleId string, removed []RemovedEvent, restored []string) error {
+	tx, err := db.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, event := range removed {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO removed_event VALUES (?, ?, ?, ?, ?, ?, ?) "+
+				"ON CONFLICT DO UPDATE SET title = excluded.title, start = excluded.start, "+
+				"\"end\" = excluded.\"end\", location = excluded.location, date = excluded.date",
+			scheduleId, event.EventId, event.Title, event.Start, event.End, event.Location, event.Date,
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO removed_event_session "+
+				"SELECT s.schedule_id, sl.event_id, s.id FROM session s "+
+				"JOIN schedule_selection sl ON sl.schedule_id = s.schedule_id AND sl.selection_hash = s.selection_hash "+
+				"WHERE s.schedule_id = ? AND sl.event_id = ?",
+			scheduleId, event.EventId,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, eventId := range restored {
+		for _, query := range []string{
+			"DELETE FROM removed_event WHERE schedule_id = ? AND event_id = ?",
+			"DELETE FROM removed_event_session WHERE schedule_id = ? AND event_id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, scheduleId, eventId); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.DebugContext(ctx, "recorded removed events", "removed", len(removed), "restored", len(restored))
+	return nil
+}
+
+// RemovedEventKey returns the key a removed event is ordered by, for a Page.
+func RemovedEventKey(event RemovedEvent) string {
+	return event.Start + "\x00" + event.EventId
+}
+
+// GetSessionRemovedEvents returns the page of removed events that the session
+// had bookmarked when they were removed, ordered by their RemovedEventKey.
+func (db *DB) GetSessionRemovedEvents(ctx context.Context, sessionId string, scheduleId string, page Page) ([]RemovedEvent, error) {
+	start, eventId, _ := strings.Cut(page.After, "\x00")
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT r.event_id, r.title, r.start, r.\"end\", r.location, r.date FROM removed_event r "+
+			"JOIN removed_event_session rs ON rs.schedule_id = r.schedule_id AND rs.event_id = r.event_id "+
+			"WHERE r.schedule_id = ? AND rs.session_id = ? AND (? = '' OR (r.start, r.event_id) > (?, ?)) "+
+			"ORDER BY r.start, r.event_id LIMIT ?",
+		scheduleId, sessionId, page.After, start, eventId, page.sqlLimit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	events := make([]RemovedEvent, 0)
+	for res.Next() {
+		var event RemovedEvent
+		if err := res.Scan(&event.EventId, &event.Title, &event.Start, &event.End, &event.Location, &event.Date); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, res.Err()
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bookmarks/internal/selection"
+	"context"
+	"errors"
+	"time"
+)
+
+// Store is the storage used by the server. DB stores everything in SQLite;
+// see the memory package for a store for tests.
+type Store interface {
+	GetSelection(ctx context.Context, scheduleId string, hash string) (*selection.Selection, error)
+	GetSessionSelection(ctx context.Context, sessionId string, scheduleId string) (*selection.Selection, string, error)
+	SaveSessionSelection(ctx context.Context, sessionId string, scheduleId string, set *selection.Selection) (string, string, error)
+	PruneSessionHistory(ctx context.Context, before time.Time) error
+	GetEventSelectionCounts(ctx context.Context, scheduleId string) (map[string]int, error)
+	GetEventSelectionCount(ctx context.Context, scheduleId string, eventId string) (int, error)
+
+	SetRemovedEvents(ctx context.Context, scheduleId string, removed []RemovedEvent, restored []string) error
+	GetSessionRemovedEvents(ctx context.Context, sessionId string, scheduleId string, page Page) ([]RemovedEvent, error)
+	AddEventChanges(ctx context.Context, scheduleId string, changes []EventChange) error
+	GetSessionEventChanges(ctx context.Context, sessionId string, scheduleId string, since time.Time, page Page) ([]EventChange, error)
+	SaveEventList(ctx context.Context, scheduleId string, version string, eventIds []string) error
+	GetEventList(ctx context.Context, scheduleId string, version string) ([]string, error)
+
+	SetPushSubscription(ctx context.Context, scheduleId string, sub PushSubscription) error
+	DeletePushSubscription(ctx context.Context, scheduleId string, sessionId string, endpoint string) error
+	GetPushSubscriptions(ctx context.Context, scheduleId string) ([]PushSubscription, error)
+	MarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) (bool, error)
+	UnmarkPushSent(ctx context.Context, scheduleId string, endpoint string, eventId string, kind string, value string) error
+	PrunePushSent(ctx context.Context, before time.Time) error
+
+	AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) error
+	MarkCountThreshold(ctx context.Context, scheduleId string, eventId string, threshold int) (bool, error)
+
+	SetAttended(ctx context.Context, sessionId string, scheduleId string, eventId string, attended bool) error
+	GetSessionAttendance(ctx context.Context, sessionId string, scheduleId string) ([]Attendance, error)
+	GetAttendanceCounts(ctx context.Context, scheduleId string) (map[string]int, error)
+
+	MergeSessions(ctx context.Context, fromId string, toId string, scheduleId string) error
+	ResolveSessionLink(ctx context.Context, sessionId string, scheduleId string) (string, error)
+	GetLinkedSessionIds(ctx context.Context, sessionId string) ([]string, error)
+	GetSessionSchedules(ctx context.Context, sessionId string) ([]SessionSchedule, error)
+	GetSessionData(ctx context.Context, sessionId string) (*SessionData, error)
+	DeleteSessionData(ctx context.Context, sessionId string) error
+	IsSessionRevoked(ctx context.Context, sessionId string) (bool, error)
+
+	// Backup writes a consistent copy of the stored data to path.
+	Backup(ctx context.Context, path string) error
+}
+
+var _ Store = (*DB)(nil)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects part of a list: the items after the key After, or from the
+// start if it is empty, and at most Limit of them, or all if it is 0.
+type Page struct {
+	After string
+	Limit int
+}
+
+// sqlLimit returns the page's limit for a LIMIT clause.
+func (p Page) sqlLimit() int {
+	if p.Limit == 0 {
+		return -1
+	}
+	return p.Limit
+}
@@ -0,0 +1,534 @@
+// Package storetest checks that db.Store implementations behave alike.
+package storetest
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/selection"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+const SCHEDULE_ID = "test-schedule"
+
+// HASH_KEY is the key stores under test must hash selections with.
+const HASH_KEY = "test-key"
+
+// Run tests a store implementation. newStore must return an empty store that
+// hashes selections with HASH_KEY.
+func Run(t *testing.T, newStore func(t *testing.T) db.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store db.Store)
+	}{
+		{"Selections", testSelections},
+		{"SessionHistory", testSessionHistory},
+		{"Counts", testCounts},
+		{"RemovedEvents", testRemovedEvents},
+		{"EventChanges", testEventChanges},
+		{"EventLists", testEventLists},
+		{"PushSubscriptions", testPushSubscriptions},
+		{"Webhooks", testWebhooks},
+		{"Attendance", testAttendance},
+		{"MergeSessions", testMergeSessions},
+		{"DeleteSessionData", testDeleteSessionData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStore(t))
+		})
+	}
+}
+
+func save(t *testing.T, store db.Store, sessionId string, scheduleId string, eventIds ...string) string {
+	t.Helper()
+	hash, _, err := store.SaveSessionSelection(context.Background(), sessionId, scheduleId, selection.NewSelection(eventIds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func sorted(eventIds []string) []string {
+	eventIds = slices.Clone(eventIds)
+	slices.Sort(eventIds)
+	return eventIds
+}
+
+func testSelections(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	sel, date, err := store.GetSessionSelection(ctx, "s1", SCHEDULE_ID)
+	if err != nil || sel != nil || date != "" {
+		t.Fatalf("expected no selection, got %v, %q, %v", sel, date, err)
+	}
+
+	hash, date, err := store.SaveSessionSelection(ctx, "s1", SCHEDULE_ID, selection.NewSelection([]string{"e2", "e1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := selection.NewSelection([]string{"e1", "e2"}).Hash([]byte(HASH_KEY)); hash != expected {
+		t.Fatalf("expected hash %s, got %s", expected, hash)
+	}
+
+	sel, curDate, err := store.GetSessionSelection(ctx, "s1", SCHEDULE_ID)
+	if err != nil || sel.Id() != hash || curDate != date || !slices.Equal(sorted(sel.GetEventIds()), []string{"e1", "e2"}) {
+		t.Fatalf("unexpected session selection %v, %q, %v", sel, curDate, err)
+	}
+
+	sel, err = store.GetSelection(ctx, SCHEDULE_ID, hash)
+	if err != nil || !slices.Equal(sorted(sel.GetEventIds()), []string{"e1", "e2"}) {
+		t.Fatalf("unexpected selection %v, %v", sel, err)
+	}
+
+	sel, err = store.GetSelection(ctx, "other", hash)
+	if err != nil || len(sel.GetEventIds()) != 0 {
+		t.Fatalf("expected an empty selection in another schedule, got %v, %v", sel, err)
+	}
+
+	save(t, store, "s1", "other", "e3")
+	schedules, err := store.GetSessionSchedules(ctx, "s1")
+	if err != nil || len(schedules) != 2 || schedules[0].ScheduleId != "other" || schedules[0].Count != 1 ||
+		schedules[1].ScheduleId != SCHEDULE_ID || schedules[1].Count != 2 {
+		t.Fatalf("unexpected schedules %v, %v", schedules, err)
+	}
+
+	if revoked, err := store.IsSessionRevoked(ctx, "s1"); err != nil || revoked {
+		t.Fatalf("expected the session not to be revoked, got %v, %v", revoked, err)
+	}
+}
+
+func testSessionHistory(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	// saving the same selection again does not add to the history
+	first := save(t, store, "s1", SCHEDULE_ID, "e1")
+	save(t, store, "s1", SCHEDULE_ID, "e1")
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+
+	data, err := store.GetSessionData(ctx, "s1")
+	if err != nil || len(data.History) != 2 || data.History[0].Hash != first {
+		t.Fatalf("expected 2 history entries, got %+v, %v", data, err)
+	}
+
+	if err := store.PruneSessionHistory(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.GetSessionData(ctx, "s1"); err != nil || len(data.History) != 2 {
+		t.Fatalf("expected recent history to be kept, got %+v, %v", data, err)
+	}
+
+	if err := store.PruneSessionHistory(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	data, err = store.GetSessionData(ctx, "s1")
+	if err != nil || len(data.History) != 0 || len(data.Selections) != 1 {
+		t.Fatalf("expected only the current selection to be left, got %+v, %v", data, err)
+	}
+}
+
+func testCounts(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+	save(t, store, "s2", SCHEDULE_ID, "e1")
+	save(t, store, "s3", SCHEDULE_ID, "e3")
+	save(t, store, "s3", SCHEDULE_ID, "e1", "e2")
+	save(t, store, "s1", "other", "e1")
+
+	counts, err := store.GetEventSelectionCounts(ctx, SCHEDULE_ID)
+	if expected := map[string]int{"e1": 3, "e2": 2}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected counts %v, got %v, %v", expected, counts, err)
+	}
+
+	count, err := store.GetEventSelectionCount(ctx, SCHEDULE_ID, "e2")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2, got %d, %v", count, err)
+	}
+	count, err = store.GetEventSelectionCount(ctx, SCHEDULE_ID, "e3")
+	if err != nil || count != 0 {
+		t.Fatalf("expected 0, got %d, %v", count, err)
+	}
+}
+
+func testRemovedEvents(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+	// s2 un-bookmarked e1 before it was removed
+	save(t, store, "s2", SCHEDULE_ID, "e1")
+	save(t, store, "s2", SCHEDULE_ID, "e3")
+
+	if err := store.SetRemovedEvents(ctx, SCHEDULE_ID, []db.RemovedEvent{
+		{EventId: "e2", Title: "Two", Start: "2025-01-02"},
+		{EventId: "e1", Title: "One", Start: "2025-01-01"},
+		{EventId: "e4", Title: "Four"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the removed events stay listed after the session saves without them
+	// and its history is pruned
+	save(t, store, "s1", SCHEDULE_ID, "e3")
+	if err := store.PruneSessionHistory(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := store.GetSessionRemovedEvents(ctx, "s1", SCHEDULE_ID, db.Page{})
+	if err != nil || len(removed) != 2 || removed[0].EventId != "e1" || removed[1].Title != "Two" {
+		t.Fatalf("unexpected removed events %v, %v", removed, err)
+	}
+
+	if removed, err := store.GetSessionRemovedEvents(ctx, "s2", SCHEDULE_ID, db.Page{}); err != nil || len(removed) != 0 {
+		t.Fatalf("expected no removed events for a session that un-bookmarked them, got %v, %v", removed, err)
+	}
+
+	removed, err = store.GetSessionRemovedEvents(ctx, "s1", SCHEDULE_ID, db.Page{Limit: 1})
+	if err != nil || len(removed) != 1 || removed[0].EventId != "e1" {
+		t.Fatalf("unexpected first page %v, %v", removed, err)
+	}
+	removed, err = store.GetSessionRemovedEvents(ctx, "s1", SCHEDULE_ID, db.Page{After: db.RemovedEventKey(removed[0]), Limit: 1})
+	if err != nil || len(removed) != 1 || removed[0].EventId != "e2" {
+		t.Fatalf("unexpected second page %v, %v", removed, err)
+	}
+
+	if err := store.SetRemovedEvents(ctx, SCHEDULE_ID, nil, []string{"e1"}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = store.GetSessionRemovedEvents(ctx, "s1", SCHEDULE_ID, db.Page{})
+	if err != nil || len(removed) != 1 || removed[0].EventId != "e2" {
+		t.Fatalf("unexpected removed events %v, %v", removed, err)
+	}
+}
+
+func testEventChanges(t *testing.T, store db.Store) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+
+	if err := store.AddEventChanges(ctx, SCHEDULE_ID, []db.EventChange{
+		{EventId: "e1", Kind: "time", Old: "10:00", New: "11:00", Date: start.Add(time.Minute)},
+		{EventId: "e3", Kind: "time", Date: start.Add(time.Minute)},
+		{EventId: "e2", Kind: "location", Old: "A", New: "B", Date: start.Add(2 * time.Minute)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, time.Time{}, db.Page{})
+	if err != nil || len(changes) != 2 || changes[0].EventId != "e1" || changes[1].New != "B" ||
+		!changes[1].Date.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+
+	page, err := store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, time.Time{}, db.Page{After: db.EventChangeKey(changes[0]), Limit: 1})
+	if err != nil || len(page) != 1 || page[0].EventId != "e2" {
+		t.Fatalf("unexpected page %v, %v", page, err)
+	}
+	if _, err := store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, time.Time{}, db.Page{After: "x"}); !errors.Is(err, db.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	changes, err = store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, start.Add(time.Minute), db.Page{})
+	if err != nil || len(changes) != 1 || changes[0].EventId != "e2" {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+
+	// events that are no longer bookmarked have no changes
+	save(t, store, "s1", SCHEDULE_ID, "e2")
+	changes, err = store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, time.Time{}, db.Page{})
+	if err != nil || len(changes) != 1 || changes[0].EventId != "e2" {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+
+	// removed events are dropped from later selections, but their removal
+	// stays listed
+	if err := store.AddEventChanges(ctx, SCHEDULE_ID, []db.EventChange{
+		{EventId: "e2", Kind: "removed", Date: start.Add(3 * time.Minute)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRemovedEvents(ctx, SCHEDULE_ID, []db.RemovedEvent{{EventId: "e2", Title: "Two", Start: "10:00"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	save(t, store, "s1", SCHEDULE_ID)
+	changes, err = store.GetSessionEventChanges(ctx, "s1", SCHEDULE_ID, start.Add(2*time.Minute), db.Page{})
+	if err != nil || len(changes) != 1 || changes[0].EventId != "e2" || changes[0].Kind != "removed" {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+}
+
+func testEventLists(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	if err := store.SaveEventList(ctx, SCHEDULE_ID, "v1", []string{"e1", "e2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveEventList(ctx, SCHEDULE_ID, "v2", []string{"e1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventIds, err := store.GetEventList(ctx, SCHEDULE_ID, "v1")
+	if err != nil || !slices.Equal(eventIds, []string{"e1", "e2"}) {
+		t.Fatalf("unexpected event list %v, %v", eventIds, err)
+	}
+
+	eventIds, err = store.GetEventList(ctx, "other", "v1")
+	if err != nil || eventIds != nil {
+		t.Fatalf("expected no event list, got %v, %v", eventIds, err)
+	}
+}
+
+func testPushSubscriptions(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+	for _, sub := range []db.PushSubscription{
+		{SessionId: "s1", Endpoint: "https://push.example/a", P256dh: "k", Auth: "a", ReminderMinutes: 10},
+		{SessionId: "s1", Endpoint: "https://push.example/a", P256dh: "k2", Auth: "a", ReminderMinutes: 15},
+		{SessionId: "s2", Endpoint: "https://push.example/b", P256dh: "k", Auth: "a", ChangeAlerts: true},
+	} {
+		if err := store.SetPushSubscription(ctx, SCHEDULE_ID, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, err := store.GetPushSubscriptions(ctx, SCHEDULE_ID)
+	if err != nil || len(subs) != 2 {
+		t.Fatalf("unexpected subscriptions %v, %v", subs, err)
+	}
+	if subs[0].P256dh != "k2" || subs[0].ReminderMinutes != 15 || !slices.Equal(sorted(subs[0].Events), []string{"e1", "e2"}) {
+		t.Fatalf("unexpected subscription %v", subs[0])
+	}
+	if subs[1].SessionId != "s2" || !subs[1].ChangeAlerts || subs[1].Events == nil || len(subs[1].Events) != 0 {
+		t.Fatalf("unexpected subscription %v", subs[1])
+	}
+
+	if err := store.DeletePushSubscription(ctx, SCHEDULE_ID, "s2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if subs, err := store.GetPushSubscriptions(ctx, SCHEDULE_ID); err != nil || len(subs) != 1 {
+		t.Fatalf("unexpected subscriptions %v, %v", subs, err)
+	}
+
+	if ok, err := store.MarkPushSent(ctx, SCHEDULE_ID, "https://push.example/a", "e1", "reminder", "10"); err != nil || !ok {
+		t.Fatalf("expected the notification to be marked, got %v, %v", ok, err)
+	}
+	if ok, err := store.MarkPushSent(ctx, SCHEDULE_ID, "https://push.example/a", "e1", "reminder", "10"); err != nil || ok {
+		t.Fatalf("expected the notification to be marked already, got %v, %v", ok, err)
+	}
+	if err := store.UnmarkPushSent(ctx, SCHEDULE_ID, "https://push.example/a", "e1", "reminder", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.MarkPushSent(ctx, SCHEDULE_ID, "https://push.example/a", "e1", "reminder", "10"); err != nil || !ok {
+		t.Fatalf("expected the unmarked notification to be marked, got %v, %v", ok, err)
+	}
+	if err := store.PrunePushSent(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.MarkPushSent(ctx, SCHEDULE_ID, "https://push.example/a", "e1", "reminder", "10"); err != nil || !ok {
+		t.Fatalf("expected the pruned notification to be marked, got %v, %v", ok, err)
+	}
+}
+
+func testWebhooks(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	if err := store.AddWebhookDelivery(ctx, db.WebhookDelivery{
+		ScheduleId: SCHEDULE_ID, URL: "https://hooks.example", EventId: "1", EventType: "selection.saved",
+		Attempt: 1, Status: 200, Date: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PruneWebhookDeliveries(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := store.MarkCountThreshold(ctx, SCHEDULE_ID, "e1", 10); err != nil || !ok {
+		t.Fatalf("expected the threshold to be marked, got %v, %v", ok, err)
+	}
+	if ok, err := store.MarkCountThreshold(ctx, SCHEDULE_ID, "e1", 10); err != nil || ok {
+		t.Fatalf("expected the threshold to be marked already, got %v, %v", ok, err)
+	}
+	if ok, err := store.MarkCountThreshold(ctx, SCHEDULE_ID, "e1", 20); err != nil || !ok {
+		t.Fatalf("expected the threshold to be marked, got %v, %v", ok, err)
+	}
+}
+
+func testAttendance(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	save(t, store, "s1", SCHEDULE_ID, "e1", "e2")
+	save(t, store, "s2", SCHEDULE_ID, "e1")
+	for _, a := range []struct {
+		sessionId string
+		eventId   string
+	}{{"s1", "e1"}, {"s1", "e2"}, {"s1", "e1"}, {"s2", "e1"}, {"s3", "e1"}} {
+		if err := store.SetAttended(ctx, a.sessionId, SCHEDULE_ID, a.eventId, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attendance, err := store.GetSessionAttendance(ctx, "s1", SCHEDULE_ID)
+	if err != nil || len(attendance) != 2 || attendance[0].EventId != "e1" || attendance[1].EventId != "e2" || attendance[0].Date == "" {
+		t.Fatalf("unexpected attendance %v, %v", attendance, err)
+	}
+	if attendance, err := store.GetSessionAttendance(ctx, "s1", "other"); err != nil || attendance == nil || len(attendance) != 0 {
+		t.Fatalf("expected no attendance in another schedule, got %v, %v", attendance, err)
+	}
+
+	// only sessions that still have the event bookmarked are counted
+	save(t, store, "s1", SCHEDULE_ID, "e1")
+	counts, err := store.GetAttendanceCounts(ctx, SCHEDULE_ID)
+	if expected := map[string]int{"e1": 2}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected attendance counts %v, got %v, %v", expected, counts, err)
+	}
+
+	if err := store.SetAttended(ctx, "s2", SCHEDULE_ID, "e1", false); err != nil {
+		t.Fatal(err)
+	}
+	counts, err = store.GetAttendanceCounts(ctx, SCHEDULE_ID)
+	if expected := map[string]int{"e1": 1}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected attendance counts %v, got %v, %v", expected, counts, err)
+	}
+}
+
+func testMergeSessions(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	save(t, store, "old", SCHEDULE_ID, "e1")
+	save(t, store, "old", "other", "e2")
+	save(t, store, "new", "other", "e3")
+	if err := store.SetPushSubscription(ctx, SCHEDULE_ID, db.PushSubscription{SessionId: "old", Endpoint: "https://push.example/a"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sessionId := range []string{"old", "new"} {
+		if err := store.SetAttended(ctx, sessionId, "other", "e3", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetRemovedEvents(ctx, SCHEDULE_ID, []db.RemovedEvent{{EventId: "e1", Title: "One"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.MergeSessions(ctx, "old", "new", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	sel, _, err := store.GetSessionSelection(ctx, "new", SCHEDULE_ID)
+	if err != nil || sel == nil || !slices.Equal(sel.GetEventIds(), []string{"e1"}) {
+		t.Fatalf("expected the moved selection, got %v, %v", sel, err)
+	}
+	sel, _, err = store.GetSessionSelection(ctx, "new", "other")
+	if err != nil || sel == nil || !slices.Equal(sel.GetEventIds(), []string{"e3"}) {
+		t.Fatalf("expected the target's selection to be kept, got %v, %v", sel, err)
+	}
+	if sel, _, err := store.GetSessionSelection(ctx, "old", SCHEDULE_ID); err != nil || sel != nil {
+		t.Fatalf("expected no selection for the old session, got %v, %v", sel, err)
+	}
+
+	subs, err := store.GetPushSubscriptions(ctx, SCHEDULE_ID)
+	if err != nil || len(subs) != 1 || subs[0].SessionId != "new" {
+		t.Fatalf("expected the moved subscription, got %v, %v", subs, err)
+	}
+
+	counts, err := store.GetEventSelectionCounts(ctx, "other")
+	if expected := map[string]int{"e3": 1}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected counts %v, got %v, %v", expected, counts, err)
+	}
+	attendance, err := store.GetSessionAttendance(ctx, "new", "other")
+	if err != nil || len(attendance) != 1 || attendance[0].EventId != "e3" {
+		t.Fatalf("expected the attendance to be merged, got %v, %v", attendance, err)
+	}
+	if attendance, err := store.GetSessionAttendance(ctx, "old", "other"); err != nil || len(attendance) != 0 {
+		t.Fatalf("expected no attendance for the old session, got %v, %v", attendance, err)
+	}
+	if removed, err := store.GetSessionRemovedEvents(ctx, "new", SCHEDULE_ID, db.Page{}); err != nil || len(removed) != 1 {
+		t.Fatalf("expected the removed event to be merged, got %v, %v", removed, err)
+	}
+
+	for _, scheduleId := range []string{SCHEDULE_ID, "other"} {
+		if id, err := store.ResolveSessionLink(ctx, "old", scheduleId); err != nil || id != "new" {
+			t.Fatalf("expected the link to resolve to new, got %s, %v", id, err)
+		}
+	}
+	if id, err := store.ResolveSessionLink(ctx, "unlinked", SCHEDULE_ID); err != nil || id != "unlinked" {
+		t.Fatalf("expected an unlinked ID to resolve to itself, got %s, %v", id, err)
+	}
+
+	// links to a merged session follow it
+	if err := store.MergeSessions(ctx, "new", "newest", ""); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := store.ResolveSessionLink(ctx, "old", SCHEDULE_ID); err != nil || id != "newest" {
+		t.Fatalf("expected the link to resolve to newest, got %s, %v", id, err)
+	}
+
+	if err := store.MergeSessions(ctx, "s1", "s2", SCHEDULE_ID); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := store.ResolveSessionLink(ctx, "s1", "other"); err != nil || id != "s1" {
+		t.Fatalf("expected the link to only apply to its schedule, got %s, %v", id, err)
+	}
+
+	linked, err := store.GetLinkedSessionIds(ctx, "newest")
+	if err != nil || !slices.Equal(linked, []string{"new", "old"}) {
+		t.Fatalf("unexpected linked IDs %v, %v", linked, err)
+	}
+}
+
+func testDeleteSessionData(t *testing.T, store db.Store) {
+	ctx := context.Background()
+
+	shared := save(t, store, "s1", SCHEDULE_ID, "e1")
+	own := save(t, store, "s1", SCHEDULE_ID, "e2")
+	save(t, store, "s2", SCHEDULE_ID, "e1")
+	if err := store.SetPushSubscription(ctx, SCHEDULE_ID, db.PushSubscription{SessionId: "s1", Endpoint: "https://push.example/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetAttended(ctx, "s1", SCHEDULE_ID, "e2", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MergeSessions(ctx, "s0", "s1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := store.GetSessionData(ctx, "s1")
+	if err != nil || len(data.Selections) != 1 || len(data.History) != 2 || len(data.PushSubscriptions) != 1 ||
+		len(data.Attendance) != 1 || !slices.Equal(data.LinkedIds, []string{"s0"}) {
+		t.Fatalf("unexpected session data %+v, %v", data, err)
+	}
+	if data.Selections[0].Hash != own || !slices.Equal(data.History[0].Events, []string{"e1"}) {
+		t.Fatalf("unexpected session data %+v", data)
+	}
+
+	if err := store.DeleteSessionData(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = store.GetSessionData(ctx, "s1")
+	if err != nil || len(data.Selections) != 0 || len(data.History) != 0 || len(data.PushSubscriptions) != 0 ||
+		len(data.Attendance) != 0 || len(data.LinkedIds) != 0 {
+		t.Fatalf("expected no session data, got %+v, %v", data, err)
+	}
+	if id, err := store.ResolveSessionLink(ctx, "s0", SCHEDULE_ID); err != nil || id != "s0" {
+		t.Fatalf("expected the link to be deleted, got %s, %v", id, err)
+	}
+
+	// the selection another session has is kept
+	if sel, err := store.GetSelection(ctx, SCHEDULE_ID, shared); err != nil || len(sel.GetEventIds()) != 1 {
+		t.Fatalf("expected the shared selection to be kept, got %v, %v", sel, err)
+	}
+	if sel, err := store.GetSelection(ctx, SCHEDULE_ID, own); err != nil || len(sel.GetEventIds()) != 0 {
+		t.Fatalf("expected the session's own selection to be deleted, got %v, %v", sel, err)
+	}
+
+	counts, err := store.GetEventSelectionCounts(ctx, SCHEDULE_ID)
+	if expected := map[string]int{"e1": 1}; err != nil || !maps.Equal(counts, expected) {
+		t.Fatalf("expected counts %v, got %v, %v", expected, counts, err)
+	}
+}
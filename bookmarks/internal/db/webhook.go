@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// WebhookDelivery is an attempt to deliver a webhook event.
+type WebhookDelivery struct {
+	ScheduleId string
+	URL        string
+	EventId    string
+	EventType  string
+	Attempt    int
+	Status     int
+	Error      string
+	Date       time.Time
+}
+
+func (db *DB) AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := db.writer.ExecContext(ctx,
+		"INSERT INTO webhook_delivery (schedule_id, url, event_id, event_type, attempt, status, error, date) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.ScheduleId, delivery.URL, delivery.EventId, delivery.EventType, delivery.Attempt,
+		delivery.Status, delivery.Error, delivery.Date.UTC().Format(DATE_FORMAT),
+	)
+	return err
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts, newest first, of
+// a schedule or of every schedule if scheduleId is empty.
+func (db *DB) GetWebhookDeliveries(ctx context.Context, scheduleId string, limit int) ([]WebhookDelivery, error) {
+	res, err := db.conn.QueryContext(ctx,
+		"SELECT schedule_id, url, event_id, event_type, attempt, status, error, date FROM webhook_delivery "+
+			"WHERE ? = '' OR schedule_id = ? ORDER BY id DESC LIMIT ?",
+		scheduleId, scheduleId, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for res.Next() {
+		var delivery WebhookDelivery
+		var date string
+		if err := res.Scan(
+			&delivery.ScheduleId, &delivery.URL, &delivery.EventId, &delivery.EventType,
+			&delivery.Attempt, &delivery.Status, &delivery.Error, &date,
+		); err != nil {
+			return nil, err
+		}
+		delivery.Date, err = time.Parse(DATE_FORMAT, date)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, res.Err()
+}
+
+// PruneWebhookDeliveries forgets delivery attempts before the given time.
+func (db *DB) PruneWebhookDeliveries(ctx context.Context, before time.Time) error {
+	_, err := db.writer.ExecContext(ctx,
+		"DELETE FROM webhook_delivery WHERE date < ?", before.UTC().Format(DATE_FORMAT),
+	)
+	return err
+}
+
+// MarkCountThreshold records that an event's bookmark count reached a
+// threshold, so it is only reported once. It returns false if it was already
+// recorded.
+func (db *DB) MarkCountThreshold(ctx context.Context, scheduleId string, eventId string, threshold int) (bool, error) {
+	res, err := db.writer.ExecContext(ctx,
+		"INSERT INTO webhook_threshold VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		scheduleId, eventId, threshold, time.Now().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+type ctxKey struct{}
+
+// requestAttrs holds the attributes attached to every log record made with a
+// request's context. Handlers add to it as they learn more about the request
+// (schedule ID, session), so the access log line includes them too.
+type requestAttrs struct {
+	lock      sync.Mutex
+	requestId string
+	attrs     []slog.Attr
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+// Setup installs a JSON logger writing to stderr as the default logger.
+func Setup(level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// WithRequestID returns a context carrying the given request ID.
+func WithRequestID(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &requestAttrs{
+		requestId: requestId,
+		attrs:     []slog.Attr{slog.String("request_id", requestId)},
+	})
+}
+
+// RequestID returns the request ID of the context, if any.
+func RequestID(ctx context.Context) string {
+	if ra, ok := ctx.Value(ctxKey{}).(*requestAttrs); ok {
+		return ra.requestId
+	}
+	return ""
+}
+
+// AddAttrs attaches attributes to all further log records of the request.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	ra, ok := ctx.Value(ctxKey{}).(*requestAttrs)
+	if !ok {
+		return
+	}
+
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	ra.attrs = append(ra.attrs, attrs...)
+}
+
+func getAttrs(ctx context.Context) []slog.Attr {
+	ra, ok := ctx.Value(ctxKey{}).(*requestAttrs)
+	if !ok {
+		return nil
+	}
+
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	return append([]slog.Attr(nil), ra.attrs...)
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(getAttrs(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware assigns each request an ID, taken from the X-Request-ID header
+// or generated, logs the request once it completes and recovers from panics.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestId := req.Header.Get(REQUEST_ID_HEADER)
+		if requestId == "" || len(requestId) > 64 {
+			requestId = nanoid.Must()
+		}
+
+		ctx := WithRequestID(req.Context(), requestId)
+		req = req.WithContext(ctx)
+		w.Header().Set(REQUEST_ID_HEADER, requestId)
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				slog.ErrorContext(ctx, "panic", "error", rec, "stack", string(debug.Stack()))
+				if sw.status == 0 {
+					http.Error(sw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}
+
+			slog.InfoContext(ctx, "request",
+				"method", req.Method,
+				"path", req.URL.Path,
+				"status", sw.status,
+				"bytes", sw.bytes,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+		}()
+
+		next.ServeHTTP(sw, req)
+	})
+}
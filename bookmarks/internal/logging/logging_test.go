@@ -0,0 +1,49 @@
+package logging_test
+
+import (
+	"bookmarks/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var seen string
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = logging.RequestID(req.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.REQUEST_ID_HEADER, "abc123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if seen != "abc123" {
+		t.Fatalf("expected abc123, got %s", seen)
+	}
+
+	if w.Header().Get(logging.REQUEST_ID_HEADER) != "abc123" {
+		t.Fatalf("expected abc123, got %s", w.Header().Get(logging.REQUEST_ID_HEADER))
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if seen == "" || seen == "abc123" {
+		t.Fatalf("expected a generated request ID, got %s", seen)
+	}
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("test")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+}
@@ -0,0 +1,9 @@
+package push
+
+// EncryptWith encrypts with a fixed application server key and salt, to
+// check the encryption against known vectors.
+var EncryptWith = encryptWith
+
+// PublicClient is the default client, which only connects to public
+// addresses.
+var PublicClient = publicClient
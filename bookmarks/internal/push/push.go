@@ -0,0 +1,388 @@
+package push
+
+import (
+	"bookmarks/internal/logging"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const RECORD_SIZE = 4096
+const JWT_EXPIRATION = 12 * time.Hour
+const SEND_TIMEOUT = 10 * time.Second
+
+var ErrGone = errors.New("push subscription is no longer valid")
+var ErrPayloadTooLarge = errors.New("push payload too large")
+var ErrInvalidKey = errors.New("invalid key")
+var ErrInvalidEndpoint = errors.New("invalid push endpoint")
+var ErrPrivateAddress = errors.New("push endpoint has a private address")
+
+// cgnatPrefix is the shared address space of RFC 6598, which is not public
+// but not covered by netip.Addr.IsPrivate.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicClient is the default client of senders without Hosts. It only
+// connects to public addresses, so endpoints can't reach internal services
+// through host names that resolve to private addresses.
+var publicClient = &http.Client{Transport: publicTransport()}
+
+// Subscription is a browser's PushSubscription.
+type Subscription struct {
+	Endpoint string
+	// P256dh is the user agent's public key.
+	P256dh string
+	// Auth is the user agent's authentication secret.
+	Auth string
+}
+
+// Keys is a VAPID key pair.
+type Keys struct {
+	PublicKey string
+	private   *ecdsa.PrivateKey
+}
+
+// Sender sends Web Push messages, encrypted per RFC 8291 and authenticated
+// with VAPID (RFC 8292).
+type Sender struct {
+	Keys *Keys
+	// Subject is a mailto: or https: URL to contact the sender.
+	Subject string
+	// Hosts are the hosts endpoints may be on, as for CheckSubscription.
+	Hosts  []string
+	Client *http.Client
+}
+
+// GenerateKeys creates a new VAPID key pair, encoded as unpadded base64url.
+func GenerateKeys() (publicKey string, privateKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return encode(key.PublicKey().Bytes()), encode(key.Bytes()), nil
+}
+
+// ParseKeys parses a VAPID key pair encoded as unpadded base64url.
+func ParseKeys(publicKey string, privateKey string) (*Keys, error) {
+	privBytes, err := decode(privateKey)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	ecdhKey, err := ecdh.P256().NewPrivateKey(privBytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	pub := ecdhKey.PublicKey().Bytes()
+	if publicKey != encode(pub) {
+		return nil, fmt.Errorf("%w: public key does not match private key", ErrInvalidKey)
+	}
+
+	return &Keys{
+		PublicKey: publicKey,
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:]),
+			},
+			D: new(big.Int).SetBytes(privBytes),
+		},
+	}, nil
+}
+
+// CheckSubscription checks that a subscription has valid keys and an https
+// endpoint on one of the hosts or their subdomains. Without hosts, any host
+// name is allowed except localhost, but not IP addresses.
+func CheckSubscription(sub Subscription, hosts []string) error {
+	if err := checkEndpoint(sub.Endpoint, hosts); err != nil {
+		return err
+	}
+
+	uaPublicBytes, err := decode(sub.P256dh)
+	if err != nil {
+		return ErrInvalidKey
+	}
+
+	if _, err := ecdh.P256().NewPublicKey(uaPublicBytes); err != nil {
+		return ErrInvalidKey
+	}
+
+	if authSecret, err := decode(sub.Auth); err != nil || len(authSecret) != 16 {
+		return ErrInvalidKey
+	}
+
+	return nil
+}
+
+// Send delivers an encrypted payload to a subscription. It returns ErrGone if
+// the push service reports that the subscription has expired.
+func (s *Sender) Send(ctx context.Context, sub Subscription, payload []byte, ttl time.Duration) error {
+	// subscriptions may have been saved before the hosts changed
+	if err := checkEndpoint(sub.Endpoint, s.Hosts); err != nil {
+		return err
+	}
+
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	auth, err := s.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl/time.Second)))
+	if requestId := logging.RequestID(ctx); requestId != "" {
+		req.Header.Set(logging.REQUEST_ID_HEADER, requestId)
+	}
+
+	client := s.Client
+	if client == nil && len(s.Hosts) == 0 {
+		client = publicClient
+	} else if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("unexpected http status %d from push service", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// checkEndpoint checks that an endpoint is an https URL on one of the hosts or
+// their subdomains, or without hosts, that it is not on localhost or an IP
+// address.
+func checkEndpoint(endpoint string, hosts []string) error {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Scheme != "https" || endpointURL.Hostname() == "" {
+		return fmt.Errorf("%w %q", ErrInvalidEndpoint, endpoint)
+	}
+
+	hostname := strings.ToLower(strings.TrimSuffix(endpointURL.Hostname(), "."))
+	if len(hosts) > 0 {
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			if hostname == host || strings.HasSuffix(hostname, "."+host) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s is not an allowed push host", ErrInvalidEndpoint, hostname)
+	}
+
+	if _, err := netip.ParseAddr(hostname); err == nil {
+		return fmt.Errorf("%w: %s is an IP address", ErrInvalidEndpoint, hostname)
+	}
+	if hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		return fmt.Errorf("%w: %s is local", ErrInvalidEndpoint, hostname)
+	}
+	return nil
+}
+
+// publicTransport returns a transport that refuses to connect to addresses
+// that are not public. It connects directly, as a proxy's address would be
+// checked instead of the endpoint's.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(addrPort.Addr()) {
+				return fmt.Errorf("%w %s", ErrPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// isPublic reports whether an address is a global unicast address outside
+// the private and shared ranges.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// authorization returns the VAPID Authorization header for an endpoint.
+func (s *Sender) authorization(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header := encode([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]any{
+		"aud": endpointURL.Scheme + "://" + endpointURL.Host,
+		"exp": time.Now().Add(JWT_EXPIRATION).Unix(),
+		"sub": s.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.Keys.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	sigBytes := make([]byte, 64)
+	r.FillBytes(sigBytes[:32])
+	sig.FillBytes(sigBytes[32:])
+
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", signingInput, encode(sigBytes), s.Keys.PublicKey), nil
+}
+
+// encrypt encrypts a payload for a subscription as a single aes128gcm record,
+// with a new application server key and salt.
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return encryptWith(sub, payload, asPrivate, salt)
+}
+
+func encryptWith(sub Subscription, payload []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	uaPublicBytes, err := decode(sub.P256dh)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	authSecret, err := decode(sub.Auth)
+	if err != nil || len(authSecret) == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	if len(payload)+1+16 > RECORD_SIZE {
+		return nil, ErrPayloadTooLarge
+	}
+
+	asPublicBytes := asPrivate.PublicKey().Bytes()
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicBytes...)
+	keyInfo = append(keyInfo, asPublicBytes...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single record, ending with the last record delimiter
+	plaintext := append(append([]byte{}, payload...), 2)
+
+	body := make([]byte, 0, 21+len(asPublicBytes)+len(plaintext)+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, RECORD_SIZE)
+	body = append(body, byte(len(asPublicBytes)))
+	body = append(body, asPublicBytes...)
+	body = gcm.Seal(body, nonce, plaintext, nil)
+
+	return body, nil
+}
+
+// hkdf derives a key of up to 32 bytes with HKDF-SHA-256.
+func hkdf(salt []byte, ikm []byte, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode accepts base64url with or without padding, as browsers differ.
+func decode(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(trimPadding(data))
+}
+
+func trimPadding(data string) string {
+	for len(data) > 0 && data[len(data)-1] == '=' {
+		data = data[:len(data)-1]
+	}
+	return data
+}
@@ -0,0 +1,220 @@
+package push_test
+
+import (
+	"bookmarks/internal/push"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	publicKey, privateKey, err := push.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := push.ParseKeys(publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	var body []byte
+	var header http.Header
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		body, _ = io.ReadAll(req.Body)
+		if strings.HasSuffix(req.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	hosts := []string{"127.0.0.1"}
+	sender := &push.Sender{Keys: keys, Subject: "mailto:test@example.com", Hosts: hosts, Client: srv.Client()}
+	sub := push.Subscription{
+		Endpoint: srv.URL + "/push/1",
+		P256dh:   base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(authSecret),
+	}
+
+	if err := push.CheckSubscription(sub, hosts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sender.Send(context.Background(), sub, []byte(`{"type":"test"}`), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("TTL") != "3600" || header.Get("Content-Encoding") != "aes128gcm" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+
+	checkVAPID(t, header.Get("Authorization"), publicKey, srv.URL)
+
+	// salt, record size, key ID length, key, then a single record
+	if len(body) != 21+65+len(`{"type":"test"}`)+1+16 || int(body[20]) != 65 {
+		t.Fatalf("unexpected body length %d", len(body))
+	}
+
+	sub.Endpoint = srv.URL + "/push/gone"
+	if err := sender.Send(context.Background(), sub, []byte("{}"), time.Hour); !errors.Is(err, push.ErrGone) {
+		t.Fatalf("expected ErrGone, got %v", err)
+	}
+
+	sub.Endpoint = "http://example.com/push"
+	if err := push.CheckSubscription(sub, nil); err == nil {
+		t.Fatal("expected an http endpoint to be rejected")
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := push.Subscription{
+		P256dh: base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(make([]byte, 16)),
+	}
+
+	cases := []struct {
+		endpoint string
+		hosts    []string
+		valid    bool
+	}{
+		{"https://push.example.com/1", nil, true},
+		{"https://127.0.0.1/1", nil, false},
+		{"https://[::1]:8443/1", nil, false},
+		{"https://10.0.0.1/1", nil, false},
+		{"https://localhost/1", nil, false},
+		{"https://api.localhost./1", nil, false},
+		{"https://fcm.googleapis.com/1", []string{"googleapis.com"}, true},
+		{"https://googleapis.com/1", []string{"googleapis.com"}, true},
+		{"https://evilgoogleapis.com/1", []string{"googleapis.com"}, false},
+		{"https://127.0.0.1/1", []string{"127.0.0.1"}, true},
+	}
+	for _, c := range cases {
+		sub.Endpoint = c.endpoint
+		err := push.CheckSubscription(sub, c.hosts)
+		if c.valid && err != nil {
+			t.Fatalf("expected %s to be allowed with %v, got %v", c.endpoint, c.hosts, err)
+		}
+		if !c.valid && !errors.Is(err, push.ErrInvalidEndpoint) {
+			t.Fatalf("expected %s to be rejected with %v, got %v", c.endpoint, c.hosts, err)
+		}
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("expected no request to a private address")
+	}))
+	defer srv.Close()
+
+	// as for a host name that resolves to a private address
+	if _, err := push.PublicClient.Get(srv.URL); !errors.Is(err, push.ErrPrivateAddress) {
+		t.Fatalf("expected ErrPrivateAddress, got %v", err)
+	}
+}
+
+// TestEncrypt checks the encryption against the example in RFC 8291,
+// Appendix A.
+func TestEncrypt(t *testing.T) {
+	decode := func(s string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	asPrivate, err := ecdh.P256().NewPrivateKey(decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := push.Subscription{
+		Endpoint: "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV",
+		P256dh:   "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:     "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	salt := decode("DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := push.EncryptWith(sub, []byte("When I grow up, I want to be a watermelon"), asPrivate, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := decode("DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	if !bytes.Equal(body, expected) {
+		t.Fatalf("expected the RFC 8291 example body\n%x, got\n%x", expected, body)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	publicKey, _, _ := push.GenerateKeys()
+	_, privateKey, _ := push.GenerateKeys()
+
+	if _, err := push.ParseKeys(publicKey, privateKey); !errors.Is(err, push.ErrInvalidKey) {
+		t.Fatalf("expected mismatched keys to be rejected, got %v", err)
+	}
+}
+
+func checkVAPID(t *testing.T, auth string, publicKey string, audience string) {
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "vapid "), ", ") {
+		if val, ok := strings.CutPrefix(part, "t="); ok {
+			token = val
+		} else if val, ok := strings.CutPrefix(part, "k="); ok {
+			key = val
+		}
+	}
+
+	if key != publicKey {
+		t.Fatalf("expected key %s, got %s", publicKey, key)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid token %q", token)
+	}
+
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !strings.Contains(string(claims), `"aud":"`+audience+`"`) {
+		t.Fatalf("unexpected claims %s", claims)
+	}
+
+	pub, _ := base64.RawURLEncoding.DecodeString(publicKey)
+	ecdsaKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pub[1:33]),
+		Y:     new(big.Int).SetBytes(pub[33:]),
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(sig) != 64 || !ecdsa.Verify(ecdsaKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Fatal("invalid VAPID signature")
+	}
+}
@@ -0,0 +1,163 @@
+package selection
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// CODE_PREFIX starts share codes, which encode a selection without storing
+// it: a short hash of each selected event ID.
+const CODE_PREFIX = "c2."
+
+// LEGACY_CODE_PREFIX starts codes from before CODE_PREFIX, a bitset over a
+// version of the schedule's event IDs, which can only be read while that
+// version is known.
+const LEGACY_CODE_PREFIX = "c1."
+
+// VERSION_SIZE is the size in bytes of the event list version in a legacy
+// code.
+const VERSION_SIZE = 4
+
+// EVENT_HASH_SIZE is the size in bytes of each event ID's hash in a code.
+const EVENT_HASH_SIZE = 4
+
+// MAX_EVENT_LISTS is the number of previous versions of each schedule's
+// event IDs kept, so legacy codes made with them can still be read.
+const MAX_EVENT_LISTS = 32
+
+var ErrInvalidCode = errors.New("invalid share code")
+
+// Code is a parsed share code: the hashes of the selected event IDs, or for a
+// legacy code, a bitset over a sorted list of event IDs and the version of
+// that list.
+type Code struct {
+	// Version is the event list version of a legacy code, or "" if the
+	// code does not depend on one.
+	Version string
+	bits    []byte
+	hashes  map[string]struct{}
+}
+
+// Version returns the version of a sorted list of event IDs, as used in
+// legacy codes.
+func Version(eventIds []string) string {
+	jsonData, err := json.Marshal(eventIds)
+	if err != nil {
+		panic(err)
+	}
+
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:VERSION_SIZE])
+}
+
+func eventHash(eventId string) string {
+	sum := sha256.Sum256([]byte(eventId))
+	return string(sum[:EVENT_HASH_SIZE])
+}
+
+// Code returns a share code for the selection. Selected events that are not
+// in eventIds are left out.
+func (s *Selection) Code(eventIds []string) string {
+	hashes := make([][]byte, 0, len(s.ids))
+	for _, eventId := range eventIds {
+		if _, ok := s.idSet[eventId]; ok {
+			hashes = append(hashes, []byte(eventHash(eventId)))
+		}
+	}
+	slices.SortFunc(hashes, bytes.Compare)
+	hashes = slices.CompactFunc(hashes, bytes.Equal)
+
+	return CODE_PREFIX + base64.RawURLEncoding.EncodeToString(bytes.Join(hashes, nil))
+}
+
+// LegacyCode returns a legacy share code for the selection over the sorted
+// event IDs. Selected events that are not in the list are left out.
+func (s *Selection) LegacyCode(eventIds []string) string {
+	version, err := hex.DecodeString(Version(eventIds))
+	if err != nil {
+		panic(err)
+	}
+
+	bits := make([]byte, (len(eventIds)+7)/8)
+	size := 0
+	for i, eventId := range eventIds {
+		if _, ok := s.idSet[eventId]; ok {
+			bits[i/8] |= 1 << (i % 8)
+			size = i/8 + 1
+		}
+	}
+
+	return LEGACY_CODE_PREFIX + base64.RawURLEncoding.EncodeToString(append(version, bits[:size]...))
+}
+
+// ParseCode parses a share code. Its events are read with Selection.
+func ParseCode(code string) (Code, error) {
+	if encoded, ok := strings.CutPrefix(code, LEGACY_CODE_PREFIX); ok {
+		data, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil || len(data) < VERSION_SIZE {
+			return Code{}, ErrInvalidCode
+		}
+
+		return Code{
+			Version: hex.EncodeToString(data[:VERSION_SIZE]),
+			bits:    data[VERSION_SIZE:],
+		}, nil
+	}
+
+	encoded, ok := strings.CutPrefix(code, CODE_PREFIX)
+	if !ok {
+		return Code{}, ErrInvalidCode
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data)%EVENT_HASH_SIZE != 0 {
+		return Code{}, ErrInvalidCode
+	}
+
+	hashes := make(map[string]struct{}, len(data)/EVENT_HASH_SIZE)
+	for i := 0; i < len(data); i += EVENT_HASH_SIZE {
+		hashes[string(data[i:i+EVENT_HASH_SIZE])] = struct{}{}
+	}
+	return Code{hashes: hashes}, nil
+}
+
+// Selection returns the code's selection. For a legacy code, eventIds are
+// the sorted event IDs of its version. Otherwise they are every ID the
+// selected events may have, and those whose hashes are in the code are
+// selected.
+func (c Code) Selection(eventIds []string) (*Selection, error) {
+	if c.Version == "" {
+		selected := make([]string, 0)
+		for _, eventId := range eventIds {
+			if _, ok := c.hashes[eventHash(eventId)]; ok {
+				selected = append(selected, eventId)
+			}
+		}
+		return NewSelection(selected), nil
+	}
+
+	if len(c.bits) > (len(eventIds)+7)/8 {
+		return nil, ErrInvalidCode
+	}
+
+	selected := make([]string, 0)
+	for i, b := range c.bits {
+		for bit := range 8 {
+			if b&(1<<bit) == 0 {
+				continue
+			}
+			if i*8+bit >= len(eventIds) {
+				return nil, ErrInvalidCode
+			}
+			selected = append(selected, eventIds[i*8+bit])
+		}
+	}
+
+	return NewSelection(selected), nil
+}
@@ -1,17 +1,23 @@
 package selection
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"slices"
 	"strings"
 )
 
+// HASH_PREFIX starts the hashes of the current scheme. Legacy hashes have no
+// prefix.
+const HASH_PREFIX = "v2."
+
 type Selection struct {
 	idSet map[string]struct{}
 	ids   []string
-	hash  string
+	id    string
 }
 
 func NewSelection(eventIds []string) *Selection {
@@ -27,17 +33,53 @@ func NewSelection(eventIds []string) *Selection {
 	return &Selection{idSet: idSet, ids: idSlice}
 }
 
+// LoadSelection returns a selection stored with the ID.
+func LoadSelection(id string, eventIds []string) *Selection {
+	sel := NewSelection(eventIds)
+	sel.id = id
+	return sel
+}
+
+// Id returns the ID the selection was stored with, which may be a legacy
+// hash, or "" if it was not loaded from storage.
+func (s *Selection) Id() string {
+	return s.id
+}
+
 func (s *Selection) GetEventIds() []string {
 	ids := make([]string, 0, len(s.ids))
 	ids = append(ids, s.ids...)
 	return ids
 }
 
-func (s *Selection) Hash() string {
-	if s.hash != "" {
-		return s.hash
+// Hash returns the selection's ID in the current scheme: HASH_PREFIX and the
+// HMAC-SHA256 of the sorted event IDs, keyed with key. If key is empty, it is
+// a plain SHA-256 hash.
+func (s *Selection) Hash(key []byte) string {
+	var hashBytes []byte
+	if len(key) > 0 {
+		h := hmac.New(sha256.New, key)
+		h.Write(s.sortedJSON())
+		hashBytes = h.Sum(nil)
+	} else {
+		sum := sha256.Sum256(s.sortedJSON())
+		hashBytes = sum[:]
 	}
 
+	return HASH_PREFIX + base64.RawURLEncoding.EncodeToString(hashBytes)
+}
+
+// LegacyHash returns the unversioned hash, a truncated MD5 of the sorted
+// event IDs, which IDs were before the current scheme.
+func (s *Selection) LegacyHash() string {
+	h := md5.New()
+	h.Write(s.sortedJSON())
+	hashBytes := h.Sum(nil)
+	hash := base64.URLEncoding.EncodeToString(hashBytes)
+	return strings.TrimRight(hash, "=")
+}
+
+func (s *Selection) sortedJSON() []byte {
 	sorted := make([]string, 0, len(s.ids))
 	sorted = append(sorted, s.ids...)
 	slices.Sort(sorted)
@@ -46,12 +88,5 @@ func (s *Selection) Hash() string {
 	if err != nil {
 		panic(err)
 	}
-
-	h := md5.New()
-	h.Write(jsonData)
-	hashBytes := h.Sum(nil)
-	hash := base64.URLEncoding.EncodeToString(hashBytes)
-	hash = strings.TrimRight(hash, "=")
-	s.hash = hash
-	return hash
+	return jsonData
 }
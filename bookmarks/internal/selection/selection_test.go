@@ -0,0 +1,88 @@
+package selection_test
+
+import (
+	"bookmarks/internal/selection"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	sel := selection.NewSelection([]string{"e2", "e1", "e2"})
+	same := selection.NewSelection([]string{"e1", "e2"})
+
+	hash := sel.Hash([]byte("key"))
+	if !strings.HasPrefix(hash, selection.HASH_PREFIX) || hash != same.Hash([]byte("key")) {
+		t.Fatalf("unexpected hash %s", hash)
+	}
+
+	if hash == sel.Hash([]byte("other")) || hash == sel.Hash(nil) {
+		t.Fatal("expected the hash to depend on the key")
+	}
+
+	// hashes from before versioning must not change
+	if legacy := sel.LegacyHash(); legacy != "4UtInEt9Bf60W0GQSRquZw" {
+		t.Fatalf("unexpected legacy hash %s", legacy)
+	}
+}
+
+func TestCode(t *testing.T) {
+	eventIds := []string{"e1", "e10", "e2", "e3", "e4", "e5", "e6", "e7", "e8", "e9"}
+	sel := selection.NewSelection([]string{"e9", "e2", "unknown"})
+
+	code := sel.Code(eventIds)
+	parsed, err := selection.ParseCode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != "" {
+		t.Fatalf("expected no version, got %s", parsed.Version)
+	}
+
+	// the code is read against any list with the events
+	decoded, err := parsed.Selection([]string{"e0", "e2", "e5", "e9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded.GetEventIds(), []string{"e2", "e9"}) {
+		t.Fatalf("expected [e2 e9], got %v", decoded.GetEventIds())
+	}
+
+	for _, invalid := range []string{"", "c2.!!", "c2.AAA", sel.Hash(nil)} {
+		if _, err := selection.ParseCode(invalid); err != selection.ErrInvalidCode {
+			t.Fatalf("expected ErrInvalidCode for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestLegacyCode(t *testing.T) {
+	eventIds := []string{"e1", "e10", "e2", "e3", "e4", "e5", "e6", "e7", "e8", "e9"}
+	sel := selection.NewSelection([]string{"e9", "e2", "unknown"})
+
+	code := sel.LegacyCode(eventIds)
+	parsed, err := selection.ParseCode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != selection.Version(eventIds) {
+		t.Fatalf("unexpected version %s", parsed.Version)
+	}
+
+	decoded, err := parsed.Selection(eventIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded.GetEventIds(), []string{"e2", "e9"}) {
+		t.Fatalf("expected [e2 e9], got %v", decoded.GetEventIds())
+	}
+
+	if _, err := parsed.Selection(eventIds[:3]); err != selection.ErrInvalidCode {
+		t.Fatalf("expected ErrInvalidCode, got %v", err)
+	}
+
+	for _, invalid := range []string{"c1.", "c1.!!"} {
+		if _, err := selection.ParseCode(invalid); err != selection.ErrInvalidCode {
+			t.Fatalf("expected ErrInvalidCode for %q, got %v", invalid, err)
+		}
+	}
+}
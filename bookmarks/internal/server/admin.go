@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bookmarks/internal/structs"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminMiddleware requires the admin token as a bearer token. The admin
+// routes are not found if no token is configured.
+func (s *server) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := s.getConfig().AdminToken
+		if token == "" {
+			http.NotFound(w, req)
+			return
+		}
+
+		reqToken, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// adminCountsHandler returns a schedule's exact counts, without the count
+// privacy settings applied, and the attendance of the events with check-ins.
+func (s *server) adminCountsHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	if _, ok := s.getConfig().ScheduleURLs[scheduleId]; !ok {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := s.db.GetEventSelectionCounts(req.Context(), scheduleId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting selection counts", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	attended, err := s.db.GetAttendanceCounts(req.Context(), scheduleId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting attendance counts", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	attendance := make(map[string]structs.EventAttendance, len(attended))
+	for eventId, count := range attended {
+		// check-ins are only counted while the event is bookmarked, so
+		// the event has a selection count
+		bookmarked := max(res[eventId], count)
+		attendance[eventId] = structs.EventAttendance{
+			Bookmarked: bookmarked,
+			Attended:   count,
+			Ratio:      float64(count) / float64(bookmarked),
+		}
+	}
+
+	jsonResponse(w, structs.AdminCountsResponse{
+		Counts:     res,
+		Attendance: attendance,
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bookmarks/internal/logging"
+	"bookmarks/internal/structs"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getAttendanceHandler returns the events the session checked in to. A
+// request without a session has none.
+func (s *server) getAttendanceHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	if _, ok := s.getConfig().ScheduleURLs[scheduleId]; !ok {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	respBody := structs.AttendanceResponse{
+		Attendance: make([]structs.Attendance, 0),
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		jsonResponse(w, respBody)
+		return
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	attendance, err := s.db.GetSessionAttendance(req.Context(), sessionId.Id, scheduleId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting attendance", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	eventIds := make([]string, 0, len(attendance))
+	for _, a := range attendance {
+		eventIds = append(eventIds, a.EventId)
+	}
+	eventIds = s.validator.ResolveAliases(req.Context(), scheduleId, eventIds)
+	for i, a := range attendance {
+		respBody.Attendance = append(respBody.Attendance, structs.Attendance{EventId: eventIds[i], Date: a.Date})
+	}
+	jsonResponse(w, respBody)
+}
+
+// setAttendanceHandler checks the session in to an event, or with DELETE,
+// cancels the check-in. Only bookmarked events can be checked in to.
+func (s *server) setAttendanceHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	eventId := chi.URLParam(req, "eventId")
+	attended := req.Method != http.MethodDelete
+
+	if _, ok := s.getConfig().ScheduleURLs[scheduleId]; !ok {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	// the selection may have an event's old ID, which is the one that is
+	// stored and counted
+	stored, err := s.attendanceEventIds(req, sessionId.Id, scheduleId, attended)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if i := slices.Index(s.validator.ResolveAliases(req.Context(), scheduleId, stored), eventId); i >= 0 {
+		eventId = stored[i]
+	} else if attended {
+		httpError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.db.SetAttended(req.Context(), sessionId.Id, scheduleId, eventId, attended); err != nil {
+		slog.ErrorContext(req.Context(), "error saving attendance", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// attendanceEventIds returns the stored IDs of the events the session has
+// bookmarked, or if not attended, of the events it checked in to.
+func (s *server) attendanceEventIds(req *http.Request, sessionId string, scheduleId string, attended bool) ([]string, error) {
+	if !attended {
+		attendance, err := s.db.GetSessionAttendance(req.Context(), sessionId, scheduleId)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "error getting attendance", "error", err)
+			return nil, err
+		}
+		eventIds := make([]string, 0, len(attendance))
+		for _, a := range attendance {
+			eventIds = append(eventIds, a.EventId)
+		}
+		return eventIds, nil
+	}
+
+	sel, _, err := s.db.GetSessionSelection(req.Context(), sessionId, scheduleId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting session selection", "error", err)
+		return nil, err
+	}
+	if sel == nil {
+		return nil, nil
+	}
+	return sel.GetEventIds(), nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const BACKUP_CHECK_INTERVAL = time.Minute
+const BACKUP_PREFIX = "bookmarks-"
+const BACKUP_SUFFIX = ".sqlite"
+const BACKUP_DATE_FORMAT = "20060102T150405Z"
+
+// adminBackupHandler streams a consistent copy of the database.
+func (s *server) adminBackupHandler(w http.ResponseWriter, req *http.Request) {
+	tmpDir, err := os.MkdirTemp(s.getConfig().BackupDir, ".backup-*")
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error creating backup directory", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := BACKUP_PREFIX + time.Now().UTC().Format(BACKUP_DATE_FORMAT) + BACKUP_SUFFIX
+	backupPath := filepath.Join(tmpDir, name)
+	if err := s.db.Backup(req.Context(), backupPath); err != nil {
+		slog.ErrorContext(req.Context(), "error backing up database", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(backupPath)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error reading backup", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error reading backup", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if _, err := io.Copy(w, f); err != nil {
+		slog.WarnContext(req.Context(), "error sending backup", "error", err)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "sent backup", "size", info.Size())
+}
+
+// runBackups writes a snapshot to the backup directory whenever the last one
+// is older than the backup interval, checking every BACKUP_CHECK_INTERVAL
+// until the context is done.
+func (s *server) runBackups(ctx context.Context) {
+	ticker := time.NewTicker(BACKUP_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			config := s.getConfig()
+			if config.BackupDir == "" {
+				continue
+			}
+
+			if err := s.snapshot(ctx, config.BackupDir, config.BackupInterval, config.BackupKeep, now); err != nil {
+				slog.ErrorContext(ctx, "error writing backup", "dir", config.BackupDir, "error", err)
+			}
+		}
+	}
+}
+
+// snapshot writes a snapshot to dir if the newest one is older than
+// interval, then deletes all but the newest keep snapshots.
+func (s *server) snapshot(ctx context.Context, dir string, interval time.Duration, keep int, now time.Time) error {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) > 0 {
+		last, err := time.Parse(BACKUP_DATE_FORMAT, strings.TrimSuffix(strings.TrimPrefix(snapshots[len(snapshots)-1], BACKUP_PREFIX), BACKUP_SUFFIX))
+		if err == nil && now.Sub(last) < interval {
+			return nil
+		}
+	}
+
+	// written under a hidden name, so incomplete snapshots are not listed
+	name := BACKUP_PREFIX + now.UTC().Format(BACKUP_DATE_FORMAT) + BACKUP_SUFFIX
+	tmpPath := filepath.Join(dir, "."+name)
+	os.Remove(tmpPath)
+	if err := s.db.Backup(ctx, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	slog.InfoContext(ctx, "wrote backup", "path", filepath.Join(dir, name))
+
+	snapshots = append(snapshots, name)
+	for _, old := range snapshots[:max(len(snapshots)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, old)); err != nil {
+			slog.WarnContext(ctx, "error deleting old backup", "path", filepath.Join(dir, old), "error", err)
+		}
+	}
+
+	return nil
+}
+
+// listSnapshots returns the names of the snapshots in dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), BACKUP_PREFIX) && strings.HasSuffix(entry.Name(), BACKUP_SUFFIX) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bookmarks/internal/selection"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/validator"
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *server) decodeShareCodeHandler(w http.ResponseWriter, req *http.Request) {
+	resp, status := s.decodeShareCode(req)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+func (s *server) decodeShareCode(req *http.Request) (*structs.BookmarksResponse, int) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	code := chi.URLParam(req, "code")
+
+	parsed, err := selection.ParseCode(code)
+	if err != nil {
+		return nil, http.StatusNotFound
+	}
+
+	var eventIds []string
+	if parsed.Version != "" {
+		eventIds, err = s.validator.EventIdsVersion(req.Context(), scheduleId, parsed.Version)
+	} else {
+		eventIds, err = s.validator.KnownEventIds(req.Context(), scheduleId)
+	}
+	if err == validator.ErrNoSchedule || err == validator.ErrUnknownVersion {
+		return nil, http.StatusNotFound
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "error getting event IDs", "error", err)
+		return nil, http.StatusInternalServerError
+	}
+
+	sel, err := parsed.Selection(eventIds)
+	if err != nil {
+		return nil, http.StatusNotFound
+	}
+
+	events := s.resolveAliases(req.Context(), scheduleId, sel)
+	resp := structs.BookmarksResponse{
+		Id: code, Events: events, Code: s.shareCode(req.Context(), scheduleId, events),
+	}
+	return &resp, http.StatusOK
+}
+
+// shareCode returns a share code for the events over the schedule's current
+// events, or "" if they are unavailable.
+func (s *server) shareCode(ctx context.Context, scheduleId string, events []string) string {
+	eventIds, err := s.validator.EventIds(ctx, scheduleId)
+	if err != nil {
+		slog.WarnContext(ctx, "error getting event IDs for share code", "error", err)
+		return ""
+	}
+
+	return selection.NewSelection(events).Code(eventIds)
+}
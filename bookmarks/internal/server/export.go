@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bookmarks/internal/logging"
+	"bookmarks/internal/structs"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *server) exportHandler(w http.ResponseWriter, req *http.Request) {
+	ids := s.getSessionIds(req)
+	if len(ids) > 0 {
+		logging.AddAttrs(req.Context(), slog.Any("session", ids[0]))
+	}
+
+	sessionIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		sessionIds = append(sessionIds, id.Id)
+	}
+
+	respBody, err := s.exportSessions(req.Context(), sessionIds)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error exporting sessions", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"bookmarks.json\"")
+	jsonResponse(w, respBody)
+}
+
+func (s *server) eraseHandler(w http.ResponseWriter, req *http.Request) {
+	config := s.getConfig()
+	ids := s.getSessionIds(req)
+	if len(ids) > 0 {
+		logging.AddAttrs(req.Context(), slog.Any("session", ids[0]))
+	}
+
+	sessionIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		sessionIds = append(sessionIds, id.Id)
+	}
+
+	if err := s.eraseSessions(req.Context(), sessionIds); err != nil {
+		slog.ErrorContext(req.Context(), "error erasing sessions", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, cookie := range req.Cookies() {
+		if _, ok := cutCookieName(cookie.Name); ok || cookie.Name == GLOBAL_COOKIE_NAME {
+			http.SetCookie(w, &http.Cookie{
+				Name:   cookie.Name,
+				MaxAge: -1,
+				Path:   "/",
+				Domain: config.Domain,
+			})
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) adminExportSessionHandler(w http.ResponseWriter, req *http.Request) {
+	sessionId := chi.URLParam(req, "sessionId")
+
+	respBody, err := s.exportSessions(req.Context(), []string{sessionId})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error exporting session", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if len(respBody.Sessions) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+	jsonResponse(w, respBody)
+}
+
+func (s *server) adminEraseSessionHandler(w http.ResponseWriter, req *http.Request) {
+	sessionId := chi.URLParam(req, "sessionId")
+
+	if err := s.eraseSessions(req.Context(), []string{sessionId}); err != nil {
+		slog.ErrorContext(req.Context(), "error erasing session", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkedSessionIds returns the session IDs with those merged into them.
+func (s *server) linkedSessionIds(ctx context.Context, sessionIds []string) ([]string, error) {
+	res := make([]string, 0, len(sessionIds))
+	for _, sessionId := range sessionIds {
+		linked, err := s.db.GetLinkedSessionIds(ctx, sessionId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range append([]string{sessionId}, linked...) {
+			if !slices.Contains(res, id) {
+				res = append(res, id)
+			}
+		}
+	}
+	return res, nil
+}
+
+// exportSessions returns the data of the sessions, and of the sessions merged
+// into them, that have any.
+func (s *server) exportSessions(ctx context.Context, sessionIds []string) (structs.ExportResponse, error) {
+	respBody := structs.ExportResponse{
+		Sessions: make([]structs.ExportSession, 0),
+	}
+
+	sessionIds, err := s.linkedSessionIds(ctx, sessionIds)
+	if err != nil {
+		return respBody, err
+	}
+
+	for _, sessionId := range sessionIds {
+		data, err := s.db.GetSessionData(ctx, sessionId)
+		if err != nil {
+			return respBody, err
+		}
+
+		if len(data.Selections) == 0 && len(data.History) == 0 && len(data.PushSubscriptions) == 0 && len(data.Attendance) == 0 {
+			continue
+		}
+
+		session := structs.ExportSession{
+			Id:                sessionId,
+			Selections:        make([]structs.ExportSelection, 0, len(data.Selections)),
+			History:           make([]structs.ExportSelection, 0, len(data.History)),
+			PushSubscriptions: make([]structs.ExportPushSubscription, 0, len(data.PushSubscriptions)),
+			Attendance:        make([]structs.ExportAttendance, 0, len(data.Attendance)),
+			LinkedIds:         data.LinkedIds,
+		}
+		for _, sel := range data.Selections {
+			session.Selections = append(session.Selections, structs.ExportSelection{
+				ScheduleId: sel.ScheduleId, Id: sel.Hash, Date: sel.Date, Events: sel.Events,
+			})
+		}
+		for _, sel := range data.History {
+			session.History = append(session.History, structs.ExportSelection{
+				ScheduleId: sel.ScheduleId, Id: sel.Hash, Date: sel.Date, Events: sel.Events,
+			})
+		}
+		for _, sub := range data.PushSubscriptions {
+			session.PushSubscriptions = append(session.PushSubscriptions, structs.ExportPushSubscription{
+				ScheduleId:      sub.ScheduleId,
+				Endpoint:        sub.Endpoint,
+				ReminderMinutes: sub.ReminderMinutes,
+				ChangeAlerts:    sub.ChangeAlerts,
+			})
+		}
+		for _, a := range data.Attendance {
+			session.Attendance = append(session.Attendance, structs.ExportAttendance{
+				ScheduleId: a.ScheduleId, EventId: a.EventId, Date: a.Date,
+			})
+		}
+		respBody.Sessions = append(respBody.Sessions, session)
+	}
+
+	return respBody, nil
+}
+
+// eraseSessions deletes the sessions and the sessions merged into them.
+func (s *server) eraseSessions(ctx context.Context, sessionIds []string) error {
+	sessionIds, err := s.linkedSessionIds(ctx, sessionIds)
+	if err != nil {
+		return err
+	}
+
+	for _, sessionId := range sessionIds {
+		if err := s.db.DeleteSessionData(ctx, sessionId); err != nil {
+			return err
+		}
+	}
+
+	// counts may include the erased sessions
+	s.clearCountCache()
+	return nil
+}
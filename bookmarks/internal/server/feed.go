@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/validator"
+	"bookmarks/internal/webhook"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// feedChanged records the changes between two fetches of a schedule's feed.
+func (s *server) feedChanged(ctx context.Context, scheduleId string, changes []validator.Change) {
+	date := time.Now()
+	now := date.Format(time.RFC3339Nano)
+	removed := make([]db.RemovedEvent, 0)
+	restored := make([]string, 0)
+	changeLog := make([]db.EventChange, 0, len(changes))
+
+	for _, change := range changes {
+		changeLog = append(changeLog, newEventChange(change, date))
+
+		switch change.Kind {
+		case validator.CHANGE_REMOVED:
+			removed = append(removed, db.RemovedEvent{
+				EventId:  change.EventId,
+				Title:    change.Old.Title,
+				Start:    change.Old.Start,
+				End:      change.Old.End,
+				Location: change.Old.Location,
+				Date:     now,
+			})
+		case validator.CHANGE_ADDED:
+			restored = append(restored, change.EventId)
+		}
+	}
+
+	slog.InfoContext(ctx, "feed changed", "schedule", scheduleId, "changes", len(changes))
+
+	if err := s.db.AddEventChanges(ctx, scheduleId, changeLog); err != nil {
+		slog.ErrorContext(ctx, "error recording event changes", "schedule", scheduleId, "error", err)
+	}
+
+	if err := s.db.SetRemovedEvents(ctx, scheduleId, removed, restored); err != nil {
+		slog.ErrorContext(ctx, "error recording removed events", "schedule", scheduleId, "error", err)
+	}
+
+	if hooks := s.getConfig().GetWebhooks(scheduleId); len(hooks) > 0 {
+		s.webhooks.Dispatch(ctx, hooks, webhook.FEED_CHANGED, scheduleId, feedChangedData(changeLog))
+	}
+
+	go s.sendChangeAlerts(context.WithoutCancel(ctx), scheduleId, changes)
+}
+
+func newEventChange(change validator.Change, date time.Time) db.EventChange {
+	res := db.EventChange{
+		EventId: change.EventId,
+		Kind:    string(change.Kind),
+		Date:    date,
+	}
+
+	if change.New != nil {
+		res.Title = change.New.Title
+	} else {
+		res.Title = change.Old.Title
+	}
+
+	switch change.Kind {
+	case validator.CHANGE_TIME:
+		res.Old = change.Old.Start + "/" + change.Old.End
+		res.New = change.New.Start + "/" + change.New.End
+	case validator.CHANGE_LOCATION:
+		res.Old = change.Old.Location
+		res.New = change.New.Location
+	case validator.CHANGE_TITLE:
+		res.Old = change.Old.Title
+		res.New = change.New.Title
+	}
+
+	return res
+}
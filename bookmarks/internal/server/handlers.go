@@ -3,16 +3,20 @@ package server
 import (
 	"bookmarks/internal/config"
 	"bookmarks/internal/db"
+	"bookmarks/internal/logging"
 	"bookmarks/internal/selection"
 	"bookmarks/internal/structs"
 	"bookmarks/internal/validator"
+	"bookmarks/internal/webhook"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -20,40 +24,58 @@ import (
 )
 
 type server struct {
-	db         *db.DB
-	config     *config.Config
+	db         db.Store
+	cfg        atomic.Pointer[config.Config]
 	validator  *validator.Validator
 	countCache *lru.TTLCache[string, map[string]int]
+	webhooks   *webhook.Dispatcher
+	// pushClient sends push notifications, the default client if nil.
+	pushClient       *http.Client
+	reminderInterval time.Duration
+	// emptySelection is the response for sessions without bookmarks.
+	emptySelection *structs.SessionBookmarksResponse
 }
 
-var emptySelectionResponse = func() *structs.SessionBookmarksResponse {
+// newEmptySelectionResponse returns the response for sessions without
+// bookmarks. Its ID is keyed like those the store mints, with the secret.
+func newEmptySelectionResponse(secret string) *structs.SessionBookmarksResponse {
 	sel := selection.NewSelection([]string{})
 	return &structs.SessionBookmarksResponse{
-		Id:     sel.Hash(),
+		Id:     sel.Hash([]byte(secret)),
 		Date:   time.Unix(0, 0).Format(time.RFC3339),
 		Events: sel.GetEventIds(),
 	}
-}()
+}
 
 func (s *server) getSelectionHandler(w http.ResponseWriter, req *http.Request) {
+	resp, status := s.getSelection(req)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+func (s *server) getSelection(req *http.Request) (*structs.BookmarksResponse, int) {
 	scheduleId := chi.URLParam(req, "scheduleId")
 	hash := chi.URLParam(req, "hash")
 
-	sel, err := s.db.GetSelection(scheduleId, hash)
+	sel, err := s.db.GetSelection(req.Context(), scheduleId, hash)
 	if err != nil {
-		http.NotFound(w, req)
-		return
+		return nil, http.StatusNotFound
 	}
 
+	events := s.resolveAliases(req.Context(), scheduleId, sel)
 	resp := structs.BookmarksResponse{
-		Id: hash, Events: sel.GetEventIds(),
+		Id: hash, Events: events, Code: s.shareCode(req.Context(), scheduleId, events),
 	}
-	jsonResponse(w, resp)
+	return &resp, http.StatusOK
 }
 
 func (s *server) setupSessionHandler(w http.ResponseWriter, req *http.Request) {
 	scheduleId := chi.URLParam(req, "scheduleId")
-	if _, ok := s.config.ScheduleURLs[scheduleId]; !ok {
+	config := s.getConfig()
+	if _, ok := config.ScheduleURLs[scheduleId]; !ok {
 		httpError(w, http.StatusNotFound)
 		return
 	}
@@ -75,16 +97,24 @@ func (s *server) setupSessionHandler(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		sessionId, err = verifySessionId(sessionReq.SessionID, s.config.Secret)
+		sessionId, err = verifySessionId(sessionReq.SessionID, config.Secret)
+		if err == nil {
+			sessionId = s.resolveSessionLink(req.Context(), sessionId, scheduleId)
+			err = s.checkRevoked(req.Context(), sessionId)
+		}
+		if err == nil {
+			s.joinSession(req, sessionId)
+		}
 	} else {
-		sessionId, err = getSessionIdFromCookie(req, s.config.Secret, scheduleId)
+		sessionId, err = s.setupSession(req, scheduleId)
 	}
 
 	if err != nil {
-		sessionId = newSessionId(s.config.Secret)
+		sessionId = newSessionId(config.Secret)
 	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
 
-	sessionId.SetCookie(w, s.config.Domain, scheduleId)
+	s.setSessionCookie(w, sessionId, scheduleId)
 	resp := structs.BookmarkSetupResponse{
 		SessionID: sessionId.String(),
 	}
@@ -92,77 +122,221 @@ func (s *server) setupSessionHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *server) setSelectionHandler(w http.ResponseWriter, req *http.Request) {
+	resp, status := s.setSelection(w, req)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+func (s *server) setSelection(w http.ResponseWriter, req *http.Request) (*structs.SessionBookmarksResponse, int) {
 	scheduleId := chi.URLParam(req, "scheduleId")
+	config := s.getConfig()
 
 	var reqBody structs.BookmarksRequest
 	if err := json.NewDecoder(req.Body).Decode(&reqBody); err != nil {
-		httpError(w, http.StatusUnprocessableEntity)
-		return
+		return nil, http.StatusUnprocessableEntity
 	}
 
-	sessionId, err := getSessionIdFromCookie(req, s.config.Secret, scheduleId)
+	sessionId, err := s.getSession(req, scheduleId)
 	if err != nil {
-		httpError(w, http.StatusUnauthorized)
-		return
+		return nil, http.StatusUnauthorized
 	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
 
-	validatedEvents, err := s.validator.ValidateEvents(scheduleId, reqBody.Events)
+	validatedEvents, err := s.validator.ValidateEvents(req.Context(), scheduleId, reqBody.Events)
 	if err == validator.ErrNoSchedule {
-		http.NotFound(w, req)
-		return
+		return nil, http.StatusNotFound
 	} else if err != nil {
-		log.Println(err)
-		httpError(w, http.StatusInternalServerError)
-		return
+		slog.ErrorContext(req.Context(), "error validating events", "error", err)
+		return nil, http.StatusInternalServerError
 	}
 
 	sel := selection.NewSelection(validatedEvents)
 
-	hash, err := s.db.SaveSelection(scheduleId, sel)
-	if err != nil {
-		panic(err)
+	var prev *selection.Selection
+	if len(config.GetWebhooks(scheduleId)) > 0 {
+		prev, _, err = s.db.GetSessionSelection(req.Context(), sessionId.Id, scheduleId)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "error getting session selection", "error", err)
+			return nil, http.StatusInternalServerError
+		}
 	}
 
-	date, err := s.db.SetSessionSelection(sessionId.Id, scheduleId, hash)
+	hash, date, err := s.db.SaveSessionSelection(req.Context(), sessionId.Id, scheduleId, sel)
 	if err != nil {
-		panic(err)
+		slog.ErrorContext(req.Context(), "error saving selection", "error", err)
+		return nil, http.StatusInternalServerError
 	}
 
-	sessionId.SetCookie(w, s.config.Domain, scheduleId)
+	go s.selectionSaved(context.WithoutCancel(req.Context()), scheduleId, prev, sel)
+
+	s.setSessionCookie(w, sessionId, scheduleId)
 
 	respBody := structs.SessionBookmarksResponse{
 		Id:     hash,
 		Date:   date,
 		Events: sel.GetEventIds(),
+		Code:   s.shareCode(req.Context(), scheduleId, sel.GetEventIds()),
 	}
-	jsonResponse(w, respBody)
+	return &respBody, http.StatusOK
 }
 
 func (s *server) getSessionSelectionHandler(w http.ResponseWriter, req *http.Request) {
+	resp, status := s.getSessionSelection(req)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+func (s *server) getSessionSelection(req *http.Request) (*structs.SessionBookmarksResponse, int) {
 	scheduleId := chi.URLParam(req, "scheduleId")
 
-	sessionId, err := getSessionIdFromCookie(req, s.config.Secret, scheduleId)
+	sessionId, err := s.getSession(req, scheduleId)
 	if err != nil {
-		jsonResponse(w, emptySelectionResponse)
-		return
+		return s.emptySelection, http.StatusOK
 	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
 
-	selections, date, err := s.db.GetSessionSelection(sessionId.Id, scheduleId)
+	selections, date, err := s.db.GetSessionSelection(req.Context(), sessionId.Id, scheduleId)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError)
-		return
+		slog.ErrorContext(req.Context(), "error getting session selection", "error", err)
+		return nil, http.StatusInternalServerError
 	}
 	if selections == nil {
-		jsonResponse(w, emptySelectionResponse)
-		return
+		return s.emptySelection, http.StatusOK
 	}
 
+	events := s.resolveAliases(req.Context(), scheduleId, selections)
 	respBody := &structs.SessionBookmarksResponse{
-		Id:     selections.Hash(),
+		Id:     selections.Id(),
 		Date:   date,
-		Events: selections.GetEventIds(),
+		Events: events,
+		Code:   s.shareCode(req.Context(), scheduleId, events),
 	}
-	jsonResponse(w, respBody)
+	return respBody, http.StatusOK
+}
+
+func (s *server) getRemovedEventsHandler(w http.ResponseWriter, req *http.Request) {
+	resp, _, status := s.getRemovedEvents(req, db.Page{})
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+// getRemovedEvents returns the page of the session's removed events, and the
+// cursor of the next page.
+func (s *server) getRemovedEvents(req *http.Request, p db.Page) (*structs.RemovedEventsResponse, string, int) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	config := s.getConfig()
+
+	if _, ok := config.ScheduleURLs[scheduleId]; !ok {
+		return nil, "", http.StatusNotFound
+	}
+
+	respBody := structs.RemovedEventsResponse{
+		Events: make([]structs.RemovedEvent, 0),
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		return &respBody, "", http.StatusOK
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	// make sure the latest feed has been compared
+	if _, err := s.validator.Events(req.Context(), scheduleId); err != nil {
+		slog.WarnContext(req.Context(), "error fetching events", "error", err)
+	}
+
+	removed, err := s.db.GetSessionRemovedEvents(req.Context(), sessionId.Id, scheduleId, storePage(p))
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting removed events", "error", err)
+		return nil, "", http.StatusInternalServerError
+	}
+	removed, next := nextPage(removed, db.RemovedEventKey, p)
+
+	for _, event := range removed {
+		respBody.Events = append(respBody.Events, structs.RemovedEvent{
+			Id:       event.EventId,
+			Title:    event.Title,
+			Start:    event.Start,
+			End:      event.End,
+			Location: event.Location,
+			Date:     event.Date,
+		})
+	}
+	return &respBody, next, http.StatusOK
+}
+
+func (s *server) getEventChangesHandler(w http.ResponseWriter, req *http.Request) {
+	resp, _, status := s.getEventChanges(req, db.Page{})
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+	jsonResponse(w, resp)
+}
+
+// getEventChanges returns the page of changes to the session's events, and
+// the cursor of the next page.
+func (s *server) getEventChanges(req *http.Request, p db.Page) (*structs.EventChangesResponse, string, int) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	config := s.getConfig()
+
+	if _, ok := config.ScheduleURLs[scheduleId]; !ok {
+		return nil, "", http.StatusNotFound
+	}
+
+	var since time.Time
+	if sinceStr := req.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			return nil, "", http.StatusBadRequest
+		}
+	}
+
+	respBody := structs.EventChangesResponse{
+		Changes: make([]structs.EventChange, 0),
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		return &respBody, "", http.StatusOK
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	// make sure the latest feed has been compared
+	if _, err := s.validator.Events(req.Context(), scheduleId); err != nil {
+		slog.WarnContext(req.Context(), "error fetching events", "error", err)
+	}
+
+	changes, err := s.db.GetSessionEventChanges(req.Context(), sessionId.Id, scheduleId, since, storePage(p))
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return nil, "", http.StatusBadRequest
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "error getting event changes", "error", err)
+		return nil, "", http.StatusInternalServerError
+	}
+	changes, next := nextPage(changes, db.EventChangeKey, p)
+
+	for _, change := range changes {
+		respBody.Changes = append(respBody.Changes, structs.EventChange{
+			EventId: change.EventId,
+			Title:   change.Title,
+			Kind:    change.Kind,
+			Old:     change.Old,
+			New:     change.New,
+			Date:    change.Date.Format(time.RFC3339Nano),
+		})
+	}
+	return &respBody, next, http.StatusOK
 }
 
 func (s *server) getEventSelectionCountsHandler(w http.ResponseWriter, req *http.Request) {
@@ -170,6 +344,7 @@ func (s *server) getEventSelectionCountsHandler(w http.ResponseWriter, req *http
 
 	res, err := s.getSelectionCounts(req.Context(), scheduleId)
 	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting selection counts", "error", err)
 		httpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -190,6 +365,7 @@ func (s *server) getEventSelectionCountsHTMLHandler(w http.ResponseWriter, req *
 
 	res, err := s.getSelectionCounts(req.Context(), scheduleId)
 	if err != nil {
+		slog.ErrorContext(req.Context(), "error getting selection counts", "error", err)
 		httpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -226,19 +402,80 @@ func (s *server) getEventSelectionCountsHTMLHandler(w http.ResponseWriter, req *
 	w.Write([]byte("</tbody></table></body></html>"))
 }
 
+// getSelectionCounts returns the public counts of a schedule, with the
+// schedule's count privacy settings applied.
 func (s *server) getSelectionCounts(ctx context.Context, scheduleId string) (map[string]int, error) {
-	if _, ok := s.config.ScheduleURLs[scheduleId]; !ok {
+	if _, ok := s.getConfig().ScheduleURLs[scheduleId]; !ok {
 		return nil, nil
 	}
 
 	res, err, _ := s.countCache.GetOrLoad(ctx, scheduleId, func(ctx context.Context, key string) (map[string]int, time.Duration, error) {
-		res, err := s.db.GetEventSelectionCounts(key)
+		res, err := s.db.GetEventSelectionCounts(ctx, key)
 		if err != nil {
 			return nil, 0, err
 		}
 
+		config := s.getConfig()
+		privacy := config.Schedules[key].Counts
+		var eventIds []string
+		if privacy.NoiseEpsilon > 0 {
+			// every event gets noise, not only bookmarked ones
+			eventIds, err = s.validator.EventIds(ctx, key)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		res = privacy.Apply([]byte(config.Secret), key, eventIds, res)
 		return res, 60 * time.Second, nil
 	})
 
 	return res, err
 }
+
+// clearCountCache discards every schedule's cached counts.
+func (s *server) clearCountCache() {
+	for _, scheduleId := range s.countCache.AppendKeys(nil) {
+		s.countCache.Delete(scheduleId)
+	}
+}
+
+// resolveAliases returns the selection's event IDs with renamed events
+// rewritten to their current IDs.
+func (s *server) resolveAliases(ctx context.Context, scheduleId string, sel *selection.Selection) []string {
+	resolved := s.validator.ResolveAliases(ctx, scheduleId, sel.GetEventIds())
+	return selection.NewSelection(resolved).GetEventIds()
+}
+
+func (s *server) getConfig() *config.Config {
+	return s.cfg.Load()
+}
+
+// reload applies a new config. The DB and secret cannot change while
+// running, so they are kept from the current config.
+func (s *server) reload(newConfig *config.Config) {
+	cur := s.getConfig()
+
+	updated := *newConfig
+	if updated.DBURL != cur.DBURL {
+		slog.Warn("db_url cannot be changed without a restart")
+		updated.DBURL = cur.DBURL
+	}
+	if updated.Secret != cur.Secret {
+		slog.Warn("secret cannot be changed without a restart")
+		updated.Secret = cur.Secret
+	}
+	if updated.FeedCacheDir != cur.FeedCacheDir {
+		slog.Warn("feed_cache_dir cannot be changed without a restart")
+		updated.FeedCacheDir = cur.FeedCacheDir
+	}
+
+	// the config is stored first, so a schedule's settings are there
+	// before its events can be fetched
+	s.cfg.Store(&updated)
+	s.validator.SetSchedules(updated.ScheduleURLs, updated.GetAliases())
+	s.validator.Prewarm(context.Background())
+
+	// count privacy settings may have changed
+	s.clearCountCache()
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bookmarks/internal/logging"
+	"bookmarks/internal/structs"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// getSession returns the request's session for a schedule. It changes
+// nothing, so that reads stay safe: with global sessions, the schedule's own
+// session is used until setupSession merges it into the global session.
+// Session IDs that were merged resolve to the session they were merged into.
+func (s *server) getSession(req *http.Request, scheduleId string) (sessionId, error) {
+	local, err := s.scheduleSession(req, scheduleId)
+	if err == nil || !s.getConfig().GlobalSessions {
+		return local, err
+	}
+	return s.globalSession(req)
+}
+
+// setupSession returns the request's session for a schedule when it is set
+// up. With global sessions, a schedule's session is merged into the global
+// session the first time both are seen. The caller sets the cookies.
+func (s *server) setupSession(req *http.Request, scheduleId string) (sessionId, error) {
+	ctx := req.Context()
+	local, localErr := s.scheduleSession(req, scheduleId)
+	if !s.getConfig().GlobalSessions {
+		return local, localErr
+	}
+
+	global, globalErr := s.globalSession(req)
+	switch {
+	case globalErr != nil && localErr != nil:
+		return sessionId{}, ErrInvalidSession
+	case globalErr != nil:
+		// the schedule's session becomes the global session
+		return local, nil
+	case localErr == nil && local.Id != global.Id:
+		if err := s.db.MergeSessions(ctx, local.Id, global.Id, scheduleId); err != nil {
+			slog.ErrorContext(ctx, "error merging session into global session", "error", err)
+			return local, nil
+		}
+		slog.InfoContext(ctx, "merged session into global session", "from", local, "to", global)
+	}
+
+	return global, nil
+}
+
+// scheduleSession returns the session of the schedule's cookie.
+func (s *server) scheduleSession(req *http.Request, scheduleId string) (sessionId, error) {
+	local, err := getSessionIdFromCookie(req, s.getConfig().Secret, scheduleId)
+	if err != nil {
+		return sessionId{}, err
+	}
+
+	local = s.resolveSessionLink(req.Context(), local, scheduleId)
+	return local, s.checkRevoked(req.Context(), local)
+}
+
+// globalSession returns the session of the global cookie.
+func (s *server) globalSession(req *http.Request) (sessionId, error) {
+	global, err := getGlobalSessionIdFromCookie(req, s.getConfig().Secret)
+	if err != nil {
+		return sessionId{}, err
+	}
+
+	global = s.resolveSessionLink(req.Context(), global, "")
+	return global, s.checkRevoked(req.Context(), global)
+}
+
+// joinSession makes a session ID given by the user, e.g. from another device,
+// the request's global session. The current global session is merged into
+// it.
+func (s *server) joinSession(req *http.Request, id sessionId) {
+	ctx := req.Context()
+	config := s.getConfig()
+	if !config.GlobalSessions {
+		return
+	}
+
+	global, err := s.globalSession(req)
+	if err != nil || global.Id == id.Id {
+		return
+	}
+
+	if err := s.db.MergeSessions(ctx, global.Id, id.Id, ""); err != nil {
+		slog.ErrorContext(ctx, "error merging global session", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "merged global session", "from", global, "to", id)
+}
+
+// setSessionCookie sets the schedule's session cookie, and the global session
+// cookie if global sessions are enabled.
+func (s *server) setSessionCookie(w http.ResponseWriter, id sessionId, scheduleId string) {
+	config := s.getConfig()
+	id.SetCookie(w, config.Domain, scheduleId)
+	if config.GlobalSessions {
+		id.SetGlobalCookie(w, config.Domain)
+	}
+}
+
+func (s *server) resolveSessionLink(ctx context.Context, id sessionId, scheduleId string) sessionId {
+	resolved, err := s.db.ResolveSessionLink(ctx, id.Id, scheduleId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error resolving session link", "error", err)
+		return id
+	}
+
+	if resolved == id.Id {
+		return id
+	}
+	return signSessionId(resolved, s.getConfig().Secret)
+}
+
+// checkRevoked returns ErrInvalidSession if the session was revoked. Errors
+// checking are logged, and the session is allowed.
+func (s *server) checkRevoked(ctx context.Context, id sessionId) error {
+	revoked, err := s.db.IsSessionRevoked(ctx, id.Id)
+	if err != nil {
+		slog.ErrorContext(ctx, "error checking session revocation", "error", err)
+		return nil
+	}
+
+	if revoked {
+		slog.InfoContext(ctx, "rejected revoked session", "session", id)
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+// getSessionIds returns every session ID of the request: the global session
+// and each schedule's session.
+func (s *server) getSessionIds(req *http.Request) []sessionId {
+	ctx := req.Context()
+	config := s.getConfig()
+	ids := make([]sessionId, 0)
+
+	if config.GlobalSessions {
+		if global, err := s.globalSession(req); err == nil {
+			ids = append(ids, global)
+		}
+	}
+
+	for _, cookie := range req.Cookies() {
+		scheduleId, ok := cutCookieName(cookie.Name)
+		if !ok {
+			continue
+		}
+
+		local, err := verifySessionId(cookie.Value, config.Secret)
+		if err != nil {
+			continue
+		}
+
+		local = s.resolveSessionLink(ctx, local, scheduleId)
+		if s.checkRevoked(ctx, local) != nil {
+			continue
+		}
+		if !slices.ContainsFunc(ids, func(id sessionId) bool { return id.Id == local.Id }) {
+			ids = append(ids, local)
+		}
+	}
+
+	return ids
+}
+
+func (s *server) getMySchedulesHandler(w http.ResponseWriter, req *http.Request) {
+	respBody := structs.MySchedulesResponse{
+		Schedules: make([]structs.SessionSchedule, 0),
+	}
+
+	ids := s.getSessionIds(req)
+	if len(ids) > 0 {
+		logging.AddAttrs(req.Context(), slog.Any("session", ids[0]))
+	}
+
+	for _, id := range ids {
+		schedules, err := s.db.GetSessionSchedules(req.Context(), id.Id)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "error getting session schedules", "error", err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		for _, schedule := range schedules {
+			// a schedule's own session may be older than the global session
+			if schedule.Count == 0 || slices.ContainsFunc(respBody.Schedules, func(cur structs.SessionSchedule) bool {
+				return cur.ScheduleId == schedule.ScheduleId
+			}) {
+				continue
+			}
+
+			respBody.Schedules = append(respBody.Schedules, structs.SessionSchedule{
+				ScheduleId: schedule.ScheduleId,
+				Date:       schedule.Date,
+				Count:      schedule.Count,
+			})
+		}
+	}
+
+	slices.SortStableFunc(respBody.Schedules, func(a structs.SessionSchedule, b structs.SessionSchedule) int {
+		aDate, _ := time.Parse(time.RFC3339Nano, a.Date)
+		bDate, _ := time.Parse(time.RFC3339Nano, b.Date)
+		return bDate.Compare(aDate)
+	})
+
+	jsonResponse(w, respBody)
+}
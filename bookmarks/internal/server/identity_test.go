@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/server"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/validator/validatortest"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+const OTHER_SCHEDULE_ID = "other"
+
+// scheduleCookieClient returns a client with only the schedule's session
+// cookie of another client, like a browser from before global sessions.
+func (s *testServer) scheduleCookieClient(t *testing.T, from *http.Client) *http.Client {
+	t.Helper()
+	serverURL, err := url.Parse(s.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newClient(t)
+	for _, cookie := range from.Jar.Cookies(serverURL) {
+		if cookie.Name == server.COOKIE_NAME+SCHEDULE_ID {
+			client.Jar.SetCookies(serverURL, []*http.Cookie{cookie})
+			return client
+		}
+	}
+	t.Fatal("expected a schedule session cookie")
+	return nil
+}
+
+func TestGlobalSessions(t *testing.T) {
+	other := validatortest.NewSource(t, map[string][]structs.Event{OTHER_SCHEDULE_ID: testEvents})
+	srv := newConfiguredServer(t, func(cfg *config.Config) {
+		cfg.GlobalSessions = true
+		maps.Copy(cfg.ScheduleURLs, other.URLs())
+	})
+
+	// a session from before global sessions, with only a schedule's cookie
+	old := newClient(t)
+	oldSessionId := srv.setup(t, old)
+	srv.setBookmarks(t, old, "e1", "e2")
+	old = srv.scheduleCookieClient(t, old)
+
+	// a browser that has the old session, and a global session from another
+	// schedule
+	client := srv.scheduleCookieClient(t, old)
+	var setupResp structs.BookmarkSetupResponse
+	if status := srv.do(t, client, "PUT", "/schedule/"+OTHER_SCHEDULE_ID+"/setup-bookmarks", nil, &setupResp); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	globalSessionId := setupResp.SessionID
+	if globalSessionId == oldSessionId {
+		t.Fatal("expected a new session for the other schedule")
+	}
+	// schedules are listed newest first
+	time.Sleep(10 * time.Millisecond)
+	if status := srv.do(t, client, "PUT", "/schedule/"+OTHER_SCHEDULE_ID+"/bookmarks", structs.BookmarksRequest{Events: []string{"e3"}}, nil); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+
+	// reads use the schedule's own session, without merging it or setting
+	// cookies
+	req, err := http.NewRequest("GET", srv.url+"/schedule/"+SCHEDULE_ID+"/bookmarks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(resp.Cookies()) != 0 {
+		t.Fatalf("expected 200 without cookies, got %d with %v", resp.StatusCode, resp.Cookies())
+	}
+	if sessionId := srv.setup(t, srv.scheduleCookieClient(t, old)); sessionId != oldSessionId {
+		t.Fatalf("expected the old session not to be merged by a read, got %s", sessionId)
+	}
+
+	// the schedule's session is merged into the global session
+	if sessionId := srv.setup(t, client); sessionId != globalSessionId {
+		t.Fatalf("expected the global session %s, got %s", globalSessionId, sessionId)
+	}
+	var bookmarks structs.SessionBookmarksResponse
+	if status := srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks", nil, &bookmarks); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if !slices.Equal(bookmarks.Events, []string{"e1", "e2"}) {
+		t.Fatalf("expected the merged bookmarks [e1 e2], got %v", bookmarks.Events)
+	}
+
+	var schedules structs.MySchedulesResponse
+	if status := srv.do(t, client, "GET", "/me/schedules", nil, &schedules); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(schedules.Schedules) != 2 {
+		t.Fatalf("expected 2 schedules, got %+v", schedules.Schedules)
+	}
+	for i, expected := range []structs.SessionSchedule{
+		{ScheduleId: OTHER_SCHEDULE_ID, Count: 1},
+		{ScheduleId: SCHEDULE_ID, Count: 2},
+	} {
+		schedule := schedules.Schedules[i]
+		if schedule.ScheduleId != expected.ScheduleId || schedule.Count != expected.Count {
+			t.Fatalf("expected %s with %d bookmarks, got %+v", expected.ScheduleId, expected.Count, schedule)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, schedule.Date); err != nil {
+			t.Fatalf("expected a date for %s, got %q", schedule.ScheduleId, schedule.Date)
+		}
+	}
+
+	// the old cookie resolves to the global session it was merged into
+	if sessionId := srv.setup(t, old); sessionId != globalSessionId {
+		t.Fatalf("expected the old session to resolve to %s, got %s", globalSessionId, sessionId)
+	}
+	srv.setBookmarks(t, old, "e3")
+	if status := srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks", nil, &bookmarks); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if !slices.Equal(bookmarks.Events, []string{"e3"}) {
+		t.Fatalf("expected the bookmarks saved with the old cookie, got %v", bookmarks.Events)
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const MAINTENANCE_INTERVAL = time.Hour
+const WEBHOOK_LOG_RETENTION = 14 * 24 * time.Hour
+
+// runMaintenance prunes old records every MAINTENANCE_INTERVAL until the
+// context is done.
+func (s *server) runMaintenance(ctx context.Context) {
+	ticker := time.NewTicker(MAINTENANCE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.db.PrunePushSent(ctx, now.Add(-PUSH_SENT_RETENTION)); err != nil {
+				slog.ErrorContext(ctx, "error pruning sent notifications", "error", err)
+			}
+			if err := s.db.PruneWebhookDeliveries(ctx, now.Add(-WEBHOOK_LOG_RETENTION)); err != nil {
+				slog.ErrorContext(ctx, "error pruning webhook deliveries", "error", err)
+			}
+			if retention := s.getConfig().HistoryRetention; retention > 0 {
+				if err := s.db.PruneSessionHistory(ctx, now.Add(-retention)); err != nil {
+					slog.ErrorContext(ctx, "error pruning selection history", "error", err)
+				}
+			}
+		}
+	}
+}
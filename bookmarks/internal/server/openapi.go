@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bookmarks/internal/structs"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// apiOperation documents a route for the OpenAPI description. Path is the
+// route's chi pattern; its parameters are documented as strings.
+type apiOperation struct {
+	Method     string
+	Path       string
+	Summary    string
+	Admin      bool
+	Deprecated bool
+	Query      map[string]string
+	Request    any
+	Responses  []apiResponse
+}
+
+// apiResponse is a possible response of an operation. Body is a value of the
+// response's JSON type, or nil if it is not JSON, in which case ContentType
+// is used if set.
+type apiResponse struct {
+	Status      int
+	Body        any
+	ContentType string
+}
+
+func okResponse(body any) apiResponse {
+	return apiResponse{Status: http.StatusOK, Body: body}
+}
+
+// errorResponses are v2 errors, with an ErrorResponse body.
+func errorResponses(codes ...int) []apiResponse {
+	responses := make([]apiResponse, 0, len(codes))
+	for _, code := range codes {
+		responses = append(responses, apiResponse{Status: code, Body: structs.ErrorResponse{}})
+	}
+	return responses
+}
+
+func statusResponses(codes ...int) []apiResponse {
+	responses := make([]apiResponse, 0, len(codes))
+	for _, code := range codes {
+		responses = append(responses, apiResponse{Status: code})
+	}
+	return responses
+}
+
+// apiOperations are the documented routes. The contract tests check that
+// they match the routes registered in NewHandler. Admin routes can also
+// respond 401, or 404 if no admin token is configured.
+var apiOperations = []apiOperation{
+	{
+		Method:  "GET",
+		Path:    "/openapi.json",
+		Summary: "This description of the API.",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, ContentType: "application/json"},
+		},
+	},
+	{
+		Method:    "GET",
+		Path:      "/me/schedules",
+		Summary:   "Every schedule the caller has bookmarked events in, most recent first.",
+		Responses: append([]apiResponse{okResponse(structs.MySchedulesResponse{})}, statusResponses(http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/me/export",
+		Summary:   "Everything stored about the caller's sessions.",
+		Responses: append([]apiResponse{okResponse(structs.ExportResponse{})}, statusResponses(http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "DELETE",
+		Path:      "/me/",
+		Summary:   "Erases the caller's sessions and clears their cookies.",
+		Responses: statusResponses(http.StatusNoContent, http.StatusInternalServerError),
+	},
+	{
+		Method:    "GET",
+		Path:      "/admin/sessions/{sessionId}/export",
+		Summary:   "The data of a session, as returned by GET /me/export.",
+		Admin:     true,
+		Responses: append([]apiResponse{okResponse(structs.ExportResponse{})}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "DELETE",
+		Path:      "/admin/sessions/{sessionId}",
+		Summary:   "Erases a session.",
+		Admin:     true,
+		Responses: statusResponses(http.StatusNoContent, http.StatusInternalServerError),
+	},
+	{
+		Method:    "GET",
+		Path:      "/admin/schedule/{scheduleId}/counts",
+		Summary:   "The exact counts of a schedule, without its count privacy settings applied, and the attendance of the events with check-ins.",
+		Admin:     true,
+		Responses: append([]apiResponse{okResponse(structs.AdminCountsResponse{})}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/admin/backup",
+		Summary: "A copy of the database file.",
+		Admin:   true,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, ContentType: "application/vnd.sqlite3"},
+		}, statusResponses(http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "PUT",
+		Path:       "/schedule/{scheduleId}/setup-bookmarks",
+		Summary:    "Sets up the session cookie, optionally continuing an existing session.",
+		Deprecated: true,
+		Request:    structs.BookmarkSetupRequest{},
+		Responses:  append([]apiResponse{okResponse(structs.BookmarkSetupResponse{})}, statusResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/bookmarks/",
+		Summary:    "The session's bookmarked events.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.SessionBookmarksResponse{})}, statusResponses(http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "PUT",
+		Path:       "/schedule/{scheduleId}/bookmarks/",
+		Summary:    "Replaces the session's bookmarked events.",
+		Deprecated: true,
+		Request:    structs.BookmarksRequest{},
+		Responses:  append([]apiResponse{okResponse(structs.SessionBookmarksResponse{})}, statusResponses(http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/bookmarks/removed",
+		Summary:    "Bookmarked events that have since been removed from the schedule.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.RemovedEventsResponse{})}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/bookmarks/changes",
+		Summary:    "Changes to bookmarked events.",
+		Deprecated: true,
+		Query: map[string]string{
+			"since": "Only return changes after this RFC 3339 time.",
+		},
+		Responses: append([]apiResponse{okResponse(structs.EventChangesResponse{})}, statusResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/bookmarks/decode/{code}",
+		Summary:    "The events of a share code.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.BookmarksResponse{})}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/bookmarks/{hash}",
+		Summary:    "A shared selection of bookmarked events.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.BookmarksResponse{})}, statusResponses(http.StatusNotFound)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/push/key",
+		Summary:    "The VAPID public key. Not found if push notifications are disabled.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.PushKeyResponse{})}, statusResponses(http.StatusNotFound)...),
+	},
+	{
+		Method:     "PUT",
+		Path:       "/schedule/{scheduleId}/push/",
+		Summary:    "Registers the session's push subscription.",
+		Deprecated: true,
+		Request:    structs.PushSubscriptionRequest{},
+		Responses:  append([]apiResponse{okResponse(structs.PushSubscriptionResponse{})}, statusResponses(http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:     "DELETE",
+		Path:       "/schedule/{scheduleId}/push/",
+		Summary:    "Removes the session's push subscription with the given endpoint, or all of them if the body is empty.",
+		Deprecated: true,
+		Request:    structs.PushUnsubscribeRequest{},
+		Responses:  statusResponses(http.StatusNoContent, http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnprocessableEntity, http.StatusInternalServerError),
+	},
+	{
+		Method:     "GET",
+		Path:       "/schedule/{scheduleId}/counts",
+		Summary:    "The number of sessions that bookmarked each event.",
+		Deprecated: true,
+		Responses:  append([]apiResponse{okResponse(structs.EventSelectionCountsResponse{})}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/schedule/{scheduleId}/counts.html",
+		Summary: "The number of sessions that bookmarked each event, as an HTML table.",
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, ContentType: "text/html"},
+		}, statusResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "PUT",
+		Path:      "/v2/schedule/{scheduleId}/setup-bookmarks",
+		Summary:   "Sets up the session cookie, optionally continuing an existing session.",
+		Request:   structs.BookmarkSetupRequest{},
+		Responses: append([]apiResponse{okResponse(structs.BookmarkSetupResponse{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/bookmarks/",
+		Summary:   "The session's bookmarked events.",
+		Query:     expandQuery,
+		Responses: append([]apiResponse{okResponse(structs.SelectionResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "PUT",
+		Path:      "/v2/schedule/{scheduleId}/bookmarks/",
+		Summary:   "Replaces the session's bookmarked events.",
+		Query:     expandQuery,
+		Request:   structs.BookmarksRequest{},
+		Responses: append([]apiResponse{okResponse(structs.SelectionResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/bookmarks/removed",
+		Summary:   "Bookmarked events that have since been removed from the schedule, by start time.",
+		Query:     pageQuery,
+		Responses: append([]apiResponse{okResponse(structs.RemovedEventsResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/v2/schedule/{scheduleId}/bookmarks/changes",
+		Summary: "Changes to bookmarked events, oldest first.",
+		Query: map[string]string{
+			"since":  "Only return changes after this RFC 3339 time.",
+			"limit":  pageQuery["limit"],
+			"cursor": pageQuery["cursor"],
+		},
+		Responses: append([]apiResponse{okResponse(structs.EventChangesResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/bookmarks/decode/{code}",
+		Summary:   "The events of a share code.",
+		Query:     expandQuery,
+		Responses: append([]apiResponse{okResponse(structs.SelectionResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/bookmarks/{hash}",
+		Summary:   "A shared selection of bookmarked events.",
+		Query:     expandQuery,
+		Responses: append([]apiResponse{okResponse(structs.SelectionResponseV2{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/push/key",
+		Summary:   "The VAPID public key. Not found if push notifications are disabled.",
+		Responses: append([]apiResponse{okResponse(structs.PushKeyResponse{})}, errorResponses(http.StatusNotFound)...),
+	},
+	{
+		Method:    "PUT",
+		Path:      "/v2/schedule/{scheduleId}/push/",
+		Summary:   "Registers the session's push subscription.",
+		Request:   structs.PushSubscriptionRequest{},
+		Responses: append([]apiResponse{okResponse(structs.PushSubscriptionResponse{})}, errorResponses(http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "DELETE",
+		Path:      "/v2/schedule/{scheduleId}/push/",
+		Summary:   "Removes the session's push subscription with the given endpoint, or all of them if the body is empty.",
+		Request:   structs.PushUnsubscribeRequest{},
+		Responses: append(statusResponses(http.StatusNoContent), errorResponses(http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/attendance/",
+		Summary:   "The events the session checked in to, in the order it checked in. Only available under /v2.",
+		Responses: append([]apiResponse{okResponse(structs.AttendanceResponse{})}, errorResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "PUT",
+		Path:      "/v2/schedule/{scheduleId}/attendance/{eventId}",
+		Summary:   "Checks the session in to a bookmarked event. Only available under /v2.",
+		Responses: append(statusResponses(http.StatusNoContent), errorResponses(http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "DELETE",
+		Path:      "/v2/schedule/{scheduleId}/attendance/{eventId}",
+		Summary:   "Cancels the session's check-in to an event. Only available under /v2.",
+		Responses: append(statusResponses(http.StatusNoContent), errorResponses(http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		Method:    "GET",
+		Path:      "/v2/schedule/{scheduleId}/counts",
+		Summary:   "The number of sessions that bookmarked each event.",
+		Responses: append([]apiResponse{okResponse(structs.EventSelectionCountsResponse{})}, errorResponses(http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+}
+
+var expandQuery = map[string]string{
+	"expand": "events: include eventDetails.",
+}
+
+var pageQuery = map[string]string{
+	"limit":  "The page size, at most " + strconv.Itoa(MAX_PAGE_SIZE) + ". Defaults to " + strconv.Itoa(DEFAULT_PAGE_SIZE) + ".",
+	"cursor": "The nextCursor of the previous page.",
+}
+
+var pathParamRegex = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPIPath returns the OpenAPI path of a chi route pattern. Subrouters'
+// index routes are documented without the trailing slash, which chi accepts
+// too.
+func openAPIPath(pattern string) string {
+	if len(pattern) > 1 {
+		return strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+// openAPIDocument builds the OpenAPI description of apiOperations, with
+// schemas derived from the request and response types.
+func openAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	for _, op := range apiOperations {
+		path := openAPIPath(op.Path)
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+
+		params := []any{}
+		for _, match := range pathParamRegex.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]any{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, name := range slices.Sorted(maps.Keys(op.Query)) {
+			params = append(params, map[string]any{
+				"name": name, "in": "query", "description": op.Query[name],
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		responses := map[string]any{}
+		opResponses := op.Responses
+		if op.Admin {
+			opResponses = append(statusResponses(http.StatusUnauthorized, http.StatusNotFound), opResponses...)
+		}
+		for _, resp := range opResponses {
+			respObj := map[string]any{"description": http.StatusText(resp.Status)}
+			if resp.Body != nil {
+				respObj["content"] = map[string]any{
+					"application/json": map[string]any{"schema": typeSchema(reflect.TypeOf(resp.Body), schemas)},
+				}
+			} else if resp.ContentType != "" {
+				respObj["content"] = map[string]any{resp.ContentType: map[string]any{}}
+			}
+			responses[strconv.Itoa(resp.Status)] = respObj
+		}
+
+		opObj := map[string]any{
+			"summary":   op.Summary,
+			"responses": responses,
+		}
+		if len(params) > 0 {
+			opObj["parameters"] = params
+		}
+		if op.Request != nil {
+			opObj["requestBody"] = map[string]any{
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": typeSchema(reflect.TypeOf(op.Request), schemas)},
+				},
+			}
+		}
+		if op.Deprecated {
+			opObj["deprecated"] = true
+		}
+		if op.Admin {
+			opObj["security"] = []any{map[string]any{"admin": []any{}}}
+		} else {
+			opObj["security"] = []any{map[string]any{"session": []any{}}, map[string]any{}}
+		}
+		item[strings.ToLower(op.Method)] = opObj
+	}
+
+	return map[string]any{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]any{
+			"title":   "Bookmarks",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"admin": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The admin_token. The admin routes are not found if it is not configured.",
+				},
+				"session": map[string]any{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        GLOBAL_COOKIE_NAME,
+					"description": "The signed session cookie, set by PUT /schedule/{scheduleId}/setup-bookmarks. Each schedule has its own cookie too.",
+				},
+			},
+		},
+	}
+}
+
+// typeSchema returns the schema of a JSON-encoded type. Named structs are
+// added to schemas and referenced.
+func typeSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// placeholder for recursive types
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	panic("no schema for type " + t.String())
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = typeSchema(field.Type, schemas)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var openAPIJSON = sync.OnceValue(func() []byte {
+	data, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		panic(err)
+	}
+	return data
+})
+
+func (s *server) openAPIHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(openAPIJSON())
+}
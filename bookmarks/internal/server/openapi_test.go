@@ -0,0 +1,301 @@
+package server_test
+
+import (
+	"bookmarks/internal/structs"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func loadOpenAPI(t *testing.T, url string) map[string]any {
+	t.Helper()
+	resp, err := http.Get(url + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var api map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&api); err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+// apiPath returns the documented path of a chi route pattern.
+func apiPath(pattern string) string {
+	if len(pattern) > 1 {
+		return strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+func (s *testServer) operation(method string, pattern string) map[string]any {
+	paths, _ := s.api["paths"].(map[string]any)
+	item, _ := paths[apiPath(pattern)].(map[string]any)
+	op, _ := item[strings.ToLower(method)].(map[string]any)
+	return op
+}
+
+// checkContract checks that a response's status is documented for its
+// route, and that its body matches the documented schema.
+func (s *testServer) checkContract(t *testing.T, method string, path string, resp *http.Response, body []byte) {
+	t.Helper()
+
+	urlPath, _, _ := strings.Cut(path, "?")
+	pattern := s.routes.Find(chi.NewRouteContext(), method, urlPath)
+	if pattern == "" {
+		return
+	}
+	s.called[method+" "+apiPath(pattern)] = true
+
+	op := s.operation(method, pattern)
+	if op == nil {
+		t.Errorf("%s %s is not documented", method, pattern)
+		return
+	}
+
+	responses, _ := op["responses"].(map[string]any)
+	respObj, ok := responses[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		t.Errorf("%s %s: status %d is not documented", method, pattern, resp.StatusCode)
+		return
+	}
+
+	content, _ := respObj["content"].(map[string]any)
+	if len(content) == 0 {
+		if resp.StatusCode < 400 && len(body) > 0 {
+			t.Errorf("%s %s: status %d has an undocumented body", method, pattern, resp.StatusCode)
+		}
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		t.Errorf("%s %s: content type %q is not documented", method, pattern, mediaType)
+		return
+	}
+
+	schema, ok := media["schema"].(map[string]any)
+	if !ok {
+		return
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		t.Errorf("%s %s: invalid JSON: %s", method, pattern, err)
+		return
+	}
+	if err := validateSchema(s.api, schema, value, "body"); err != nil {
+		t.Errorf("%s %s: %s", method, pattern, err)
+	}
+}
+
+// validateSchema checks a decoded JSON value against the subset of schemas
+// used in the description. Undocumented properties are errors.
+func validateSchema(api map[string]any, schema map[string]any, value any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		components, _ := api["components"].(map[string]any)
+		schemas, _ := components["schemas"].(map[string]any)
+		resolved, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return validateSchema(api, resolved, value, at)
+	}
+
+	var ok bool
+	switch schema["type"] {
+	case "object":
+		var obj map[string]any
+		if obj, ok = value.(map[string]any); !ok {
+			break
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, exists := obj[name.(string)]; !exists {
+				return fmt.Errorf("%s: missing %s", at, name)
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, propValue := range obj {
+			propSchema, exists := properties[name].(map[string]any)
+			if !exists {
+				propSchema = additional
+			}
+			if propSchema == nil {
+				return fmt.Errorf("%s: undocumented property %s", at, name)
+			}
+			if err := validateSchema(api, propSchema, propValue, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		var items []any
+		if items, ok = value.([]any); !ok {
+			break
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			if err := validateSchema(api, itemSchema, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		_, ok = value.(string)
+	case "boolean":
+		_, ok = value.(bool)
+	case "number":
+		_, ok = value.(float64)
+	case "integer":
+		var n float64
+		n, ok = value.(float64)
+		ok = ok && n == math.Trunc(n)
+	default:
+		return fmt.Errorf("%s: unknown schema type %v", at, schema["type"])
+	}
+
+	if !ok {
+		return fmt.Errorf("%s: expected %s, got %v", at, schema["type"], value)
+	}
+	return nil
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	srv := newTestServer(t)
+
+	var routes []string
+	err := chi.Walk(srv.routes, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+apiPath(route))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var documented []string
+	for path, item := range srv.api["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	slices.Sort(routes)
+	slices.Sort(documented)
+	if !slices.Equal(routes, documented) {
+		t.Fatalf("expected the documented routes %v to be the registered routes %v", documented, routes)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv := newTestServer(t)
+	client := newClient(t)
+	admin := newAdminClient()
+
+	srv.do(t, client, "GET", "/openapi.json", nil, nil)
+
+	for _, prefix := range []string{"", "/v2"} {
+		schedule := prefix + "/schedule/" + SCHEDULE_ID
+		srv.do(t, client, "PUT", schedule+"/setup-bookmarks", "invalid", nil)
+		srv.do(t, client, "PUT", schedule+"/setup-bookmarks", nil, nil)
+
+		var saved structs.SelectionResponseV2
+		srv.do(t, client, "PUT", schedule+"/bookmarks?expand=events", structs.BookmarksRequest{Events: []string{"e1", "e2"}}, &saved)
+		srv.do(t, client, "PUT", schedule+"/bookmarks", "invalid", nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks?expand=events", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks?expand=unknown", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/"+saved.Id+"?expand=events", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/unknown", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/decode/"+saved.Code+"?expand=events", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/decode/invalid", nil, nil)
+
+		srv.addChanges(t, "e1", "e2")
+		srv.do(t, client, "GET", schedule+"/bookmarks/changes?limit=1", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/changes?since="+time.Now().Add(-time.Hour).Format(time.RFC3339), nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/changes?since=invalid", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/removed?limit=1", nil, nil)
+		srv.do(t, client, "GET", schedule+"/bookmarks/removed?cursor=invalid!", nil, nil)
+		srv.do(t, client, "GET", prefix+"/schedule/unknown/bookmarks/removed", nil, nil)
+
+		srv.do(t, client, "GET", schedule+"/push/key", nil, nil)
+		srv.do(t, client, "PUT", schedule+"/push", structs.PushSubscriptionRequest{}, nil)
+		srv.do(t, client, "DELETE", schedule+"/push", nil, nil)
+		srv.do(t, newClient(t), "DELETE", schedule+"/push", nil, nil)
+
+		srv.do(t, client, "GET", schedule+"/counts", nil, nil)
+		srv.do(t, client, "GET", prefix+"/schedule/unknown/counts", nil, nil)
+	}
+
+	attendance := "/v2/schedule/" + SCHEDULE_ID + "/attendance"
+	srv.do(t, client, "PUT", attendance+"/e1", nil, nil)
+	srv.do(t, client, "PUT", attendance+"/unknown", nil, nil)
+	srv.do(t, newClient(t), "PUT", attendance+"/e1", nil, nil)
+	srv.do(t, client, "PUT", "/v2/schedule/unknown/attendance/e1", nil, nil)
+	srv.do(t, client, "GET", attendance, nil, nil)
+	srv.do(t, client, "GET", "/v2/schedule/unknown/attendance", nil, nil)
+	srv.do(t, client, "DELETE", attendance+"/e1", nil, nil)
+	srv.do(t, newClient(t), "DELETE", attendance+"/e1", nil, nil)
+	srv.do(t, client, "DELETE", "/v2/schedule/unknown/attendance/e1", nil, nil)
+
+	srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/counts.html", nil, nil)
+	srv.do(t, client, "GET", "/me/schedules", nil, nil)
+	srv.do(t, client, "GET", "/me/export", nil, nil)
+
+	sessionId := srv.setup(t, client)
+	id, _, _ := strings.Cut(sessionId, ".")
+	srv.do(t, client, "GET", "/admin/sessions/"+id+"/export", nil, nil)
+	srv.do(t, admin, "GET", "/admin/sessions/"+id+"/export", nil, nil)
+	srv.do(t, admin, "GET", "/admin/sessions/unknown/export", nil, nil)
+	srv.do(t, admin, "GET", "/admin/schedule/"+SCHEDULE_ID+"/counts", nil, nil)
+	srv.do(t, admin, "GET", "/admin/schedule/unknown/counts", nil, nil)
+	srv.do(t, admin, "GET", "/admin/backup", nil, nil)
+	srv.do(t, admin, "DELETE", "/admin/sessions/"+id, nil, nil)
+	srv.do(t, client, "DELETE", "/me", nil, nil)
+
+	for path, item := range srv.api["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			if key := strings.ToUpper(method) + " " + path; !srv.called[key] {
+				t.Errorf("%s was not checked", key)
+			}
+		}
+	}
+}
+
+func TestContract(t *testing.T) {
+	srv := newTestServer(t)
+	op := srv.operation("GET", "/schedule/{scheduleId}/bookmarks/{hash}")
+	schema := op["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+
+	cases := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"id": "a", "events": ["e1"], "code": "c"}`, true},
+		{`{"id": "a", "events": []}`, true},
+		{`{"id": "a"}`, false},
+		{`{"id": "a", "events": null}`, false},
+		{`{"id": "a", "events": [1]}`, false},
+		{`{"id": "a", "events": [], "extra": true}`, false},
+		{`[]`, false},
+	}
+	for _, c := range cases {
+		var value any
+		if err := json.Unmarshal([]byte(c.body), &value); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateSchema(srv.api, schema, value, "body"); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid to be %t, got %v", c.body, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/logging"
+	"bookmarks/internal/push"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/validator"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const REMINDER_INTERVAL = time.Minute
+const MAX_REMINDER_MINUTES = 24 * 60
+const PUSH_SENT_RETENTION = 30 * 24 * time.Hour
+const CHANGE_ALERT_TTL = 24 * time.Hour
+
+const PUSH_REMINDER = "reminder"
+const PUSH_CHANGE = "change"
+
+// localTimeLayouts are the accepted formats of event times without an offset.
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+func (s *server) getPushKeyHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	config := s.getConfig()
+
+	if _, ok := config.ScheduleURLs[scheduleId]; !ok || config.PushKeys() == nil {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	jsonResponse(w, structs.PushKeyResponse{PublicKey: config.VAPIDPublicKey})
+}
+
+func (s *server) setPushSubscriptionHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+	config := s.getConfig()
+
+	if _, ok := config.ScheduleURLs[scheduleId]; !ok || config.PushKeys() == nil {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	var reqBody structs.PushSubscriptionRequest
+	if err := json.NewDecoder(req.Body).Decode(&reqBody); err != nil {
+		httpError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	sub := push.Subscription{
+		Endpoint: reqBody.Subscription.Endpoint,
+		P256dh:   reqBody.Subscription.Keys.P256dh,
+		Auth:     reqBody.Subscription.Keys.Auth,
+	}
+	if err := push.CheckSubscription(sub, s.getConfig().PushHosts); err != nil {
+		slog.InfoContext(req.Context(), "rejected push subscription", "error", err)
+		httpError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if reqBody.ReminderMinutes < 0 || reqBody.ReminderMinutes > MAX_REMINDER_MINUTES {
+		httpError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = s.db.SetPushSubscription(req.Context(), scheduleId, db.PushSubscription{
+		SessionId:       sessionId.Id,
+		Endpoint:        sub.Endpoint,
+		P256dh:          sub.P256dh,
+		Auth:            sub.Auth,
+		ReminderMinutes: reqBody.ReminderMinutes,
+		ChangeAlerts:    reqBody.ChangeAlerts,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "error saving push subscription", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, structs.PushSubscriptionResponse{
+		Endpoint:        sub.Endpoint,
+		ReminderMinutes: reqBody.ReminderMinutes,
+		ChangeAlerts:    reqBody.ChangeAlerts,
+	})
+}
+
+func (s *server) deletePushSubscriptionHandler(w http.ResponseWriter, req *http.Request) {
+	scheduleId := chi.URLParam(req, "scheduleId")
+
+	var reqBody structs.PushUnsubscribeRequest
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &reqBody); err != nil {
+			httpError(w, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	sessionId, err := s.getSession(req, scheduleId)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+	logging.AddAttrs(req.Context(), slog.Any("session", sessionId))
+
+	if err := s.db.DeletePushSubscription(req.Context(), scheduleId, sessionId.Id, reqBody.Endpoint); err != nil {
+		slog.ErrorContext(req.Context(), "error deleting push subscription", "error", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushSender returns a sender using the current VAPID keys, or nil if push
+// notifications are not configured.
+func (s *server) pushSender() *push.Sender {
+	config := s.getConfig()
+	if config.PushKeys() == nil {
+		return nil
+	}
+
+	return &push.Sender{
+		Keys:    config.PushKeys(),
+		Subject: config.VAPIDSubject,
+		Hosts:   config.PushHosts,
+		Client:  s.pushClient,
+	}
+}
+
+// runReminders sends reminders for bookmarked events every
+// reminderInterval until the context is done.
+func (s *server) runReminders(ctx context.Context) {
+	ticker := time.NewTicker(s.reminderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sendReminders(ctx, now)
+		}
+	}
+}
+
+func (s *server) sendReminders(ctx context.Context, now time.Time) {
+	sender := s.pushSender()
+	if sender == nil {
+		return
+	}
+
+	for scheduleId := range s.getConfig().ScheduleURLs {
+		s.sendScheduleReminders(ctx, sender, scheduleId, now)
+	}
+}
+
+// sendScheduleReminders notifies subscribers of their bookmarked events that
+// start within their reminder time.
+func (s *server) sendScheduleReminders(ctx context.Context, sender *push.Sender, scheduleId string, now time.Time) {
+	subs, err := s.db.GetPushSubscriptions(ctx, scheduleId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error getting push subscriptions", "schedule", scheduleId, "error", err)
+		return
+	}
+
+	hasReminders := false
+	for _, sub := range subs {
+		hasReminders = hasReminders || sub.ReminderMinutes > 0
+	}
+	if !hasReminders {
+		return
+	}
+
+	events, err := s.validator.Events(ctx, scheduleId)
+	if err != nil {
+		slog.WarnContext(ctx, "error fetching events for reminders", "schedule", scheduleId, "error", err)
+		return
+	}
+
+	loc := s.scheduleLocation(ctx, scheduleId)
+	starts := make(map[string]time.Time, len(events))
+	eventsById := make(map[string]structs.Event, len(events))
+	for _, event := range events {
+		start, err := parseEventTime(event.Start, loc)
+		if err != nil {
+			continue
+		}
+		starts[event.Id] = start
+		eventsById[event.Id] = event
+	}
+
+	for _, sub := range subs {
+		if sub.ReminderMinutes == 0 {
+			continue
+		}
+
+		remindBefore := time.Duration(sub.ReminderMinutes) * time.Minute
+		for _, eventId := range s.validator.ResolveAliases(ctx, scheduleId, sub.Events) {
+			start, ok := starts[eventId]
+			if !ok || !start.After(now) || start.Sub(now) > remindBefore {
+				continue
+			}
+
+			event := eventsById[eventId]
+			s.sendPush(ctx, sender, scheduleId, sub, PUSH_REMINDER, event.Start, structs.PushMessage{
+				Type:       PUSH_REMINDER,
+				ScheduleId: scheduleId,
+				EventId:    eventId,
+				Title:      event.Title,
+				Start:      event.Start,
+				Location:   event.Location,
+			}, start.Sub(now))
+		}
+	}
+}
+
+// sendChangeAlerts notifies subscribers of time, location and removal changes
+// to their bookmarked events.
+func (s *server) sendChangeAlerts(ctx context.Context, scheduleId string, changes []validator.Change) {
+	sender := s.pushSender()
+	if sender == nil {
+		return
+	}
+
+	subs, err := s.db.GetPushSubscriptions(ctx, scheduleId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error getting push subscriptions", "schedule", scheduleId, "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, sub := range subs {
+		if !sub.ChangeAlerts {
+			continue
+		}
+
+		bookmarked := make(map[string]bool, len(sub.Events))
+		for _, eventId := range s.validator.ResolveAliases(ctx, scheduleId, sub.Events) {
+			bookmarked[eventId] = true
+		}
+
+		for _, change := range changes {
+			switch change.Kind {
+			case validator.CHANGE_TIME, validator.CHANGE_LOCATION, validator.CHANGE_REMOVED:
+			default:
+				continue
+			}
+			if !bookmarked[change.EventId] {
+				continue
+			}
+
+			entry := newEventChange(change, now)
+			value := entry.New
+			if change.Kind == validator.CHANGE_REMOVED {
+				// removals have no new value, so they are told apart by
+				// date, in case the event is restored and removed again
+				value = entry.Date.UTC().Format(db.DATE_FORMAT)
+			}
+			s.sendPush(ctx, sender, scheduleId, sub, PUSH_CHANGE+"-"+entry.Kind, value, structs.PushMessage{
+				Type:       PUSH_CHANGE,
+				ScheduleId: scheduleId,
+				EventId:    entry.EventId,
+				Title:      entry.Title,
+				Kind:       entry.Kind,
+				Old:        entry.Old,
+				New:        entry.New,
+			}, CHANGE_ALERT_TTL)
+		}
+	}
+}
+
+// sendPush sends a notification unless it was already sent to the endpoint.
+// It is marked as sent first, so that it is sent once, and unmarked if the
+// push service fails, so that it is retried. Subscriptions the push service
+// reports as expired are removed.
+func (s *server) sendPush(ctx context.Context, sender *push.Sender, scheduleId string, sub db.PushSubscription, kind string, value string, msg structs.PushMessage, ttl time.Duration) {
+	first, err := s.db.MarkPushSent(ctx, scheduleId, sub.Endpoint, msg.EventId, kind, value)
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording notification", "schedule", scheduleId, "error", err)
+		return
+	} else if !first {
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+
+	err = sender.Send(ctx, push.Subscription{Endpoint: sub.Endpoint, P256dh: sub.P256dh, Auth: sub.Auth}, payload, ttl)
+	if errors.Is(err, push.ErrGone) {
+		slog.InfoContext(ctx, "removing expired push subscription", "schedule", scheduleId)
+		if err := s.db.DeletePushSubscription(ctx, scheduleId, sub.SessionId, sub.Endpoint); err != nil {
+			slog.ErrorContext(ctx, "error deleting push subscription", "schedule", scheduleId, "error", err)
+		}
+	} else if err != nil {
+		slog.WarnContext(ctx, "error sending notification", "schedule", scheduleId, "event", msg.EventId, "error", err)
+		// try again next time
+		if err := s.db.UnmarkPushSent(ctx, scheduleId, sub.Endpoint, msg.EventId, kind, value); err != nil {
+			slog.ErrorContext(ctx, "error unmarking notification", "schedule", scheduleId, "error", err)
+		}
+	} else {
+		slog.DebugContext(ctx, "sent notification", "schedule", scheduleId, "event", msg.EventId, "kind", kind)
+	}
+}
+
+// scheduleLocation returns the time zone of event times without an offset:
+// the configured time_zone, else the feed's, else UTC.
+func (s *server) scheduleLocation(ctx context.Context, scheduleId string) *time.Location {
+	name := s.getConfig().Schedules[scheduleId].TimeZone
+	if name == "" {
+		name, _ = s.validator.TimeZone(ctx, scheduleId)
+	}
+	if name == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid schedule time zone", "schedule", scheduleId, "timeZone", name)
+		return time.UTC
+	}
+	return loc
+}
+
+func parseEventTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid event time %q", value)
+}
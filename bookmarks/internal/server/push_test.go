@@ -0,0 +1,206 @@
+package server_test
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/push"
+	"bookmarks/internal/server"
+	"bookmarks/internal/structs"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const TIME_ZONE = "Asia/Tokyo"
+
+// pushService is a stand-in push service. It counts the notifications sent
+// to each endpoint path, and fails the first request to paths ending in
+// "/fail".
+type pushService struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	received map[string]int
+}
+
+func newPushService(t *testing.T) *pushService {
+	p := &pushService{received: make(map[string]int)}
+	p.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p.lock.Lock()
+		p.received[req.URL.Path]++
+		count := p.received[req.URL.Path]
+		p.lock.Unlock()
+
+		if strings.HasSuffix(req.URL.Path, "/fail") && count == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *pushService) count(path string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.received[path]
+}
+
+// newPushServer starts a server that sends notifications to the stand-in, and
+// fetches the feed on every use.
+func newPushServer(t *testing.T, service *pushService) *testServer {
+	publicKey, privateKey, err := push.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newConfiguredServer(t, func(cfg *config.Config) {
+		cfg.VAPIDPublicKey = publicKey
+		cfg.VAPIDPrivateKey = privateKey
+		cfg.VAPIDSubject = "mailto:test@example.com"
+		cfg.PushHosts = []string{"127.0.0.1"}
+		cfg.Schedules = map[string]config.ScheduleSettings{SCHEDULE_ID: {TimeZone: TIME_ZONE}}
+	},
+		server.WithPushClient(service.server.Client()),
+		server.WithReminderInterval(10*time.Millisecond),
+		server.WithRefreshInterval(0),
+	)
+}
+
+// subscribe sets up a session with the events bookmarked, and subscribes it
+// to notifications at the path of the push service. It returns the session's
+// client.
+func (s *testServer) subscribe(t *testing.T, service *pushService, path string, reminderMinutes int, changeAlerts bool, events ...string) *http.Client {
+	t.Helper()
+	client := newClient(t)
+	s.setup(t, client)
+	s.setBookmarks(t, client, events...)
+
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	req := structs.PushSubscriptionRequest{ReminderMinutes: reminderMinutes, ChangeAlerts: changeAlerts}
+	req.Subscription.Endpoint = service.server.URL + path
+	req.Subscription.Keys.P256dh = base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes())
+	req.Subscription.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+	if status := s.do(t, client, "PUT", "/schedule/"+SCHEDULE_ID+"/push", req, nil); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	return client
+}
+
+// waitFor waits up to a second for cond to be true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReminders(t *testing.T) {
+	service := newPushService(t)
+	srv := newPushServer(t, service)
+
+	// start times without an offset are in the schedule's time zone
+	loc, err := time.LoadLocation(TIME_ZONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(d time.Duration) string {
+		return time.Now().Add(d).In(loc).Format("2006-01-02T15:04:05")
+	}
+	srv.source.SetEvents(SCHEDULE_ID, []structs.Event{
+		{Id: "soon", Title: "Soon", Start: local(5 * time.Minute)},
+		{Id: "later", Title: "Later", Start: local(30 * time.Minute)},
+		{Id: "started", Title: "Started", Start: local(-time.Minute)},
+	})
+
+	srv.subscribe(t, service, "/push/soon", 10, false, "soon")
+	srv.subscribe(t, service, "/push/later", 10, false, "later", "started")
+	srv.subscribe(t, service, "/push/fail", 10, false, "soon")
+
+	waitFor(t, func() bool {
+		return service.count("/push/soon") > 0 && service.count("/push/fail") > 1
+	})
+
+	// later reminder checks don't send them again
+	time.Sleep(100 * time.Millisecond)
+	if count := service.count("/push/soon"); count != 1 {
+		t.Fatalf("expected one reminder, got %d", count)
+	}
+	if count := service.count("/push/fail"); count != 2 {
+		t.Fatalf("expected the failed reminder to be sent once more, got %d", count)
+	}
+	if count := service.count("/push/later"); count != 0 {
+		t.Fatalf("expected no reminder for events not due, got %d", count)
+	}
+}
+
+func TestChangeAlerts(t *testing.T) {
+	service := newPushService(t)
+	srv := newPushServer(t, service)
+
+	client := srv.subscribe(t, service, "/push/alerts", 0, true, "e1")
+	srv.subscribe(t, service, "/push/none", 0, false, "e1")
+
+	srv.source.SetEvents(SCHEDULE_ID, []structs.Event{
+		{Id: "e1", Title: "One", Location: "Hall B"},
+		{Id: "e2", Title: "Two", Location: "Hall C"},
+		{Id: "e3", Title: "Three"},
+	})
+	// fetching the feed compares it with the previous one
+	srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks/changes", nil, nil)
+	waitFor(t, func() bool {
+		return service.count("/push/alerts") > 0
+	})
+
+	srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks/changes", nil, nil)
+	time.Sleep(50 * time.Millisecond)
+	if count := service.count("/push/alerts"); count != 1 {
+		t.Fatalf("expected one alert for the bookmarked event, got %d", count)
+	}
+	if count := service.count("/push/none"); count != 0 {
+		t.Fatalf("expected no alert without change alerts, got %d", count)
+	}
+}
+
+func TestRemovalAlerts(t *testing.T) {
+	service := newPushService(t)
+	srv := newPushServer(t, service)
+
+	client := srv.subscribe(t, service, "/push/alerts", 0, true, "e1")
+	// fetch changes the feed, and waits for the session's changes to e1
+	fetch := func(changes int, events ...structs.Event) {
+		srv.source.SetEvents(SCHEDULE_ID, events)
+		waitFor(t, func() bool {
+			var resp structs.EventChangesResponse
+			srv.do(t, client, "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks/changes", nil, &resp)
+			return len(resp.Changes) == changes
+		})
+	}
+
+	fetch(1, structs.Event{Id: "e2", Title: "Two"})
+	waitFor(t, func() bool {
+		return service.count("/push/alerts") == 1
+	})
+
+	// a removal after the event is restored is a new alert
+	fetch(2, structs.Event{Id: "e1", Title: "One"}, structs.Event{Id: "e2", Title: "Two"})
+	fetch(3, structs.Event{Id: "e2", Title: "Two"})
+	waitFor(t, func() bool {
+		return service.count("/push/alerts") == 2
+	})
+}
@@ -3,55 +3,176 @@ package server
 import (
 	"bookmarks/internal/config"
 	"bookmarks/internal/db"
+	"bookmarks/internal/logging"
 	"bookmarks/internal/validator"
+	"bookmarks/internal/webhook"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/phuslu/lru"
 )
 
-func Run(port int, db *db.DB, config *config.Config) {
+func Run(port int, store db.Store, config *config.Config, updates <-chan *config.Config) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: NewHandler(context.Background(), store, config, updates),
+	}
+
+	slog.Info("server starting", "port", port)
+	if err := server.ListenAndServe(); err != nil {
+		panic(err)
+	}
+}
+
+// Option changes a default of NewHandler, e.g. to run against local
+// stand-ins in tests.
+type Option func(s *server)
+
+// WithPushClient sends push notifications with client instead of the default
+// HTTP client.
+func WithPushClient(client *http.Client) Option {
+	return func(s *server) {
+		s.pushClient = client
+	}
+}
+
+// WithReminderInterval checks for due reminders every interval instead of
+// every REMINDER_INTERVAL.
+func WithReminderInterval(interval time.Duration) Option {
+	return func(s *server) {
+		s.reminderInterval = interval
+	}
+}
 
-	serverCfg := server{
-		db:         db,
-		config:     config,
-		validator:  validator.NewValidator(config.ScheduleURLs),
-		countCache: lru.NewTTLCache[string, map[string]int](16),
+// WithRefreshInterval sets how long fetched events are used before the feeds
+// are fetched again.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(s *server) {
+		s.validator.RefreshInterval = interval
 	}
+}
+
+// NewHandler returns the service's routes, and starts its background tasks
+// until ctx is done. Config changes are read from updates, which may be nil.
+func NewHandler(ctx context.Context, store db.Store, config *config.Config, updates <-chan *config.Config, opts ...Option) http.Handler {
+	serverCfg := &server{
+		db:               store,
+		validator:        validator.NewValidator(config.ScheduleURLs, config.FeedCacheDir),
+		countCache:       lru.NewTTLCache[string, map[string]int](16),
+		webhooks:         webhook.NewDispatcher(),
+		reminderInterval: REMINDER_INTERVAL,
+		emptySelection:   newEmptySelectionResponse(config.Secret),
+	}
+	for _, opt := range opts {
+		opt(serverCfg)
+	}
+	serverCfg.cfg.Store(config)
+	serverCfg.validator.SetAliases(config.GetAliases())
+	serverCfg.validator.OnChange = serverCfg.feedChanged
+	serverCfg.validator.EventLists = store
+	serverCfg.webhooks.OnAttempt = serverCfg.webhookAttempt
+	serverCfg.validator.Prewarm(ctx)
+	go serverCfg.runReminders(ctx)
+	go serverCfg.runMaintenance(ctx)
+	go serverCfg.runBackups(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				serverCfg.reload(update)
+			}
+		}
+	}()
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(logging.Middleware)
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   config.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "PUT", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowOriginFunc: func(r *http.Request, origin string) bool {
+			return slices.Contains(serverCfg.getConfig().AllowedOrigins, origin)
+		},
+		AllowedMethods:   []string{"GET", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", logging.REQUEST_ID_HEADER},
+		ExposedHeaders:   []string{logging.REQUEST_ID_HEADER, "Deprecation", "Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	r.Get("/openapi.json", serverCfg.openAPIHandler)
+
+	r.Route("/me", func(r chi.Router) {
+		r.Get("/schedules", serverCfg.getMySchedulesHandler)
+		r.Get("/export", serverCfg.exportHandler)
+		r.Delete("/", serverCfg.eraseHandler)
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(serverCfg.adminMiddleware)
+		r.Get("/sessions/{sessionId}/export", serverCfg.adminExportSessionHandler)
+		r.Delete("/sessions/{sessionId}", serverCfg.adminEraseSessionHandler)
+		r.Get("/schedule/{scheduleId}/counts", serverCfg.adminCountsHandler)
+		r.Get("/backup", serverCfg.adminBackupHandler)
+	})
+
 	r.Route("/schedule/{scheduleId}", func(r chi.Router) {
+		r.Use(scheduleLogMiddleware)
+		r.Group(func(r chi.Router) {
+			r.Use(deprecatedMiddleware)
+			r.Put("/setup-bookmarks", serverCfg.setupSessionHandler)
+			r.Route("/bookmarks", func(r chi.Router) {
+				r.Get("/", serverCfg.getSessionSelectionHandler)
+				r.Put("/", serverCfg.setSelectionHandler)
+				r.Get("/removed", serverCfg.getRemovedEventsHandler)
+				r.Get("/changes", serverCfg.getEventChangesHandler)
+				r.Get("/decode/{code}", serverCfg.decodeShareCodeHandler)
+				r.Get("/{hash}", serverCfg.getSelectionHandler)
+			})
+			r.Route("/push", func(r chi.Router) {
+				r.Get("/key", serverCfg.getPushKeyHandler)
+				r.Put("/", serverCfg.setPushSubscriptionHandler)
+				r.Delete("/", serverCfg.deletePushSubscriptionHandler)
+			})
+			r.Get("/counts", serverCfg.getEventSelectionCountsHandler)
+		})
+		r.Get("/counts.html", serverCfg.getEventSelectionCountsHTMLHandler)
+	})
+
+	r.Route("/v2/schedule/{scheduleId}", func(r chi.Router) {
+		r.Use(scheduleLogMiddleware)
+		r.Use(errorEnvelopeMiddleware)
 		r.Put("/setup-bookmarks", serverCfg.setupSessionHandler)
 		r.Route("/bookmarks", func(r chi.Router) {
-			r.Get("/", serverCfg.getSessionSelectionHandler)
-			r.Put("/", serverCfg.setSelectionHandler)
-			r.Get("/{hash}", serverCfg.getSelectionHandler)
+			r.Get("/", serverCfg.v2SelectionHandler(serverCfg.v2GetSessionSelection))
+			r.Put("/", serverCfg.v2SelectionHandler(serverCfg.v2SetSelection))
+			r.Get("/removed", serverCfg.v2GetRemovedEventsHandler)
+			r.Get("/changes", serverCfg.v2GetEventChangesHandler)
+			r.Get("/decode/{code}", serverCfg.v2SelectionHandler(serverCfg.v2DecodeShareCode))
+			r.Get("/{hash}", serverCfg.v2SelectionHandler(serverCfg.v2GetSelection))
+		})
+		r.Route("/push", func(r chi.Router) {
+			r.Get("/key", serverCfg.getPushKeyHandler)
+			r.Put("/", serverCfg.setPushSubscriptionHandler)
+			r.Delete("/", serverCfg.deletePushSubscriptionHandler)
+		})
+		// check-ins are new, so they aren't added to the deprecated routes
+		r.Route("/attendance", func(r chi.Router) {
+			r.Get("/", serverCfg.getAttendanceHandler)
+			r.Put("/{eventId}", serverCfg.setAttendanceHandler)
+			r.Delete("/{eventId}", serverCfg.setAttendanceHandler)
 		})
 		r.Get("/counts", serverCfg.getEventSelectionCountsHandler)
-		r.Get("/counts.html", serverCfg.getEventSelectionCountsHTMLHandler)
 	})
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: r,
-	}
-
-	log.Println("server starting")
-	if err := server.ListenAndServe(); err != nil {
-		panic(err)
-	}
+	return r
 }
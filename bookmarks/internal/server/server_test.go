@@ -0,0 +1,406 @@
+package server_test
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/counts"
+	"bookmarks/internal/db/memory"
+	"bookmarks/internal/selection"
+	"bookmarks/internal/server"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/validator/validatortest"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const SCHEDULE_ID = "test"
+const SECRET = "test-secret"
+const ADMIN_TOKEN = "test-admin-token"
+
+var testEvents = []structs.Event{
+	{Id: "e1", Title: "One"},
+	{Id: "e2", Title: "Two"},
+	{Id: "e3", Title: "Three"},
+}
+
+type testServer struct {
+	url    string
+	store  *memory.Store
+	source *validatortest.Source
+	routes chi.Routes
+	api    map[string]any
+	called map[string]bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newConfiguredServer(t, nil)
+}
+
+// newConfiguredServer starts a server for testEvents. configure, if not nil,
+// changes the config, which is then parsed as from a file.
+func newConfiguredServer(t *testing.T, configure func(cfg *config.Config), opts ...server.Option) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	source := validatortest.NewSource(t, map[string][]structs.Event{SCHEDULE_ID: testEvents})
+	store := memory.NewStore([]byte(SECRET))
+	cfg := &config.Config{
+		ScheduleURLs: source.URLs(),
+		Secret:       SECRET,
+		AdminToken:   ADMIN_TOKEN,
+	}
+	if configure != nil {
+		configure(cfg)
+		cfg = parseConfig(t, cfg)
+	}
+
+	handler := server.NewHandler(ctx, store, cfg, nil, opts...)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &testServer{srv.URL, store, source, handler.(chi.Routes), loadOpenAPI(t, srv.URL), map[string]bool{}}
+}
+
+// restart starts another server with the same store and feeds, as after a
+// restart without a feed cache.
+func (s *testServer) restart(t *testing.T, opts ...server.Option) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := &config.Config{
+		ScheduleURLs: s.source.URLs(),
+		Secret:       SECRET,
+		AdminToken:   ADMIN_TOKEN,
+	}
+	handler := server.NewHandler(ctx, s.store, cfg, nil, opts...)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &testServer{srv.URL, s.store, s.source, handler.(chi.Routes), s.api, map[string]bool{}}
+}
+
+// parseConfig writes a config to a file and parses it, to validate it and
+// read its keys.
+func parseConfig(t *testing.T, cfg *config.Config) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.DBURL = filepath.Join(dir, "db.sqlite")
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(cfgPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := config.ParseConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// newClient returns a client with its own cookies, like a browser.
+func newClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// newAdminClient returns a client that sends the admin token.
+func newAdminClient() *http.Client {
+	return &http.Client{Transport: adminTransport{}}
+}
+
+type adminTransport struct{}
+
+func (adminTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+ADMIN_TOKEN)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// do sends a request with body as JSON, if not nil, and decodes the response
+// into out, if not nil. It returns the status code. The response is checked
+// against the OpenAPI description.
+func (s *testServer) do(t *testing.T, client *http.Client, method string, path string, body any, out any) int {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, s.url+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.checkContract(t, method, path, resp, respBody)
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (s *testServer) setup(t *testing.T, client *http.Client) string {
+	t.Helper()
+	var resp structs.BookmarkSetupResponse
+	if status := s.do(t, client, "PUT", "/schedule/"+SCHEDULE_ID+"/setup-bookmarks", nil, &resp); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	return resp.SessionID
+}
+
+func (s *testServer) setBookmarks(t *testing.T, client *http.Client, events ...string) structs.SessionBookmarksResponse {
+	t.Helper()
+	var resp structs.SessionBookmarksResponse
+	if status := s.do(t, client, "PUT", "/schedule/"+SCHEDULE_ID+"/bookmarks", structs.BookmarksRequest{Events: events}, &resp); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	return resp
+}
+
+func TestSetup(t *testing.T) {
+	srv := newTestServer(t)
+	client := newClient(t)
+
+	if status := srv.do(t, client, "PUT", "/schedule/unknown/setup-bookmarks", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown schedule, got %d", status)
+	}
+
+	sessionId := srv.setup(t, client)
+	if sessionId == "" {
+		t.Fatal("expected a session ID")
+	}
+	if again := srv.setup(t, client); again != sessionId {
+		t.Fatalf("expected the cookie's session %s, got %s", sessionId, again)
+	}
+
+	// another device joins the session with its ID
+	other := newClient(t)
+	var resp structs.BookmarkSetupResponse
+	if status := srv.do(t, other, "PUT", "/schedule/"+SCHEDULE_ID+"/setup-bookmarks", structs.BookmarkSetupRequest{SessionID: sessionId}, &resp); status != http.StatusOK || resp.SessionID != sessionId {
+		t.Fatalf("expected to join %s, got %d, %s", sessionId, status, resp.SessionID)
+	}
+
+	// forged IDs are replaced
+	forged := newClient(t)
+	if status := srv.do(t, forged, "PUT", "/schedule/"+SCHEDULE_ID+"/setup-bookmarks", structs.BookmarkSetupRequest{SessionID: "forged.sig"}, &resp); status != http.StatusOK || resp.SessionID == sessionId || resp.SessionID == "forged.sig" {
+		t.Fatalf("expected a new session, got %d, %s", status, resp.SessionID)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	srv := newTestServer(t)
+	client := newClient(t)
+	path := "/schedule/" + SCHEDULE_ID + "/bookmarks"
+
+	var empty structs.SessionBookmarksResponse
+	if status := srv.do(t, client, "GET", path, nil, &empty); status != http.StatusOK || len(empty.Events) != 0 {
+		t.Fatalf("expected no bookmarks, got %d, %v", status, empty)
+	}
+	if empty.Id != selection.NewSelection(nil).Hash([]byte(SECRET)) {
+		t.Fatalf("unexpected empty selection ID %s", empty.Id)
+	}
+
+	if status := srv.do(t, client, "PUT", path, structs.BookmarksRequest{Events: []string{"e1"}}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a session, got %d", status)
+	}
+
+	sessionId := srv.setup(t, client)
+	saved := srv.setBookmarks(t, client, "e2", "e1", "unknown")
+	if !slices.Equal(saved.Events, []string{"e2", "e1"}) || saved.Id == "" || saved.Code == "" {
+		t.Fatalf("unexpected response %v", saved)
+	}
+
+	var cur structs.SessionBookmarksResponse
+	if status := srv.do(t, client, "GET", path, nil, &cur); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if cur.Id != saved.Id || cur.Date != saved.Date || cur.Code != saved.Code || !slices.Equal(slices.Sorted(slices.Values(cur.Events)), []string{"e1", "e2"}) {
+		t.Fatalf("expected the saved bookmarks %v, got %v", saved, cur)
+	}
+
+	// the selection is shared by its ID, without a session
+	var shared structs.BookmarksResponse
+	if status := srv.do(t, newClient(t), "GET", path+"/"+saved.Id, nil, &shared); status != http.StatusOK || shared.Id != saved.Id || len(shared.Events) != 2 {
+		t.Fatalf("expected the shared selection, got %d, %v", status, shared)
+	}
+
+	var decoded structs.BookmarksResponse
+	if status := srv.do(t, newClient(t), "GET", path+"/decode/"+saved.Code, nil, &decoded); status != http.StatusOK || !slices.Equal(decoded.Events, []string{"e1", "e2"}) {
+		t.Fatalf("expected the decoded selection, got %d, %v", status, decoded)
+	}
+	if status := srv.do(t, newClient(t), "GET", path+"/decode/invalid", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for an invalid code, got %d", status)
+	}
+
+	// other sessions are separate
+	other := newClient(t)
+	srv.setup(t, other)
+	if status := srv.do(t, other, "GET", path, nil, &cur); status != http.StatusOK || len(cur.Events) != 0 {
+		t.Fatalf("expected no bookmarks for another session, got %d, %v", status, cur)
+	}
+	// saving no bookmarks gives the ID of the empty response
+	if cleared := srv.setBookmarks(t, other); cleared.Id != empty.Id {
+		t.Fatalf("expected the empty selection ID %s, got %s", empty.Id, cleared.Id)
+	}
+
+	data, err := srv.store.GetSessionData(context.Background(), strings.Split(sessionId, ".")[0])
+	if err != nil || len(data.History) != 1 {
+		t.Fatalf("expected the session's history, got %v, %v", data, err)
+	}
+
+	if status := srv.do(t, client, "DELETE", "/me/", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	if status := srv.do(t, client, "GET", path, nil, &cur); status != http.StatusOK || len(cur.Events) != 0 {
+		t.Fatalf("expected no bookmarks after erasing, got %d, %v", status, cur)
+	}
+	data, err = srv.store.GetSessionData(context.Background(), strings.Split(sessionId, ".")[0])
+	if err != nil || len(data.History) != 0 {
+		t.Fatalf("expected the session's data to be erased, got %v, %v", data, err)
+	}
+
+	if fetches := srv.source.Fetches(SCHEDULE_ID); fetches != 1 {
+		t.Fatalf("expected the feed to be fetched once, got %d", fetches)
+	}
+}
+
+func TestNoisyCounts(t *testing.T) {
+	srv := newConfiguredServer(t, func(cfg *config.Config) {
+		cfg.Schedules = map[string]config.ScheduleSettings{
+			SCHEDULE_ID: {Counts: counts.Privacy{NoiseEpsilon: 0.5}},
+		}
+	}, server.WithRefreshInterval(0))
+
+	events := make([]structs.Event, 0)
+	for i := range 200 {
+		events = append(events, structs.Event{Id: fmt.Sprintf("e%d", i)})
+	}
+	srv.source.SetEvents(SCHEDULE_ID, events)
+
+	// nobody bookmarked anything, but events still get counts
+	var resp structs.EventSelectionCountsResponse
+	if status := srv.do(t, newClient(t), "GET", "/schedule/"+SCHEDULE_ID+"/counts", nil, &resp); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(resp.Counts) < 20 {
+		t.Fatalf("expected noisy counts for events without bookmarks, got %v", resp.Counts)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	srv := newTestServer(t)
+	path := "/schedule/" + SCHEDULE_ID + "/counts"
+
+	for _, events := range [][]string{{"e1", "e2"}, {"e1"}, {"e3", "e1"}} {
+		client := newClient(t)
+		srv.setup(t, client)
+		srv.setBookmarks(t, client, events...)
+	}
+
+	var resp structs.EventSelectionCountsResponse
+	if status := srv.do(t, newClient(t), "GET", path, nil, &resp); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if expected := map[string]int{"e1": 3, "e2": 1, "e3": 1}; !maps.Equal(resp.Counts, expected) {
+		t.Fatalf("expected counts %v, got %v", expected, resp.Counts)
+	}
+
+	// counts are cached
+	client := newClient(t)
+	srv.setup(t, client)
+	srv.setBookmarks(t, client, "e2")
+	if status := srv.do(t, newClient(t), "GET", path, nil, &resp); status != http.StatusOK || resp.Counts["e2"] != 1 {
+		t.Fatalf("expected the cached counts, got %d, %v", status, resp.Counts)
+	}
+
+	if status := srv.do(t, newClient(t), "GET", "/schedule/unknown/counts", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown schedule, got %d", status)
+	}
+
+	resp2, err := http.Get(srv.url + path + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	html, _ := io.ReadAll(resp2.Body)
+	if !strings.Contains(string(html), "<td>e1</td><td>3</td>") {
+		t.Fatalf("expected counts in the HTML, got %s", html)
+	}
+}
+
+func TestCodeAfterReset(t *testing.T) {
+	srv := newConfiguredServer(t, nil, server.WithRefreshInterval(0))
+	client := newClient(t)
+	srv.setup(t, client)
+	saved := srv.setBookmarks(t, client, "e1", "e2")
+
+	// e1 is renamed and e3 removed, and the database is reset
+	srv.source.SetEvents(SCHEDULE_ID, []structs.Event{
+		{Id: "renamed", Title: "One", PreviousIds: []string{"e1"}},
+		{Id: "e2", Title: "Two"},
+		{Id: "e4", Title: "Four"},
+	})
+	srv.store = memory.NewStore([]byte(SECRET))
+	reset := srv.restart(t, server.WithRefreshInterval(0))
+
+	var decoded structs.BookmarksResponse
+	if status := reset.do(t, newClient(t), "GET", "/schedule/"+SCHEDULE_ID+"/bookmarks/decode/"+saved.Code, nil, &decoded); status != http.StatusOK ||
+		!slices.Equal(slices.Sorted(slices.Values(decoded.Events)), []string{"e2", "renamed"}) {
+		t.Fatalf("expected the decoded selection, got %d, %v", status, decoded)
+	}
+}
+
+func TestLegacyCodeAfterRestart(t *testing.T) {
+	srv := newConfiguredServer(t, nil, server.WithRefreshInterval(0))
+	client := newClient(t)
+	srv.setup(t, client)
+	srv.setBookmarks(t, client, "e1", "e2")
+	code := selection.NewSelection([]string{"e1", "e2"}).LegacyCode([]string{"e1", "e2", "e3"})
+
+	srv.source.SetEvents(SCHEDULE_ID, append(slices.Clone(testEvents), structs.Event{Id: "e4", Title: "Four"}))
+	path := "/schedule/" + SCHEDULE_ID + "/bookmarks/decode/" + code
+
+	// the code is for the previous feed, before and after a restart
+	for _, srv := range []*testServer{srv, srv.restart(t, server.WithRefreshInterval(0))} {
+		var decoded structs.BookmarksResponse
+		if status := srv.do(t, newClient(t), "GET", path, nil, &decoded); status != http.StatusOK || !slices.Equal(decoded.Events, []string{"e1", "e2"}) {
+			t.Fatalf("expected the decoded selection, got %d, %v", status, decoded)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 )
 
 const COOKIE_NAME = "schedule-session-"
+const GLOBAL_COOKIE_NAME = "schedule-session"
 const COOKIE_EXPIRATION = 3 * 30 * 24 * time.Hour / time.Second
 
 var ErrInvalidSession = errors.New("invalid session")
@@ -24,7 +26,10 @@ type sessionId struct {
 }
 
 func newSessionId(secret string) sessionId {
-	id := nanoid.Must()
+	return signSessionId(nanoid.Must(), secret)
+}
+
+func signSessionId(id string, secret string) sessionId {
 	sig := sign(COOKIE_NAME+"="+id, secret)
 	return sessionId{
 		Id:        id,
@@ -41,6 +46,15 @@ func getSessionIdFromCookie(req *http.Request, secret string, scheduleId string)
 	return verifySessionId(cookieVal.Value, secret)
 }
 
+func getGlobalSessionIdFromCookie(req *http.Request, secret string) (sessionId, error) {
+	cookieVal, err := req.Cookie(GLOBAL_COOKIE_NAME)
+	if err != nil {
+		return sessionId{}, ErrInvalidSession
+	}
+
+	return verifySessionId(cookieVal.Value, secret)
+}
+
 func verifySessionId(sessionValue string, secret string) (sessionId, error) {
 	parts := strings.Split(sessionValue, ".")
 	if len(parts) < 2 {
@@ -64,9 +78,26 @@ func (s sessionId) String() string {
 	return s.Id + "." + s.Signature
 }
 
+// LogValue only logs a prefix of the session ID, never the signature.
+func (s sessionId) LogValue() slog.Value {
+	if len(s.Id) > 6 {
+		return slog.StringValue(s.Id[:6])
+	}
+	return slog.StringValue(s.Id)
+}
+
 func (s sessionId) SetCookie(w http.ResponseWriter, domain string, scheduleId string) {
+	s.setCookie(w, domain, getCookieName(scheduleId))
+}
+
+// SetGlobalCookie sets the cookie of the session shared by every schedule.
+func (s sessionId) SetGlobalCookie(w http.ResponseWriter, domain string) {
+	s.setCookie(w, domain, GLOBAL_COOKIE_NAME)
+}
+
+func (s sessionId) setCookie(w http.ResponseWriter, domain string, name string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     getCookieName(scheduleId),
+		Name:     name,
 		Value:    s.String(),
 		MaxAge:   int(COOKIE_EXPIRATION),
 		SameSite: http.SameSiteLaxMode,
@@ -86,3 +117,9 @@ func sign(text string, secret string) string {
 func getCookieName(scheduleId string) string {
 	return fmt.Sprintf("%s%s", COOKIE_NAME, scheduleId)
 }
+
+// cutCookieName returns the schedule ID of a schedule's session cookie name.
+func cutCookieName(name string) (string, bool) {
+	scheduleId, ok := strings.CutPrefix(name, COOKIE_NAME)
+	return scheduleId, ok && scheduleId != ""
+}
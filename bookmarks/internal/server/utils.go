@@ -1,10 +1,13 @@
 package server
 
 import (
+	"bookmarks/internal/logging"
 	"encoding/json"
+	"log/slog"
 	"net/http"
-)
 
+	"github.com/go-chi/chi/v5"
+)
 
 func httpError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
@@ -19,3 +22,10 @@ func jsonResponse(w http.ResponseWriter, value any) {
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(bytes)
 }
+
+func scheduleLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logging.AddAttrs(req.Context(), slog.String("schedule", chi.URLParam(req, "scheduleId")))
+		next.ServeHTTP(w, req)
+	})
+}
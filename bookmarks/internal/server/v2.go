@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/structs"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// V1_DEPRECATION is the Deprecation header of the unversioned routes: the
+// date the v2 routes were added, as an RFC 9745 structured date.
+const V1_DEPRECATION = "@1792368000"
+
+const EXPAND_EVENTS = "events"
+
+const DEFAULT_PAGE_SIZE = 100
+const MAX_PAGE_SIZE = 1000
+
+// errorEnvelopeMiddleware replaces the body of error responses with a JSON
+// ErrorResponse. Responses that are already JSON are sent as they are.
+func errorEnvelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&envelopeWriter{ResponseWriter: w}, req)
+	})
+}
+
+type envelopeWriter struct {
+	http.ResponseWriter
+	discard bool
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if status < 400 || strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.discard = true
+	v2Error(w.ResponseWriter, status, http.StatusText(status))
+}
+
+func (w *envelopeWriter) Write(data []byte) (int, error) {
+	if w.discard {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// v2Error sends an ErrorResponse.
+func v2Error(w http.ResponseWriter, status int, message string) {
+	body, err := json.Marshal(structs.ErrorResponse{
+		Error: structs.ErrorDetail{
+			Status:  status,
+			Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+			Message: message,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// deprecatedMiddleware marks the unversioned routes as deprecated, and links
+// to their /v2 successor. The link is relative, so that it works behind a
+// path prefix.
+func deprecatedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.EscapedPath()
+		successor := strings.Repeat("../", strings.Count(path, "/")-1) + "v2" + path
+		w.Header().Set("Deprecation", V1_DEPRECATION)
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// parseExpand returns the expansions in the expand parameter, a comma
+// separated list.
+func parseExpand(query url.Values) (map[string]bool, error) {
+	expand := map[string]bool{}
+	for _, value := range query["expand"] {
+		for _, name := range strings.Split(value, ",") {
+			if name != EXPAND_EVENTS {
+				return nil, errors.New("unknown expansion " + strconv.Quote(name))
+			}
+			expand[name] = true
+		}
+	}
+	return expand, nil
+}
+
+// v2SelectionHandler serves the selection returned by get, with the
+// requested expansions.
+func (s *server) v2SelectionHandler(get func(w http.ResponseWriter, req *http.Request) (*structs.SelectionResponseV2, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		expand, err := parseExpand(req.URL.Query())
+		if err != nil {
+			v2Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		resp, status := get(w, req)
+		if status != http.StatusOK {
+			httpError(w, status)
+			return
+		}
+
+		if expand[EXPAND_EVENTS] {
+			resp.EventDetails = s.eventDetails(req.Context(), chi.URLParam(req, "scheduleId"), resp.Events)
+		}
+		jsonResponse(w, resp)
+	}
+}
+
+// eventDetails returns the feed's details of the events, skipping events
+// that are not in the feed.
+func (s *server) eventDetails(ctx context.Context, scheduleId string, eventIds []string) []structs.Event {
+	events, err := s.validator.Events(ctx, scheduleId)
+	if err != nil {
+		slog.WarnContext(ctx, "error fetching events", "error", err)
+		return nil
+	}
+
+	byId := make(map[string]structs.Event, len(events))
+	for _, event := range events {
+		byId[event.Id] = event
+	}
+
+	details := make([]structs.Event, 0, len(eventIds))
+	for _, eventId := range eventIds {
+		if event, ok := byId[eventId]; ok {
+			details = append(details, event)
+		}
+	}
+	return details
+}
+
+func (s *server) v2GetSessionSelection(w http.ResponseWriter, req *http.Request) (*structs.SelectionResponseV2, int) {
+	resp, status := s.getSessionSelection(req)
+	if status != http.StatusOK {
+		return nil, status
+	}
+	return &structs.SelectionResponseV2{Id: resp.Id, Date: resp.Date, Events: resp.Events, Code: resp.Code}, status
+}
+
+func (s *server) v2SetSelection(w http.ResponseWriter, req *http.Request) (*structs.SelectionResponseV2, int) {
+	resp, status := s.setSelection(w, req)
+	if status != http.StatusOK {
+		return nil, status
+	}
+	return &structs.SelectionResponseV2{Id: resp.Id, Date: resp.Date, Events: resp.Events, Code: resp.Code}, status
+}
+
+func (s *server) v2GetSelection(w http.ResponseWriter, req *http.Request) (*structs.SelectionResponseV2, int) {
+	resp, status := s.getSelection(req)
+	if status != http.StatusOK {
+		return nil, status
+	}
+	return &structs.SelectionResponseV2{Id: resp.Id, Events: resp.Events, Code: resp.Code}, status
+}
+
+func (s *server) v2DecodeShareCode(w http.ResponseWriter, req *http.Request) (*structs.SelectionResponseV2, int) {
+	resp, status := s.decodeShareCode(req)
+	if status != http.StatusOK {
+		return nil, status
+	}
+	return &structs.SelectionResponseV2{Id: resp.Id, Events: resp.Events, Code: resp.Code}, status
+}
+
+// parsePage returns the page of a list from the limit and cursor parameters.
+func parsePage(query url.Values) (db.Page, error) {
+	p := db.Page{Limit: DEFAULT_PAGE_SIZE}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > MAX_PAGE_SIZE {
+			return p, errors.New("limit must be between 1 and " + strconv.Itoa(MAX_PAGE_SIZE))
+		}
+		p.Limit = limit
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return p, db.ErrInvalidCursor
+		}
+		p.After = string(after)
+	}
+	return p, nil
+}
+
+// storePage returns the page to read from the store: one more item than the
+// page, to know whether there is a next page.
+func storePage(p db.Page) db.Page {
+	if p.Limit > 0 {
+		p.Limit++
+	}
+	return p
+}
+
+// nextPage returns the items of the page, read with storePage, and the cursor
+// of the next page, or "" if it is the last. Cursors are keys, so pages stay
+// consistent as items are added and removed.
+func nextPage[T any](items []T, key func(T) string, p db.Page) ([]T, string) {
+	if p.Limit == 0 || len(items) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	return items, base64.RawURLEncoding.EncodeToString([]byte(key(items[len(items)-1])))
+}
+
+func (s *server) v2GetRemovedEventsHandler(w http.ResponseWriter, req *http.Request) {
+	p, err := parsePage(req.URL.Query())
+	if err != nil {
+		v2Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, next, status := s.getRemovedEvents(req, p)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+
+	jsonResponse(w, structs.RemovedEventsResponseV2{Events: resp.Events, NextCursor: next})
+}
+
+func (s *server) v2GetEventChangesHandler(w http.ResponseWriter, req *http.Request) {
+	p, err := parsePage(req.URL.Query())
+	if err != nil {
+		v2Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, next, status := s.getEventChanges(req, p)
+	if status != http.StatusOK {
+		httpError(w, status)
+		return
+	}
+
+	jsonResponse(w, structs.EventChangesResponseV2{Changes: resp.Changes, NextCursor: next})
+}
@@ -0,0 +1,236 @@
+package server_test
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/structs"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// addChanges records a title change and the removal of each event, as if
+// the feed had changed.
+func (s *testServer) addChanges(t *testing.T, eventIds ...string) {
+	t.Helper()
+	now := time.Now()
+
+	var changes []db.EventChange
+	var removed []db.RemovedEvent
+	for i, eventId := range eventIds {
+		date := now.Add(time.Duration(i) * time.Microsecond)
+		changes = append(changes, db.EventChange{
+			EventId: eventId, Kind: "title", Old: eventId, New: "Renamed " + eventId, Date: date,
+		})
+		removed = append(removed, db.RemovedEvent{
+			EventId: eventId, Title: eventId, Start: "2026-10-19T10:00:00Z", Date: date.Format(time.RFC3339Nano),
+		})
+	}
+
+	if err := s.store.AddEventChanges(context.Background(), SCHEDULE_ID, changes); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.SetRemovedEvents(context.Background(), SCHEDULE_ID, removed, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestV2Errors(t *testing.T) {
+	srv := newTestServer(t)
+	schedule := "/v2/schedule/" + SCHEDULE_ID
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"PUT", schedule + "/bookmarks", http.StatusUnprocessableEntity, "unprocessable_entity"},
+		{"DELETE", schedule + "/push", http.StatusUnauthorized, "unauthorized"},
+		{"GET", "/v2/schedule/unknown/counts", http.StatusNotFound, "not_found"},
+		{"GET", schedule + "/bookmarks?expand=unknown", http.StatusBadRequest, "bad_request"},
+		{"GET", schedule + "/bookmarks/changes?limit=0", http.StatusBadRequest, "bad_request"},
+		{"GET", schedule + "/unknown", http.StatusNotFound, "not_found"},
+		{"POST", schedule + "/bookmarks", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, srv.url+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body structs.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != c.status || body.Error.Status != c.status || body.Error.Code != c.code || body.Error.Message == "" {
+			t.Errorf("%s %s: expected a %d %s error, got %d, %v, %v", c.method, c.path, c.status, c.code, resp.StatusCode, body, err)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected JSON, got %s", c.method, c.path, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestV2Expand(t *testing.T) {
+	srv := newTestServer(t)
+	client := newClient(t)
+	path := "/v2/schedule/" + SCHEDULE_ID + "/bookmarks"
+	srv.setup(t, client)
+
+	var saved structs.SelectionResponseV2
+	if status := srv.do(t, client, "PUT", path, structs.BookmarksRequest{Events: []string{"e3", "e1"}}, &saved); status != http.StatusOK || saved.EventDetails != nil {
+		t.Fatalf("expected no event details, got %d, %v", status, saved)
+	}
+
+	var expanded structs.SelectionResponseV2
+	if status := srv.do(t, client, "GET", path+"/"+saved.Id+"?expand=events", nil, &expanded); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(expanded.EventDetails) != 2 || expanded.EventDetails[0].Id != expanded.Events[0] || expanded.EventDetails[1].Title != "Three" {
+		t.Fatalf("expected the events' details, got %v", expanded)
+	}
+}
+
+func TestV2Pagination(t *testing.T) {
+	srv := newTestServer(t)
+	client := newClient(t)
+	srv.setup(t, client)
+	srv.setBookmarks(t, client, "e1", "e2", "e3")
+	srv.addChanges(t, "e3", "e1", "e2")
+	path := "/v2/schedule/" + SCHEDULE_ID + "/bookmarks/changes?limit=2"
+
+	var first structs.EventChangesResponseV2
+	if status := srv.do(t, client, "GET", path, nil, &first); status != http.StatusOK || len(first.Changes) != 2 || first.NextCursor == "" {
+		t.Fatalf("expected a first page, got %d, %v", status, first)
+	}
+
+	// a new change doesn't move the next page
+	time.Sleep(time.Millisecond)
+	srv.addChanges(t, "e1")
+
+	var second structs.EventChangesResponseV2
+	if status := srv.do(t, client, "GET", path+"&cursor="+url.QueryEscape(first.NextCursor), nil, &second); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	var ids []string
+	for _, change := range append(first.Changes, second.Changes...) {
+		ids = append(ids, change.EventId)
+	}
+	if !slices.Equal(ids, []string{"e3", "e1", "e2", "e1"}) || second.NextCursor != "" {
+		t.Fatalf("expected every change once, oldest first, got %v, %q", ids, second.NextCursor)
+	}
+
+	// a cursor from another list is rejected
+	if status := srv.do(t, client, "GET", path+"&cursor=eA", nil, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid cursor, got %d", status)
+	}
+
+	var removed structs.RemovedEventsResponseV2
+	if status := srv.do(t, client, "GET", "/v2/schedule/"+SCHEDULE_ID+"/bookmarks/removed?limit=3", nil, &removed); status != http.StatusOK || len(removed.Events) != 3 || removed.NextCursor != "" {
+		t.Fatalf("expected every removed event, got %d, %v", status, removed)
+	}
+}
+
+func TestAttendance(t *testing.T) {
+	srv := newTestServer(t)
+	path := "/v2/schedule/" + SCHEDULE_ID + "/attendance"
+
+	var clients []*http.Client
+	for _, events := range [][]string{{"e1", "e2"}, {"e1"}, {"e3", "e1"}} {
+		client := newClient(t)
+		srv.setup(t, client)
+		srv.setBookmarks(t, client, events...)
+		clients = append(clients, client)
+	}
+
+	for i, checkIn := range []struct {
+		client  *http.Client
+		eventId string
+		status  int
+	}{
+		{clients[0], "e1", http.StatusNoContent},
+		{clients[0], "e2", http.StatusNoContent},
+		{clients[1], "e1", http.StatusNoContent},
+		{clients[1], "e3", http.StatusUnprocessableEntity},
+		{newClient(t), "e1", http.StatusUnauthorized},
+	} {
+		if status := srv.do(t, checkIn.client, "PUT", path+"/"+checkIn.eventId, nil, nil); status != checkIn.status {
+			t.Fatalf("check-in %d: expected %d, got %d", i, checkIn.status, status)
+		}
+	}
+
+	var attendance structs.AttendanceResponse
+	if status := srv.do(t, clients[0], "GET", path, nil, &attendance); status != http.StatusOK || len(attendance.Attendance) != 2 ||
+		attendance.Attendance[0].EventId != "e1" || attendance.Attendance[1].EventId != "e2" {
+		t.Fatalf("expected the session's check-ins, got %d, %v", status, attendance)
+	}
+
+	var counts structs.AdminCountsResponse
+	if status := srv.do(t, newAdminClient(), "GET", "/admin/schedule/"+SCHEDULE_ID+"/counts", nil, &counts); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	expected := map[string]structs.EventAttendance{
+		"e1": {Bookmarked: 3, Attended: 2, Ratio: 2.0 / 3},
+		"e2": {Bookmarked: 1, Attended: 1, Ratio: 1},
+	}
+	if !maps.Equal(counts.Attendance, expected) || counts.Counts["e1"] != 3 {
+		t.Fatalf("expected attendance %v, got %v", expected, counts)
+	}
+
+	var export structs.ExportResponse
+	if status := srv.do(t, clients[0], "GET", "/me/export", nil, &export); status != http.StatusOK ||
+		len(export.Sessions) != 1 || len(export.Sessions[0].Attendance) != 2 {
+		t.Fatalf("expected the check-ins in the export, got %d, %v", status, export)
+	}
+
+	// removing the bookmark or cancelling stops the check-in being counted
+	srv.setBookmarks(t, clients[0], "e2")
+	if status := srv.do(t, clients[0], "DELETE", path+"/e2", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	var updated structs.AdminCountsResponse
+	srv.do(t, newAdminClient(), "GET", "/admin/schedule/"+SCHEDULE_ID+"/counts", nil, &updated)
+	if expected := map[string]structs.EventAttendance{"e1": {Bookmarked: 2, Attended: 1, Ratio: 0.5}}; !maps.Equal(updated.Attendance, expected) {
+		t.Fatalf("expected attendance %v, got %v", expected, updated.Attendance)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := http.Get(srv.url + "/schedule/" + SCHEDULE_ID + "/bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Deprecation") == "" {
+		t.Fatal("expected a Deprecation header")
+	}
+
+	base, _ := url.Parse("https://example.com/api/schedule/" + SCHEDULE_ID + "/bookmarks")
+	link := resp.Header.Get("Link")
+	expected := `<../../v2/schedule/` + SCHEDULE_ID + `/bookmarks>; rel="successor-version"`
+	if link != expected {
+		t.Fatalf("expected Link %s, got %s", expected, link)
+	}
+	if successor, _ := base.Parse("../../v2/schedule/" + SCHEDULE_ID + "/bookmarks"); successor.Path != "/api/v2/schedule/"+SCHEDULE_ID+"/bookmarks" {
+		t.Fatalf("expected the link to resolve under the prefix, got %s", successor)
+	}
+
+	resp, err = http.Get(srv.url + "/v2/schedule/" + SCHEDULE_ID + "/bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Deprecation") != "" || resp.Header.Get("Link") != "" {
+		t.Fatal("expected v2 not to be deprecated")
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bookmarks/internal/db"
+	"bookmarks/internal/selection"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/webhook"
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// selectionSaved sends the webhook events for a session's new selection.
+func (s *server) selectionSaved(ctx context.Context, scheduleId string, prev *selection.Selection, cur *selection.Selection) {
+	config := s.getConfig()
+	hooks := config.GetWebhooks(scheduleId)
+	if len(hooks) == 0 {
+		return
+	}
+
+	// the new selection's IDs were resolved, so the stored ones must be too
+	prevIds := make([]string, 0)
+	if prev != nil {
+		prevIds = s.resolveAliases(ctx, scheduleId, prev)
+	}
+	curIds := cur.GetEventIds()
+
+	data := structs.WebhookSelectionSaved{
+		Events:  curIds,
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+	}
+	for _, eventId := range curIds {
+		if !slices.Contains(prevIds, eventId) {
+			data.Added = append(data.Added, eventId)
+		}
+	}
+	for _, eventId := range prevIds {
+		if !slices.Contains(curIds, eventId) {
+			data.Removed = append(data.Removed, eventId)
+		}
+	}
+
+	s.webhooks.Dispatch(ctx, hooks, webhook.SELECTION_SAVED, scheduleId, data)
+
+	thresholds := config.Schedules[scheduleId].CountThresholds
+	if len(thresholds) == 0 {
+		return
+	}
+
+	// counts only increase for added events
+	for _, eventId := range data.Added {
+		count, err := s.db.GetEventSelectionCount(ctx, scheduleId, eventId)
+		if err != nil {
+			slog.ErrorContext(ctx, "error getting selection count", "error", err)
+			return
+		}
+
+		for _, threshold := range thresholds {
+			if count < threshold {
+				continue
+			}
+
+			crossed, err := s.db.MarkCountThreshold(ctx, scheduleId, eventId, threshold)
+			if err != nil {
+				slog.ErrorContext(ctx, "error recording count threshold", "error", err)
+				return
+			} else if crossed {
+				s.webhooks.Dispatch(ctx, hooks, webhook.COUNT_THRESHOLD, scheduleId, structs.WebhookCountThreshold{
+					EventId:   eventId,
+					Threshold: threshold,
+					Count:     count,
+				})
+			}
+		}
+	}
+}
+
+// webhookAttempt records a webhook delivery attempt in the delivery log.
+func (s *server) webhookAttempt(ctx context.Context, attempt webhook.Attempt) {
+	delivery := db.WebhookDelivery{
+		ScheduleId: attempt.ScheduleId,
+		URL:        attempt.URL,
+		EventId:    attempt.EventId,
+		EventType:  attempt.EventType,
+		Attempt:    attempt.Attempt,
+		Status:     attempt.Status,
+		Date:       attempt.Date,
+	}
+	if attempt.Err != nil {
+		delivery.Error = attempt.Err.Error()
+	}
+
+	if err := s.db.AddWebhookDelivery(ctx, delivery); err != nil {
+		slog.ErrorContext(ctx, "error recording webhook delivery", "error", err)
+	}
+}
+
+func feedChangedData(changes []db.EventChange) structs.WebhookFeedChanged {
+	data := structs.WebhookFeedChanged{Changes: make([]structs.EventChange, 0, len(changes))}
+	for _, change := range changes {
+		data.Changes = append(data.Changes, structs.EventChange{
+			EventId: change.EventId,
+			Title:   change.Title,
+			Kind:    change.Kind,
+			Old:     change.Old,
+			New:     change.New,
+			Date:    change.Date.Format(time.RFC3339Nano),
+		})
+	}
+	return data
+}
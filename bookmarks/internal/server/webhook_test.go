@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"bookmarks/internal/config"
+	"bookmarks/internal/server"
+	"bookmarks/internal/structs"
+	"bookmarks/internal/webhook"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+const WEBHOOK_SECRET = "test-webhook-secret"
+
+// receivedEvent is a webhook event as received, with its data undecoded.
+type receivedEvent struct {
+	Type       string          `json:"type"`
+	ScheduleId string          `json:"scheduleId"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// webhookReceiver is a stand-in webhook endpoint. It records the events of
+// requests with a valid signature, and fails the test for any other request.
+type webhookReceiver struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	events []receivedEvent
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	r := &webhookReceiver{}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		signature := webhook.Sign(WEBHOOK_SECRET, req.Header.Get(webhook.TIMESTAMP_HEADER), body)
+		if req.Header.Get(webhook.SIGNATURE_HEADER) != signature {
+			t.Errorf("unexpected signature %q", req.Header.Get(webhook.SIGNATURE_HEADER))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event receivedEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("error decoding webhook event: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		r.lock.Lock()
+		r.events = append(r.events, event)
+		r.lock.Unlock()
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// received returns the data of the events of the type received so far.
+func (r *webhookReceiver) received(eventType string) []json.RawMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var res []json.RawMessage
+	for _, event := range r.events {
+		if event.Type == eventType && event.ScheduleId == SCHEDULE_ID {
+			res = append(res, event.Data)
+		}
+	}
+	return res
+}
+
+// newWebhookServer starts a server that sends the schedule's events to the
+// receiver, with the count thresholds.
+func newWebhookServer(t *testing.T, receiver *webhookReceiver, thresholds []int, opts ...server.Option) *testServer {
+	return newConfiguredServer(t, func(cfg *config.Config) {
+		cfg.Schedules = map[string]config.ScheduleSettings{SCHEDULE_ID: {
+			Webhooks:        []config.WebhookSettings{{URL: receiver.server.URL, Secret: WEBHOOK_SECRET}},
+			CountThresholds: thresholds,
+		}}
+	}, opts...)
+}
+
+func TestSelectionSavedWebhook(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	srv := newWebhookServer(t, receiver, nil)
+	client := newClient(t)
+	srv.setup(t, client)
+
+	srv.setBookmarks(t, client, "e1", "e2")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.SELECTION_SAVED)) == 1
+	})
+
+	srv.setBookmarks(t, client, "e2", "e3", "unknown")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.SELECTION_SAVED)) == 2
+	})
+
+	cases := []structs.WebhookSelectionSaved{
+		{Events: []string{"e1", "e2"}, Added: []string{"e1", "e2"}, Removed: []string{}},
+		{Events: []string{"e2", "e3"}, Added: []string{"e3"}, Removed: []string{"e1"}},
+	}
+	for i, data := range receiver.received(webhook.SELECTION_SAVED) {
+		var saved structs.WebhookSelectionSaved
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(saved.Events)
+		slices.Sort(saved.Added)
+
+		expected := cases[i]
+		if !slices.Equal(saved.Events, expected.Events) || !slices.Equal(saved.Added, expected.Added) ||
+			!slices.Equal(saved.Removed, expected.Removed) {
+			t.Fatalf("expected %+v, got %+v", expected, saved)
+		}
+	}
+}
+
+func TestCountThresholdWebhook(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	srv := newWebhookServer(t, receiver, []int{2})
+
+	first := newClient(t)
+	srv.setup(t, first)
+	srv.setBookmarks(t, first, "e1")
+
+	second := newClient(t)
+	srv.setup(t, second)
+	srv.setBookmarks(t, second, "e1", "e2")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.COUNT_THRESHOLD)) == 1
+	})
+
+	// the threshold is recorded, so reaching it again does not send it again
+	srv.setBookmarks(t, second, "e2")
+	srv.setBookmarks(t, second, "e1", "e2")
+	third := newClient(t)
+	srv.setup(t, third)
+	srv.setBookmarks(t, third, "e1")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.SELECTION_SAVED)) == 5
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	received := receiver.received(webhook.COUNT_THRESHOLD)
+	if len(received) != 1 {
+		t.Fatalf("expected the threshold to be sent once, got %d", len(received))
+	}
+	var crossed structs.WebhookCountThreshold
+	if err := json.Unmarshal(received[0], &crossed); err != nil {
+		t.Fatal(err)
+	}
+	if crossed != (structs.WebhookCountThreshold{EventId: "e1", Threshold: 2, Count: 2}) {
+		t.Fatalf("unexpected threshold event %+v", crossed)
+	}
+}
+
+func TestSelectionSavedWebhookAliases(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	srv := newWebhookServer(t, receiver, []int{1}, server.WithRefreshInterval(0))
+	client := newClient(t)
+	srv.setup(t, client)
+
+	srv.setBookmarks(t, client, "e1")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.SELECTION_SAVED)) == 1 && len(receiver.received(webhook.COUNT_THRESHOLD)) == 1
+	})
+
+	// e1 is renamed, so the stored selection has its previous ID
+	srv.source.SetEvents(SCHEDULE_ID, []structs.Event{
+		{Id: "renamed", Title: "One", PreviousIds: []string{"e1"}},
+		{Id: "e2", Title: "Two"},
+	})
+	srv.setBookmarks(t, client, "renamed", "e2")
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.SELECTION_SAVED)) == 2
+	})
+
+	var saved structs.WebhookSelectionSaved
+	if err := json.Unmarshal(receiver.received(webhook.SELECTION_SAVED)[1], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(saved.Added, []string{"e2"}) || len(saved.Removed) != 0 {
+		t.Fatalf("expected only e2 to be added, got %+v", saved)
+	}
+
+	waitFor(t, func() bool {
+		return len(receiver.received(webhook.COUNT_THRESHOLD)) >= 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	if received := receiver.received(webhook.COUNT_THRESHOLD); len(received) != 2 {
+		t.Fatalf("expected thresholds only for e1 and e2, got %d", len(received))
+	}
+}
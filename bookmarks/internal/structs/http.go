@@ -1,5 +1,9 @@
 package structs
 
+import (
+	"encoding/json"
+)
+
 type BookmarksRequest struct {
 	Events []string `json:"events"`
 }
@@ -16,11 +20,14 @@ type SessionBookmarksResponse struct {
 	Id     string   `json:"id"`
 	Date   string   `json:"date"`
 	Events []string `json:"events"`
+	// Code is a share code for the events, if the events feed is available.
+	Code string `json:"code,omitempty"`
 }
 
 type BookmarksResponse struct {
 	Id     string   `json:"id"`
 	Events []string `json:"events"`
+	Code   string   `json:"code,omitempty"`
 }
 
 type BookmarkCountResponse struct {
@@ -28,11 +35,193 @@ type BookmarkCountResponse struct {
 }
 
 type EventsResponse struct {
-	Events []struct {
-		Id string `json:"id"`
-	} `json:"events"`
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	Id          string   `json:"id"`
+	Title       string   `json:"title,omitempty"`
+	Start       string   `json:"start,omitempty"`
+	End         string   `json:"end,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+	PreviousIds []string `json:"previousIds,omitempty"`
 }
 
 type EventSelectionCountsResponse struct {
 	Counts map[string]int `json:"counts"`
 }
+
+// AdminCountsResponse is a schedule's exact counts, and for each event with
+// check-ins, how many of the sessions that bookmarked it attended.
+type AdminCountsResponse struct {
+	Counts     map[string]int             `json:"counts"`
+	Attendance map[string]EventAttendance `json:"attendance"`
+}
+
+// EventAttendance compares an event's check-ins with its bookmarks. Ratio is
+// Attended divided by Bookmarked.
+type EventAttendance struct {
+	Bookmarked int     `json:"bookmarked"`
+	Attended   int     `json:"attended"`
+	Ratio      float64 `json:"ratio"`
+}
+
+type RemovedEvent struct {
+	Id       string `json:"id"`
+	Title    string `json:"title,omitempty"`
+	Start    string `json:"start,omitempty"`
+	End      string `json:"end,omitempty"`
+	Location string `json:"location,omitempty"`
+	Date     string `json:"date"`
+}
+
+type RemovedEventsResponse struct {
+	Events []RemovedEvent `json:"events"`
+}
+
+// ScheduleConfig is the subset of a schedule's config.json used by the
+// service.
+type ScheduleConfig struct {
+	TimeZone string     `json:"timeZone,omitempty"`
+	Events   eventOrURL `json:"events"`
+}
+
+type eventOrURL struct {
+	URL    string
+	Events []Event
+}
+
+func (e *eventOrURL) UnmarshalJSON(data []byte) error {
+	var asStr string
+	err := json.Unmarshal(data, &asStr)
+	if err == nil {
+		e.URL = asStr
+		return nil
+	}
+
+	var asEvents []Event
+	err = json.Unmarshal(data, &asEvents)
+	if err == nil {
+		e.Events = asEvents
+		return nil
+	}
+
+	return err
+}
+
+type EventChange struct {
+	EventId string `json:"eventId"`
+	Title   string `json:"title,omitempty"`
+	Kind    string `json:"kind"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+	Date    string `json:"date"`
+}
+
+type EventChangesResponse struct {
+	Changes []EventChange `json:"changes"`
+}
+
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+type PushSubscriptionRequest struct {
+	Subscription    PushSubscription `json:"subscription"`
+	ReminderMinutes int              `json:"reminderMinutes"`
+	ChangeAlerts    bool             `json:"changeAlerts"`
+}
+
+type PushSubscriptionResponse struct {
+	Endpoint        string `json:"endpoint"`
+	ReminderMinutes int    `json:"reminderMinutes"`
+	ChangeAlerts    bool   `json:"changeAlerts"`
+}
+
+type PushUnsubscribeRequest struct {
+	Endpoint string `json:"endpoint"`
+}
+
+type PushKeyResponse struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// PushMessage is the payload of a push notification.
+type PushMessage struct {
+	Type       string `json:"type"`
+	ScheduleId string `json:"scheduleId"`
+	EventId    string `json:"eventId"`
+	Title      string `json:"title,omitempty"`
+	Start      string `json:"start,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Old        string `json:"old,omitempty"`
+	New        string `json:"new,omitempty"`
+}
+
+// WebhookSelectionSaved is the data of a selection.saved webhook event. It
+// does not identify the session.
+type WebhookSelectionSaved struct {
+	Events  []string `json:"events"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type WebhookCountThreshold struct {
+	EventId   string `json:"eventId"`
+	Threshold int    `json:"threshold"`
+	Count     int    `json:"count"`
+}
+
+type WebhookFeedChanged struct {
+	Changes []EventChange `json:"changes"`
+}
+
+type SessionSchedule struct {
+	ScheduleId string `json:"scheduleId"`
+	Date       string `json:"date"`
+	Count      int    `json:"count"`
+}
+
+type MySchedulesResponse struct {
+	Schedules []SessionSchedule `json:"schedules"`
+}
+
+// ExportResponse is all the data stored about the caller's sessions.
+type ExportResponse struct {
+	Sessions []ExportSession `json:"sessions"`
+}
+
+type ExportSession struct {
+	Id                string                   `json:"id"`
+	Selections        []ExportSelection        `json:"selections"`
+	History           []ExportSelection        `json:"history"`
+	PushSubscriptions []ExportPushSubscription `json:"pushSubscriptions"`
+	Attendance        []ExportAttendance       `json:"attendance"`
+	LinkedIds         []string                 `json:"linkedIds"`
+}
+
+type ExportSelection struct {
+	ScheduleId string   `json:"scheduleId"`
+	Id         string   `json:"id"`
+	Date       string   `json:"date"`
+	Events     []string `json:"events"`
+}
+
+type ExportPushSubscription struct {
+	ScheduleId      string `json:"scheduleId"`
+	Endpoint        string `json:"endpoint"`
+	ReminderMinutes int    `json:"reminderMinutes"`
+	ChangeAlerts    bool   `json:"changeAlerts"`
+}
+
+type ExportAttendance struct {
+	ScheduleId string `json:"scheduleId"`
+	EventId    string `json:"eventId"`
+	Date       string `json:"date"`
+}
@@ -0,0 +1,43 @@
+package structs
+
+// SelectionResponseV2 is a selection of bookmarked events. EventDetails are
+// included with ?expand=events, for the events still in the schedule.
+type SelectionResponseV2 struct {
+	Id           string   `json:"id"`
+	Date         string   `json:"date,omitempty"`
+	Events       []string `json:"events"`
+	Code         string   `json:"code,omitempty"`
+	EventDetails []Event  `json:"eventDetails,omitempty"`
+}
+
+type RemovedEventsResponseV2 struct {
+	Events     []RemovedEvent `json:"events"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+type EventChangesResponseV2 struct {
+	Changes    []EventChange `json:"changes"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// AttendanceResponse is the events the session checked in to, in the order it
+// checked in.
+type AttendanceResponse struct {
+	Attendance []Attendance `json:"attendance"`
+}
+
+type Attendance struct {
+	EventId string `json:"eventId"`
+	Date    string `json:"date"`
+}
+
+// ErrorResponse is the body of every v2 error.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"context"
+	"log/slog"
+)
+
+const MAX_ALIAS_DEPTH = 16
+
+// SetAliases replaces the configured event ID aliases of each schedule.
+func (v *Validator) SetAliases(aliases map[string]map[string]string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.aliases = aliases
+}
+
+// ResolveAliases rewrites old event IDs to their current IDs. Unlike
+// ValidateEvents, unknown IDs are kept, and if the feed is unavailable only
+// the configured aliases are applied.
+func (v *Validator) ResolveAliases(ctx context.Context, scheduleId string, input []string) []string {
+	entry, aliases, ok := v.getEntry(scheduleId)
+	if !ok {
+		return input
+	}
+
+	feed, err := v.getFeed(ctx, entry)
+	if err != nil {
+		slog.WarnContext(ctx, "error fetching events for aliases", "error", err)
+	}
+
+	res := make([]string, 0, len(input))
+	for _, eventId := range input {
+		res = append(res, resolveAlias(eventId, aliases, feed))
+	}
+	return res
+}
+
+// resolveAlias follows configured aliases, then the aliases of events in the
+// feed. A feed alias is not used if the ID still exists in the feed.
+func resolveAlias(eventId string, aliases map[string]string, feed *feed) string {
+	for range MAX_ALIAS_DEPTH {
+		if newId, ok := aliases[eventId]; ok {
+			eventId = newId
+			continue
+		}
+
+		if feed == nil {
+			break
+		}
+
+		if _, ok := feed.ids[eventId]; ok {
+			break
+		}
+
+		newId, ok := feed.aliases[eventId]
+		if !ok {
+			break
+		}
+		eventId = newId
+	}
+	return eventId
+}
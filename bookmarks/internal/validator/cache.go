@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"bookmarks/internal/structs"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedFeed is the last known-good feed of a schedule, as persisted to disk.
+type cachedFeed struct {
+	URL        string               `json:"url"`
+	EventsURL  string               `json:"eventsUrl,omitempty"`
+	TimeZone   string               `json:"timeZone,omitempty"`
+	Conditions map[string]condition `json:"conditions,omitempty"`
+	Date       string               `json:"date"`
+	Events     []structs.Event      `json:"events"`
+	// EventLists are the previous feeds' sorted event IDs.
+	EventLists [][]string `json:"eventLists,omitempty"`
+}
+
+// newEntry creates a schedule entry, loading its last known events from the
+// cache directory. They are used until the feed can be fetched.
+func (v *Validator) newEntry(scheduleId string, url string) *scheduleEntry {
+	entry := &scheduleEntry{id: scheduleId, url: url}
+	if v.cacheDir == "" {
+		return entry
+	}
+
+	data, err := os.ReadFile(v.cachePath(scheduleId))
+	if os.IsNotExist(err) {
+		return entry
+	} else if err != nil {
+		slog.Warn("error reading cached events", "schedule", scheduleId, "error", err)
+		return entry
+	}
+
+	var cached cachedFeed
+	if err := json.Unmarshal(data, &cached); err != nil {
+		slog.Warn("error reading cached events", "schedule", scheduleId, "error", err)
+		return entry
+	}
+
+	if cached.URL != url {
+		return entry
+	}
+
+	entry.feed = newFeed(cached.Events)
+	entry.eventLists = cached.EventLists
+	entry.eventsURL = cached.EventsURL
+	entry.timeZone = cached.TimeZone
+	entry.conditions = cached.Conditions
+
+	return entry
+}
+
+func (v *Validator) saveEntry(ctx context.Context, entry *scheduleEntry, events []structs.Event) {
+	if v.cacheDir == "" {
+		return
+	}
+
+	entry.lock.RLock()
+	cached := cachedFeed{
+		URL:        entry.url,
+		EventsURL:  entry.eventsURL,
+		TimeZone:   entry.timeZone,
+		Conditions: entry.conditions,
+		Date:       entry.lastUpdate.Format(time.RFC3339),
+		Events:     events,
+		EventLists: entry.eventLists,
+	}
+	entry.lock.RUnlock()
+
+	data, err := json.Marshal(cached)
+	if err != nil {
+		panic(err)
+	}
+
+	tmp, err := os.CreateTemp(v.cacheDir, "."+entry.id+"-*")
+	if err != nil {
+		slog.WarnContext(ctx, "error caching events", "schedule", entry.id, "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), v.cachePath(entry.id))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "error caching events", "schedule", entry.id, "error", err)
+	}
+}
+
+func (v *Validator) cachePath(scheduleId string) string {
+	return filepath.Join(v.cacheDir, scheduleId+".json")
+}
@@ -0,0 +1,70 @@
+package validator
+
+import (
+	"bookmarks/internal/structs"
+)
+
+type ChangeKind string
+
+const (
+	CHANGE_ADDED    ChangeKind = "added"
+	CHANGE_REMOVED  ChangeKind = "removed"
+	CHANGE_TIME     ChangeKind = "time"
+	CHANGE_LOCATION ChangeKind = "location"
+	CHANGE_TITLE    ChangeKind = "title"
+)
+
+// Change is a difference between two fetches of a feed.
+type Change struct {
+	Kind    ChangeKind
+	EventId string
+	// Old is the previous event, unless it was added.
+	Old *structs.Event
+	// New is the current event, unless it was removed.
+	New *structs.Event
+}
+
+// diffFeeds compares two feeds. Events that were renamed, according to the
+// new feed's or the configured aliases, are not reported as removed.
+func diffFeeds(old *feed, new *feed, aliases map[string]string) []Change {
+	changes := make([]Change, 0)
+
+	for i := range old.events {
+		oldEvent := &old.events[i]
+		if idx, ok := new.ids[oldEvent.Id]; ok {
+			changes = append(changes, diffEvents(oldEvent, &new.events[idx])...)
+			continue
+		}
+		if resolveAlias(oldEvent.Id, aliases, new) != oldEvent.Id {
+			continue
+		}
+		changes = append(changes, Change{Kind: CHANGE_REMOVED, EventId: oldEvent.Id, Old: oldEvent})
+	}
+
+	for i := range new.events {
+		newEvent := &new.events[i]
+		if _, ok := old.ids[newEvent.Id]; !ok {
+			changes = append(changes, Change{Kind: CHANGE_ADDED, EventId: newEvent.Id, New: newEvent})
+		}
+	}
+
+	return changes
+}
+
+func diffEvents(old *structs.Event, new *structs.Event) []Change {
+	changes := make([]Change, 0)
+
+	if old.Start != new.Start || old.End != new.End {
+		changes = append(changes, Change{Kind: CHANGE_TIME, EventId: new.Id, Old: old, New: new})
+	}
+
+	if old.Location != new.Location {
+		changes = append(changes, Change{Kind: CHANGE_LOCATION, EventId: new.Id, Old: old, New: new})
+	}
+
+	if old.Title != new.Title {
+		changes = append(changes, Change{Kind: CHANGE_TITLE, EventId: new.Id, Old: old, New: new})
+	}
+
+	return changes
+}
@@ -0,0 +1,281 @@
+package validator
+
+import (
+	"bookmarks/internal/logging"
+	"bookmarks/internal/structs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const FETCH_ATTEMPTS = 3
+const RETRY_DELAY = 500 * time.Millisecond
+const MAX_FEED_SIZE = 16 << 20
+
+var ErrFeedTooLarge = errors.New("events feed too large")
+var ErrNoEvents = errors.New("feed has no events")
+var ErrEventsScheme = errors.New("events URL scheme is not allowed")
+
+// condition holds the values used to conditionally fetch a URL.
+type condition struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// errPermanent marks a fetch error that retrying will not fix.
+type errPermanent struct {
+	err error
+}
+
+func (e errPermanent) Error() string {
+	return e.err.Error()
+}
+
+func (e errPermanent) Unwrap() error {
+	return e.err
+}
+
+// fetch updates the entry's events from its feed, retrying with backoff.
+func (v *Validator) fetch(ctx context.Context, entry *scheduleEntry) error {
+	var err error
+	delay := v.RetryDelay
+
+	for attempt := 1; attempt <= FETCH_ATTEMPTS; attempt++ {
+		err = v.fetchOnce(ctx, entry)
+		if err == nil {
+			return nil
+		}
+
+		var permanent errPermanent
+		if errors.As(err, &permanent) || attempt == FETCH_ATTEMPTS {
+			break
+		}
+
+		slog.DebugContext(ctx, "retrying events fetch", "url", entry.url, "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	return err
+}
+
+// fetchOnce fetches the entry's URL, which is either an events JSON or a
+// schedule config.json. If the config's events are a URL, relative to the
+// config's URL, those are fetched too.
+func (v *Validator) fetchOnce(ctx context.Context, entry *scheduleEntry) error {
+	entry.lock.RLock()
+	hasEvents := entry.feed != nil
+	eventsURL := entry.eventsURL
+	timeZone := entry.timeZone
+	prevConditions := entry.conditions
+	entry.lock.RUnlock()
+
+	conditions := make(map[string]condition)
+	var events []structs.Event
+
+	body, cond, err := v.fetchDocument(ctx, entry.url, prevConditions[entry.url], hasEvents)
+	if err != nil {
+		return err
+	}
+	conditions[entry.url] = cond
+
+	if body != nil {
+		var source structs.ScheduleConfig
+		if err := json.Unmarshal(body, &source); err != nil {
+			return errPermanent{err}
+		}
+		timeZone = source.TimeZone
+
+		if source.Events.URL != "" {
+			eventsURL, err = resolveURL(entry.url, source.Events.URL)
+			if err != nil {
+				return errPermanent{err}
+			}
+		} else if source.Events.Events != nil {
+			eventsURL = ""
+			events = source.Events.Events
+		} else {
+			return errPermanent{ErrNoEvents}
+		}
+	}
+
+	if eventsURL != "" {
+		body, cond, err := v.fetchDocument(ctx, eventsURL, prevConditions[eventsURL], hasEvents)
+		if err != nil {
+			return err
+		}
+		conditions[eventsURL] = cond
+
+		if body != nil {
+			var resp structs.EventsResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				return errPermanent{err}
+			}
+			if resp.Events == nil {
+				return errPermanent{ErrNoEvents}
+			}
+			events = resp.Events
+		}
+	}
+
+	entry.lock.Lock()
+	prevFeed := entry.feed
+	if events != nil {
+		entry.setFeed(newFeed(events))
+	}
+	curFeed := entry.feed
+	entry.eventsURL = eventsURL
+	entry.timeZone = timeZone
+	entry.conditions = conditions
+	entry.lastUpdate = time.Now()
+	entry.lock.Unlock()
+
+	// a feed loaded from the cache may not have been saved yet
+	v.saveEventList(ctx, entry, curFeed)
+
+	if events != nil {
+		v.saveEntry(ctx, entry, events)
+
+		if prevFeed != nil && v.OnChange != nil {
+			_, aliases, _ := v.getEntry(entry.id)
+			if changes := diffFeeds(prevFeed, curFeed, aliases); len(changes) > 0 {
+				v.OnChange(ctx, entry.id, changes)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchDocument fetches an http(s) or file URL. If conditional is set and the
+// document has not changed, the returned body is nil.
+func (v *Validator) fetchDocument(ctx context.Context, docURL string, prev condition, conditional bool) ([]byte, condition, error) {
+	urlObj, err := url.Parse(docURL)
+	if err != nil {
+		return nil, condition{}, errPermanent{err}
+	}
+
+	if urlObj.Scheme == "file" {
+		return readFile(urlObj.Path, prev, conditional)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", docURL, nil)
+	if err != nil {
+		return nil, condition{}, errPermanent{err}
+	}
+
+	if requestId := logging.RequestID(ctx); requestId != "" {
+		req.Header.Set(logging.REQUEST_ID_HEADER, requestId)
+	}
+
+	if conditional {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	slog.DebugContext(ctx, "fetching events", "url", docURL)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, condition{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		return nil, prev, nil
+	}
+
+	if resp.StatusCode != 200 {
+		err := fmt.Errorf("unexpected http status %d when fetching %s", resp.StatusCode, docURL)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, condition{}, errPermanent{err}
+		}
+		return nil, condition{}, err
+	}
+
+	body, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, condition{}, err
+	}
+
+	cond := condition{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return body, cond, nil
+}
+
+func readFile(path string, prev condition, conditional bool) ([]byte, condition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, condition{}, errPermanent{err}
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, condition{}, errPermanent{err}
+	}
+
+	cond := condition{LastModified: info.ModTime().UTC().Format(time.RFC3339Nano)}
+	if conditional && cond == prev {
+		return nil, prev, nil
+	}
+
+	body, err := readLimited(f)
+	if err != nil {
+		return nil, condition{}, errPermanent{err}
+	}
+	return body, cond, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, MAX_FEED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > MAX_FEED_SIZE {
+		return nil, errPermanent{ErrFeedTooLarge}
+	}
+	return body, nil
+}
+
+// resolveURL resolves a config's events URL against the config's URL. A
+// config fetched over http may only point to http or https URLs, and one
+// fetched over https only to https URLs, so that a remote config cannot read
+// the server's files. Configs read from files may point anywhere.
+func resolveURL(base string, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := baseURL.ResolveReference(refURL)
+	switch {
+	case baseURL.Scheme == "file":
+	case resolved.Scheme == "https":
+	case resolved.Scheme == "http" && baseURL.Scheme == "http":
+	default:
+		return "", fmt.Errorf("%w: %s from %s", ErrEventsScheme, resolved.Scheme, baseURL.Scheme)
+	}
+	return resolved.String(), nil
+}
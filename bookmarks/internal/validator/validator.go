@@ -1,56 +1,183 @@
 package validator
 
 import (
+	"bookmarks/internal/selection"
 	"bookmarks/internal/structs"
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
-
-	"github.com/phuslu/lru"
 )
 
 const CACHE_DURATION = 30 * time.Second
+const STALE_RETRY_DURATION = 10 * time.Second
+const FETCH_TIMEOUT = 10 * time.Second
 
 var ErrNoSchedule = errors.New("no such schedule")
+var ErrUnknownVersion = errors.New("unknown event list version")
 
 type Validator struct {
-	entries map[string]string
-	cache   *lru.TTLCache[string, map[string]struct{}]
+	// RefreshInterval is how long fetched events are used before the feed
+	// is checked again.
+	RefreshInterval time.Duration
+	// RetryDelay is the delay before the first retry of a failed fetch,
+	// doubled for each further attempt.
+	RetryDelay time.Duration
+	// OnChange is called with the differences between a feed and its
+	// previous fetch. The first fetch after a restart is only compared with
+	// the events cached on disk, so without a cache directory, changes made
+	// while the service was stopped are missed.
+	OnChange func(ctx context.Context, scheduleId string, changes []Change)
+	// EventLists, if set, stores each feed's event IDs, so that share codes
+	// made with them can be read after a restart.
+	EventLists EventListStore
+
+	entries  map[string]*scheduleEntry
+	aliases  map[string]map[string]string
+	lock     sync.RWMutex
+	client   *http.Client
+	cacheDir string
+}
+
+// EventListStore stores schedules' sorted event IDs by share code version.
+type EventListStore interface {
+	SaveEventList(ctx context.Context, scheduleId string, version string, eventIds []string) error
+	// GetEventList returns nil if the version is not known.
+	GetEventList(ctx context.Context, scheduleId string, version string) ([]string, error)
 }
 
 type scheduleEntry struct {
-	url        string
-	events     map[string]struct{}
-	lastUpdate time.Time
-	lock       sync.Mutex
+	id        string
+	url       string
+	eventsURL string
+	timeZone  string
+	feed      *feed
+	// eventLists are previous feeds' sorted event IDs, oldest first.
+	eventLists [][]string
+	// savedVersion is the last version saved to the EventLists store.
+	savedVersion string
+	conditions   map[string]condition
+	lastUpdate   time.Time
+	lock         sync.RWMutex
+	fetchLock    sync.Mutex
 }
 
-func NewValidator(urls map[string]string) *Validator {
-	return &Validator{
-		entries: urls,
-		cache:   lru.NewTTLCache(len(urls), lru.WithLoader[string, map[string]struct{}](loadEntries)),
+// NewValidator creates a validator for the schedules' events feeds. If
+// cacheDir is not empty, the last fetched events are persisted there and
+// used if a feed is unavailable.
+func NewValidator(urls map[string]string, cacheDir string) *Validator {
+	v := &Validator{
+		RefreshInterval: CACHE_DURATION,
+		RetryDelay:      RETRY_DELAY,
+		entries:         make(map[string]*scheduleEntry),
+		client:          &http.Client{Timeout: FETCH_TIMEOUT},
+		cacheDir:        cacheDir,
 	}
+	v.SetEntries(urls)
+	return v
 }
 
-func (v *Validator) ValidateEvents(scheduleId string, input []string) ([]string, error) {
-	url, ok := v.entries[scheduleId]
+// SetEntries replaces the schedule URLs. Events already fetched for an
+// unchanged URL are kept.
+func (v *Validator) SetEntries(urls map[string]string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.setEntries(urls)
+}
+
+// SetSchedules replaces the schedule URLs and the aliases together, e.g.
+// after a config reload, so that no request sees one without the other.
+func (v *Validator) SetSchedules(urls map[string]string, aliases map[string]map[string]string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.setEntries(urls)
+	v.aliases = aliases
+}
+
+// setEntries replaces the schedule URLs. The validator must be locked.
+func (v *Validator) setEntries(urls map[string]string) {
+	entries := make(map[string]*scheduleEntry, len(urls))
+	for scheduleId, url := range urls {
+		if cur, ok := v.entries[scheduleId]; ok && cur.url == url {
+			entries[scheduleId] = cur
+		} else {
+			entries[scheduleId] = v.newEntry(scheduleId, url)
+		}
+	}
+	v.entries = entries
+}
+
+// Prewarm fetches every schedule's events in the background.
+func (v *Validator) Prewarm(ctx context.Context) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	for _, entry := range v.entries {
+		go func() {
+			if _, err := v.getFeed(ctx, entry); err != nil {
+				slog.WarnContext(ctx, "error prewarming events", "schedule", entry.id, "error", err)
+			}
+		}()
+	}
+}
+
+// feed is a parsed events feed.
+type feed struct {
+	events []structs.Event
+	ids    map[string]int
+	// aliases maps the aliases and previous IDs of events to their IDs
+	aliases map[string]string
+	// sortedIds are the event IDs in order, and version their share code
+	// version.
+	sortedIds []string
+	version   string
+}
+
+func newFeed(events []structs.Event) *feed {
+	f := &feed{
+		events:    events,
+		ids:       make(map[string]int, len(events)),
+		aliases:   make(map[string]string),
+		sortedIds: make([]string, 0, len(events)),
+	}
+
+	for i, event := range events {
+		if _, ok := f.ids[event.Id]; !ok {
+			f.sortedIds = append(f.sortedIds, event.Id)
+		}
+		f.ids[event.Id] = i
+		for _, alias := range event.Aliases {
+			f.aliases[alias] = event.Id
+		}
+		for _, alias := range event.PreviousIds {
+			f.aliases[alias] = event.Id
+		}
+	}
+
+	slices.Sort(f.sortedIds)
+	f.version = selection.Version(f.sortedIds)
 
+	return f
+}
+
+func (v *Validator) ValidateEvents(ctx context.Context, scheduleId string, input []string) ([]string, error) {
+	entry, aliases, ok := v.getEntry(scheduleId)
 	if !ok {
 		return nil, ErrNoSchedule
 	}
 
-	entries, err, ok := v.cache.GetOrLoad(context.Background(), url, nil)
+	feed, err := v.getFeed(ctx, entry)
 	if err != nil {
 		return nil, err
 	}
 
 	res := make([]string, 0)
 	for _, eventId := range input {
-		if _, ok := entries[eventId]; ok {
+		eventId = resolveAlias(eventId, aliases, feed)
+		if _, ok := feed.ids[eventId]; ok {
 			res = append(res, eventId)
 		}
 	}
@@ -58,30 +185,202 @@ func (v *Validator) ValidateEvents(scheduleId string, input []string) ([]string,
 	return res, nil
 }
 
-func loadEntries(ctx context.Context, url string) (map[string]struct{}, time.Duration, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// Events returns the schedule's current events. The slice must not be
+// modified.
+func (v *Validator) Events(ctx context.Context, scheduleId string) ([]structs.Event, error) {
+	entry, _, ok := v.getEntry(scheduleId)
+	if !ok {
+		return nil, ErrNoSchedule
+	}
+
+	feed, err := v.getFeed(ctx, entry)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	return feed.events, nil
+}
+
+// TimeZone returns the time zone name from the schedule's config.json, or an
+// empty string if the feed does not have one.
+func (v *Validator) TimeZone(ctx context.Context, scheduleId string) (string, error) {
+	entry, _, ok := v.getEntry(scheduleId)
+	if !ok {
+		return "", ErrNoSchedule
+	}
+
+	if _, err := v.getFeed(ctx, entry); err != nil {
+		return "", err
+	}
+
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+	return entry.timeZone, nil
+}
+
+// EventIds returns the schedule's current event IDs in order, as used by share
+// codes. The slice must not be modified.
+func (v *Validator) EventIds(ctx context.Context, scheduleId string) ([]string, error) {
+	entry, _, ok := v.getEntry(scheduleId)
+	if !ok {
+		return nil, ErrNoSchedule
+	}
+
+	feed, err := v.getFeed(ctx, entry)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, 0, fmt.Errorf("unexpected http status %d when fetching %s", resp.StatusCode, url)
+	return feed.sortedIds, nil
+}
+
+// KnownEventIds returns the schedule's current event IDs, and the old IDs
+// that resolve to them through aliases.
+func (v *Validator) KnownEventIds(ctx context.Context, scheduleId string) ([]string, error) {
+	entry, aliases, ok := v.getEntry(scheduleId)
+	if !ok {
+		return nil, ErrNoSchedule
 	}
 
-	var events structs.EventsResponse
-	if err = json.NewDecoder(resp.Body).Decode(&events); err != nil {
-		return nil, 0, err
+	feed, err := v.getFeed(ctx, entry)
+	if err != nil {
+		return nil, err
 	}
 
-	entries := make(map[string]struct{})
-	for _, event := range events.Events {
-		entries[event.Id] = struct{}{}
+	eventIds := slices.Clone(feed.sortedIds)
+	for alias := range feed.aliases {
+		eventIds = append(eventIds, alias)
+	}
+	for alias := range aliases {
+		eventIds = append(eventIds, alias)
+	}
+	return eventIds, nil
+}
+
+// EventIdsVersion returns the schedule's event IDs in order at a share code
+// version, from the current feed, one of the last selection.MAX_EVENT_LISTS,
+// or the EventLists store.
+func (v *Validator) EventIdsVersion(ctx context.Context, scheduleId string, version string) ([]string, error) {
+	entry, _, ok := v.getEntry(scheduleId)
+	if !ok {
+		return nil, ErrNoSchedule
+	}
+
+	feed, err := v.getFeed(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if feed.version == version {
+		return feed.sortedIds, nil
 	}
 
-	return entries, CACHE_DURATION, nil
+	entry.lock.RLock()
+	for _, eventIds := range entry.eventLists {
+		if selection.Version(eventIds) == version {
+			entry.lock.RUnlock()
+			return eventIds, nil
+		}
+	}
+	entry.lock.RUnlock()
+
+	if v.EventLists != nil {
+		eventIds, err := v.EventLists.GetEventList(ctx, scheduleId, version)
+		if err != nil {
+			return nil, err
+		}
+		if eventIds != nil {
+			return eventIds, nil
+		}
+	}
+
+	return nil, ErrUnknownVersion
+}
+
+// saveEventList saves the feed's event IDs to the EventLists store, unless
+// they already were.
+func (v *Validator) saveEventList(ctx context.Context, entry *scheduleEntry, f *feed) {
+	if v.EventLists == nil {
+		return
+	}
+
+	entry.lock.RLock()
+	saved := entry.savedVersion == f.version
+	entry.lock.RUnlock()
+	if saved {
+		return
+	}
+
+	if err := v.EventLists.SaveEventList(ctx, entry.id, f.version, f.sortedIds); err != nil {
+		slog.WarnContext(ctx, "error saving event list", "schedule", entry.id, "error", err)
+		return
+	}
+
+	entry.lock.Lock()
+	entry.savedVersion = f.version
+	entry.lock.Unlock()
+}
+
+func (v *Validator) getEntry(scheduleId string) (*scheduleEntry, map[string]string, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	entry, ok := v.entries[scheduleId]
+	return entry, v.aliases[scheduleId], ok
+}
+
+// getFeed returns the schedule's events, fetching them if they are out of
+// date. If the feed cannot be fetched, the last known events are returned.
+func (v *Validator) getFeed(ctx context.Context, entry *scheduleEntry) (*feed, error) {
+	events, fresh := entry.get(v.RefreshInterval)
+	if fresh {
+		return events, nil
+	}
+
+	// while another request is fetching, use the stale events if there are any
+	if !entry.fetchLock.TryLock() {
+		if events != nil {
+			return events, nil
+		}
+		entry.fetchLock.Lock()
+	}
+	defer entry.fetchLock.Unlock()
+
+	events, fresh = entry.get(v.RefreshInterval)
+	if fresh {
+		return events, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FETCH_TIMEOUT*FETCH_ATTEMPTS)
+	defer cancel()
+
+	err := v.fetch(ctx, entry)
+	if err != nil && events != nil {
+		slog.WarnContext(ctx, "error fetching events, using last known events", "schedule", entry.id, "url", entry.url, "error", err)
+		entry.lock.Lock()
+		entry.lastUpdate = time.Now().Add(STALE_RETRY_DURATION - v.RefreshInterval)
+		entry.lock.Unlock()
+		return events, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	events, _ = entry.get(v.RefreshInterval)
+	return events, nil
+}
+
+// setFeed replaces the entry's feed, keeping the previous event IDs. The entry
+// must be locked.
+func (e *scheduleEntry) setFeed(f *feed) {
+	if e.feed != nil && e.feed.version != f.version {
+		e.eventLists = append(e.eventLists, e.feed.sortedIds)
+		if len(e.eventLists) > selection.MAX_EVENT_LISTS {
+			e.eventLists = e.eventLists[len(e.eventLists)-selection.MAX_EVENT_LISTS:]
+		}
+	}
+	e.feed = f
+}
+
+func (e *scheduleEntry) get(refreshInterval time.Duration) (*feed, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.feed, e.feed != nil && time.Since(e.lastUpdate) < refreshInterval
 }
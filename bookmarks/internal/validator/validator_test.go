@@ -0,0 +1,291 @@
+package validator_test
+
+import (
+	"bookmarks/internal/selection"
+	"bookmarks/internal/validator"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const EVENTS = `{"events": [{"id": "e1"}, {"id": "e2"}]}`
+
+func TestValidateEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(EVENTS))
+	}))
+	defer srv.Close()
+
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, "")
+
+	res, err := v.ValidateEvents(context.Background(), "s1", []string{"e1", "e3", "e2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(res, []string{"e1", "e2"}) {
+		t.Fatalf("expected [e1 e2], got %v", res)
+	}
+
+	if _, err := v.ValidateEvents(context.Background(), "s2", []string{"e1"}); err != validator.ErrNoSchedule {
+		t.Fatalf("expected ErrNoSchedule, got %v", err)
+	}
+}
+
+func TestConditionalFetch(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == "\"v1\"" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("ETag", "\"v1\"")
+		w.Write([]byte(EVENTS))
+	}))
+	defer srv.Close()
+
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, "")
+	v.RefreshInterval = 0
+
+	for range 3 {
+		if _, err := v.ValidateEvents(context.Background(), "s1", []string{"e1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetches.Load() != 1 {
+		t.Fatalf("expected 1 full fetch, got %d", fetches.Load())
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(EVENTS))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, cacheDir)
+	v.RefreshInterval = 0
+	v.RetryDelay = time.Millisecond
+
+	if _, err := v.ValidateEvents(context.Background(), "s1", []string{"e1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	failing.Store(true)
+
+	res, err := v.ValidateEvents(context.Background(), "s1", []string{"e1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res, []string{"e1"}) {
+		t.Fatalf("expected [e1], got %v", res)
+	}
+
+	// a new validator falls back to the events persisted to disk
+	v = validator.NewValidator(map[string]string{"s1": srv.URL}, cacheDir)
+	v.RetryDelay = time.Millisecond
+
+	res, err = v.ValidateEvents(context.Background(), "s1", []string{"e2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res, []string{"e2"}) {
+		t.Fatalf("expected [e2], got %v", res)
+	}
+}
+
+func TestConfigSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schedule/config.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"id": "s1", "events": "/events.json"}`))
+	})
+	mux.HandleFunc("/inline/config.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"id": "s2", "timeZone": "America/New_York", "events": [{"id": "e3"}]}`))
+	})
+	mux.HandleFunc("/events.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(EVENTS))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "events.json"), []byte(EVENTS), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v := validator.NewValidator(map[string]string{
+		"s1": srv.URL + "/schedule/config.json",
+		"s2": srv.URL + "/inline/config.json",
+		"s3": "file://" + path.Join(dir, "events.json"),
+	}, "")
+
+	cases := map[string][]string{
+		"s1": {"e1", "e2"},
+		"s2": {"e3"},
+		"s3": {"e1", "e2"},
+	}
+
+	for scheduleId, expected := range cases {
+		res, err := v.ValidateEvents(context.Background(), scheduleId, []string{"e1", "e2", "e3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(res, expected) {
+			t.Fatalf("expected %v for %s, got %v", expected, scheduleId, res)
+		}
+	}
+
+	if tz, err := v.TimeZone(context.Background(), "s2"); err != nil || tz != "America/New_York" {
+		t.Fatalf("expected time zone America/New_York, got %q (%v)", tz, err)
+	}
+}
+
+func TestConfigSourceScheme(t *testing.T) {
+	dir := t.TempDir()
+	eventsPath := path.Join(dir, "events.json")
+	if err := os.WriteFile(eventsPath, []byte(EVENTS), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configJSON := `{"id": "s1", "events": "file://` + eventsPath + `"}`
+	if err := os.WriteFile(path.Join(dir, "config.json"), []byte(configJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(configJSON))
+	}))
+	defer srv.Close()
+
+	v := validator.NewValidator(map[string]string{
+		"remote": srv.URL + "/config.json",
+		"local":  "file://" + path.Join(dir, "config.json"),
+	}, "")
+
+	if _, err := v.Events(context.Background(), "remote"); !errors.Is(err, validator.ErrEventsScheme) {
+		t.Fatalf("expected a remote config's file URL to be rejected, got %v", err)
+	}
+	if events, err := v.Events(context.Background(), "local"); err != nil || len(events) != 2 {
+		t.Fatalf("expected a local config's file URL to be read, got %v, %v", events, err)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"events": [{"id": "e1", "previousIds": ["old1"]}, {"id": "e2", "aliases": ["e1"]}, {"id": "e3"}]}`))
+	}))
+	defer srv.Close()
+
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, "")
+	v.SetAliases(map[string]map[string]string{"s1": {"old3": "e3"}})
+
+	res, err := v.ValidateEvents(context.Background(), "s1", []string{"old1", "e1", "old3", "old4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(res, []string{"e1", "e1", "e3"}) {
+		t.Fatalf("expected [e1 e1 e3], got %v", res)
+	}
+
+	resolved := v.ResolveAliases(context.Background(), "s1", []string{"old1", "old4"})
+	if !slices.Equal(resolved, []string{"e1", "old4"}) {
+		t.Fatalf("expected [e1 old4], got %v", resolved)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"events": [{"id": "e1", "title": "Event 1"}, {"id": "e2", "location": "Room 1"}, {"id": "e3"}]}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	var changes []validator.Change
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, "")
+	v.RefreshInterval = 0
+	v.OnChange = func(ctx context.Context, scheduleId string, c []validator.Change) {
+		changes = c
+	}
+
+	if _, err := v.Events(context.Background(), "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	body.Store(`{"events": [{"id": "e2", "location": "Room 2"}, {"id": "e4", "previousIds": ["e3"]}]}`)
+
+	if _, err := v.Events(context.Background(), "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changes)
+	}
+
+	if changes[0].Kind != validator.CHANGE_REMOVED || changes[0].EventId != "e1" || changes[0].Old.Title != "Event 1" {
+		t.Fatalf("expected e1 to be removed, got %v", changes[0])
+	}
+
+	if changes[1].Kind != validator.CHANGE_LOCATION || changes[1].New.Location != "Room 2" {
+		t.Fatalf("expected e2 to move, got %v", changes[1])
+	}
+
+	if changes[2].Kind != validator.CHANGE_ADDED || changes[2].EventId != "e4" {
+		t.Fatalf("expected e4 to be added, got %v", changes[2])
+	}
+}
+
+func TestEventIdsVersion(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"events": [{"id": "e2"}, {"id": "e1"}]}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+	v := validator.NewValidator(map[string]string{"s1": srv.URL}, cacheDir)
+	v.RefreshInterval = 0
+
+	oldIds, err := v.EventIds(context.Background(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(oldIds, []string{"e1", "e2"}) {
+		t.Fatalf("expected [e1 e2], got %v", oldIds)
+	}
+	oldVersion := selection.Version(oldIds)
+
+	body.Store(`{"events": [{"id": "e0"}, {"id": "e1"}, {"id": "e2"}]}`)
+	if _, err := v.EventIds(context.Background(), "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous event list is kept, also after a restart
+	restarted := validator.NewValidator(map[string]string{"s1": srv.URL}, cacheDir)
+	for _, v := range []*validator.Validator{v, restarted} {
+		res, err := v.EventIdsVersion(context.Background(), "s1", oldVersion)
+		if err != nil || !slices.Equal(res, oldIds) {
+			t.Fatalf("expected %v, got %v, %v", oldIds, res, err)
+		}
+	}
+
+	if _, err := v.EventIdsVersion(context.Background(), "s1", "00000000"); err != validator.ErrUnknownVersion {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+}
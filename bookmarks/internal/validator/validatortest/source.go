@@ -0,0 +1,85 @@
+// Package validatortest serves fake events feeds for tests.
+package validatortest
+
+import (
+	"bookmarks/internal/structs"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Source serves each schedule's events as an events feed. The events can be
+// changed while it runs.
+type Source struct {
+	server  *httptest.Server
+	lock    sync.Mutex
+	events  map[string][]structs.Event
+	fetches map[string]int
+}
+
+// NewSource starts serving the schedules' events. It is stopped when the
+// test ends.
+func NewSource(t testing.TB, events map[string][]structs.Event) *Source {
+	s := &Source{
+		events:  make(map[string][]structs.Event),
+		fetches: make(map[string]int),
+	}
+	for scheduleId, scheduleEvents := range events {
+		s.SetEvents(scheduleId, scheduleEvents)
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *Source) serve(w http.ResponseWriter, req *http.Request) {
+	scheduleId, ok := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/"), ".json")
+
+	s.lock.Lock()
+	events, exists := s.events[scheduleId]
+	s.fetches[scheduleId]++
+	s.lock.Unlock()
+
+	if !ok || !exists {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(structs.EventsResponse{Events: events})
+}
+
+// SetEvents replaces a schedule's events, or adds the schedule.
+func (s *Source) SetEvents(scheduleId string, events []structs.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events[scheduleId] = append(make([]structs.Event, 0, len(events)), events...)
+}
+
+// URL returns the URL of a schedule's events feed.
+func (s *Source) URL(scheduleId string) string {
+	return s.server.URL + "/" + scheduleId + ".json"
+}
+
+// URLs returns the feed URLs of every schedule, for config.ScheduleURLs.
+func (s *Source) URLs() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	urls := make(map[string]string, len(s.events))
+	for scheduleId := range s.events {
+		urls[scheduleId] = s.URL(scheduleId)
+	}
+	return urls
+}
+
+// Fetches returns how many times a schedule's feed was requested.
+func (s *Source) Fetches(scheduleId string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.fetches[scheduleId]
+}
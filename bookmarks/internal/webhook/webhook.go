@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"bookmarks/internal/logging"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const DELIVERY_ATTEMPTS = 5
+const RETRY_DELAY = time.Second
+const DELIVERY_TIMEOUT = 10 * time.Second
+
+const ID_HEADER = "X-Webhook-Id"
+const TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+const SIGNATURE_HEADER = "X-Webhook-Signature"
+
+// Event types.
+const (
+	SELECTION_SAVED = "selection.saved"
+	COUNT_THRESHOLD = "count.threshold"
+	FEED_CHANGED    = "feed.changed"
+)
+
+var EventTypes = []string{SELECTION_SAVED, COUNT_THRESHOLD, FEED_CHANGED}
+
+// Webhook is a URL that receives a schedule's events.
+type Webhook struct {
+	URL    string
+	Secret string
+	// Events are the event types sent to the webhook, or all if empty.
+	Events []string
+}
+
+// Event is the JSON body of a webhook request.
+type Event struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	ScheduleId string `json:"scheduleId"`
+	Date       string `json:"date"`
+	Data       any    `json:"data"`
+}
+
+// Attempt is the outcome of one delivery attempt.
+type Attempt struct {
+	URL        string
+	EventId    string
+	EventType  string
+	ScheduleId string
+	Attempt    int
+	// Status is the HTTP status of the response, or 0 if there was none.
+	Status int
+	Err    error
+	Date   time.Time
+}
+
+// Dispatcher delivers events to webhooks in the background, retrying failed
+// deliveries with backoff.
+type Dispatcher struct {
+	Client *http.Client
+	// RetryDelay is the delay before the first retry, doubled for each
+	// further attempt.
+	RetryDelay time.Duration
+	// OnAttempt is called after every delivery attempt.
+	OnAttempt func(ctx context.Context, attempt Attempt)
+
+	wg sync.WaitGroup
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:     &http.Client{Timeout: DELIVERY_TIMEOUT},
+		RetryDelay: RETRY_DELAY,
+	}
+}
+
+// Dispatch sends an event to each of the webhooks subscribed to its type.
+func (d *Dispatcher) Dispatch(ctx context.Context, hooks []Webhook, eventType string, scheduleId string, data any) {
+	event := Event{
+		Id:         nanoid.Must(),
+		Type:       eventType,
+		ScheduleId: scheduleId,
+		Date:       time.Now().Format(time.RFC3339Nano),
+		Data:       data,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, eventType) {
+			continue
+		}
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(ctx, hook, event, body)
+		}()
+	}
+}
+
+// Wait waits for pending deliveries, including their retries.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, hook Webhook, event Event, body []byte) {
+	delay := d.RetryDelay
+
+	for attempt := 1; attempt <= DELIVERY_ATTEMPTS; attempt++ {
+		status, err := d.post(ctx, hook, event, body)
+		if d.OnAttempt != nil {
+			d.OnAttempt(ctx, Attempt{
+				URL:        hook.URL,
+				EventId:    event.Id,
+				EventType:  event.Type,
+				ScheduleId: event.ScheduleId,
+				Attempt:    attempt,
+				Status:     status,
+				Err:        err,
+				Date:       time.Now(),
+			})
+		}
+
+		if err == nil {
+			slog.DebugContext(ctx, "delivered webhook", "schedule", event.ScheduleId, "type", event.Type, "attempt", attempt)
+			return
+		}
+
+		// client errors other than rate limiting will not succeed on retry
+		if status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+			break
+		}
+
+		if attempt < DELIVERY_ATTEMPTS {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	slog.WarnContext(ctx, "webhook delivery failed", "schedule", event.ScheduleId, "type", event.Type)
+}
+
+func (d *Dispatcher) post(ctx context.Context, hook Webhook, event Event, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ID_HEADER, event.Id)
+	req.Header.Set(TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(SIGNATURE_HEADER, Sign(hook.Secret, timestamp, body))
+	if requestId := logging.RequestID(ctx); requestId != "" {
+		req.Header.Set(logging.REQUEST_ID_HEADER, requestId)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected http status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature of a request body: the hex HMAC-SHA256 of the
+// timestamp, a period and the body, keyed with the webhook's secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
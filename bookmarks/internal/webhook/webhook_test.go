@@ -0,0 +1,61 @@
+package webhook_test
+
+import (
+	"bookmarks/internal/webhook"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	var requests atomic.Int32
+	var received webhook.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// fail the first attempt
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		expected := webhook.Sign("secret", req.Header.Get(webhook.TIMESTAMP_HEADER), body)
+		if req.Header.Get(webhook.SIGNATURE_HEADER) != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.Unmarshal(body, &received)
+	}))
+	defer srv.Close()
+
+	var lock sync.Mutex
+	var attempts []webhook.Attempt
+	d := webhook.NewDispatcher()
+	d.RetryDelay = time.Millisecond
+	d.OnAttempt = func(ctx context.Context, attempt webhook.Attempt) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, attempt)
+	}
+
+	hooks := []webhook.Webhook{
+		{URL: srv.URL, Secret: "secret"},
+		{URL: srv.URL, Secret: "secret", Events: []string{webhook.COUNT_THRESHOLD}},
+	}
+	d.Dispatch(context.Background(), hooks, webhook.FEED_CHANGED, "s1", map[string]string{"key": "value"})
+	d.Wait()
+
+	if len(attempts) != 2 || attempts[0].Status != http.StatusServiceUnavailable || attempts[1].Err != nil {
+		t.Fatalf("expected a failed attempt then a delivery, got %v", attempts)
+	}
+
+	if received.Type != webhook.FEED_CHANGED || received.ScheduleId != "s1" || received.Id != attempts[1].EventId {
+		t.Fatalf("unexpected event %v", received)
+	}
+}
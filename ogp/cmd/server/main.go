@@ -4,6 +4,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"oembed/internal/logging"
 	"oembed/internal/server"
 	"strings"
 )
@@ -40,12 +41,18 @@ func (es *SchedulePathSlice) Set(val string) error {
 
 func main() {
 	var port int
+	var logLevel string
 	var eventPathSlice SchedulePathSlice
 	flag.IntVar(&port, "port", 8001, "the port to listen on")
 	flag.Var(&eventPathSlice, "path", "map a schedule ID to a path where the files are located")
+	flag.StringVar(&logLevel, "log-level", "info", "the log level (debug, info, warn, error)")
 
 	flag.Parse()
 
+	if err := logging.Setup(logLevel); err != nil {
+		panic(err)
+	}
+
 	pathMap := make(map[string]string)
 
 	for _, ep := range eventPathSlice {
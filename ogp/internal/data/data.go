@@ -1,9 +1,13 @@
 package data
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"oembed/internal/logging"
 	"oembed/internal/structs"
 	"os"
 	"path"
@@ -17,7 +21,7 @@ type Data struct {
 	Events []structs.Event
 }
 
-func LoadData(baseDir string) (*Data, error) {
+func LoadData(ctx context.Context, baseDir string) (*Data, error) {
 	indexPath := path.Join(baseDir, "index.html")
 	configPath := path.Join(baseDir, "config.json")
 
@@ -46,7 +50,7 @@ func LoadData(baseDir string) (*Data, error) {
 	var events []structs.Event
 
 	if configObj.Events.URL != "" {
-		events, err = loadEvents(configObj.Events.URL)
+		events, err = loadEvents(ctx, configObj.Events.URL)
 		if err != nil {
 			return nil, err
 		}
@@ -57,12 +61,27 @@ func LoadData(baseDir string) (*Data, error) {
 	return &Data{HTML: parsed, Config: &configObj, Events: events}, nil
 }
 
-func loadEvents(url string) ([]structs.Event, error) {
-	res, err := http.Get(url)
+func loadEvents(ctx context.Context, url string) ([]structs.Event, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if requestId := logging.RequestID(ctx); requestId != "" {
+		req.Header.Set(logging.REQUEST_ID_HEADER, requestId)
+	}
+
+	slog.DebugContext(ctx, "fetching events", "url", url)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected http status %d when fetching %s", res.StatusCode, url)
+	}
+
 	bodyData, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
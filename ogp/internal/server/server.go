@@ -3,9 +3,10 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"oembed/internal/data"
+	"oembed/internal/logging"
 	"oembed/internal/ogp"
 	"oembed/internal/structs"
 	"time"
@@ -15,13 +16,19 @@ import (
 )
 
 const cacheDuration = 1 * time.Minute
+const loadTimeout = 30 * time.Second
 
 func RunServer(port int, schedulePaths map[string]string) {
 	cache := lru.NewTTLCache[string, *data.Data](32)
 
 	loadCachedData := func(ctx context.Context, path string) (*data.Data, error) {
 		evData, err, _ := cache.GetOrLoad(ctx, path, func(ctx context.Context, path string) (*data.Data, time.Duration, error) {
-			evData, err := data.LoadData(path)
+			// other requests may be waiting for the same load, so it
+			// must not be canceled if this request is
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+			defer cancel()
+
+			evData, err := data.LoadData(ctx, path)
 			if err != nil {
 				return nil, 0, err
 			}
@@ -35,24 +42,27 @@ func RunServer(port int, schedulePaths map[string]string) {
 		eventId := req.PathValue("eventId")
 		origURI := req.Header.Get("X-Original-URI")
 		defaultImg := req.Header.Get("X-Default-Image")
+		ctx := req.Context()
+		logging.AddAttrs(ctx, slog.String("schedule", scheduleId), slog.String("event", eventId))
+
 		path, ok := schedulePaths[scheduleId]
 		if !ok {
 			http.NotFound(w, req)
-			log.Printf("no schedule with id %s", scheduleId)
+			slog.InfoContext(ctx, "no such schedule")
 			return
 		}
 
-		evData, err := loadCachedData(req.Context(), path)
+		evData, err := loadCachedData(ctx, path)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Printf("error loading data for %s: %s", eventId, err)
+			slog.ErrorContext(ctx, "error loading data", "error", err)
 			return
 		}
 
 		event := getEvent(evData.Events, eventId)
 		if event == nil {
 			http.NotFound(w, req)
-			log.Printf("no event for schedule %s with id %s", scheduleId, eventId)
+			slog.InfoContext(ctx, "no such event")
 			return
 		}
 
@@ -65,7 +75,7 @@ func RunServer(port int, schedulePaths map[string]string) {
 		err = html.Render(w, htmlDoc)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Printf("error rendering html: %s", err)
+			slog.ErrorContext(ctx, "error rendering html", "error", err)
 			return
 		}
 	}
@@ -74,8 +84,8 @@ func RunServer(port int, schedulePaths map[string]string) {
 	mux.HandleFunc("GET /schedule-ogp/{scheduleId}/{eventId}", handler)
 	mux.HandleFunc("GET /schedule-ogp/{scheduleId}/{eventId}/", handler)
 
-	log.Printf("listening on %d", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	slog.Info("server starting", "port", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), logging.Middleware(mux))
 	if err != nil {
 		panic(err)
 	}